@@ -0,0 +1,210 @@
+// Package ack holds acknowledgement rules for known and accepted drift.
+package ack
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jukie/atlantis-drift-detection/internal/glob"
+)
+
+// ErrNotFound is returned when removing a rule that does not exist.
+var ErrNotFound = errors.New("acknowledgement not found")
+
+// ErrReadOnly is returned when removing a rule that comes from the config file.
+var ErrReadOnly = errors.New("acknowledgement is defined in the config file")
+
+// Rule acknowledges drift of a project, or of single resources within it,
+// until it expires. Repo, Project and Resource are glob patterns.
+type Rule struct {
+	ID       string    `yaml:"id" json:"id"`
+	Repo     string    `yaml:"repo" json:"repo"`
+	Project  string    `yaml:"project" json:"project"`
+	Resource string    `yaml:"resource" json:"resource,omitempty"`
+	Expires  time.Time `yaml:"expires" json:"expires"`
+	Reason   string    `yaml:"reason" json:"reason"`
+	// Source is "config" for rules from the config file and "api" for rules
+	// created through the HTTP API.
+	Source string `yaml:"-" json:"source"`
+}
+
+// Validate checks that the rule is complete.
+func (r Rule) Validate() error {
+	if r.Repo == "" || r.Project == "" {
+		return fmt.Errorf("repo and project are required")
+	}
+	if r.Reason == "" {
+		return fmt.Errorf("reason is required")
+	}
+	if r.Expires.IsZero() {
+		return fmt.Errorf("expires is required")
+	}
+	for _, pattern := range []string{r.Repo, r.Project} {
+		if err := glob.Validate(pattern); err != nil {
+			return err
+		}
+	}
+	return glob.ValidateAddress(r.Resource)
+}
+
+// Active reports whether the rule has not expired at now.
+func (r Rule) Active(now time.Time) bool {
+	return now.Before(r.Expires)
+}
+
+// Config is the acknowledgements section of the config file.
+type Config struct {
+	Rules []Rule `yaml:"rules"`
+	// File keeps the rules created through the HTTP API across restarts.
+	File string `yaml:"file"`
+}
+
+// Set is the combination of config and API rules. It is safe for concurrent
+// use.
+type Set struct {
+	mu     sync.RWMutex
+	config []Rule
+	api    []Rule
+	file   string
+}
+
+// NewSet returns the rules of cfg together with the API rules stored in
+// cfg.File, if it exists.
+func NewSet(cfg Config) (*Set, error) {
+	s := &Set{file: cfg.File}
+	for i, r := range cfg.Rules {
+		if r.ID == "" {
+			r.ID = fmt.Sprintf("config-%d", i)
+		}
+		r.Source = "config"
+		s.config = append(s.config, r)
+	}
+	if cfg.File == "" {
+		return s, nil
+	}
+	b, err := os.ReadFile(cfg.File)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading acknowledgements: %w", err)
+	}
+	if err := json.Unmarshal(b, &s.api); err != nil {
+		return nil, fmt.Errorf("parsing acknowledgements file %s: %w", cfg.File, err)
+	}
+	return s, nil
+}
+
+// Rules returns every rule, including expired ones.
+func (s *Set) Rules() []Rule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append(append([]Rule{}, s.config...), s.api...)
+}
+
+// Add validates and stores a new API rule.
+func (s *Set) Add(r Rule) (Rule, error) {
+	if err := r.Validate(); err != nil {
+		return r, err
+	}
+	r.ID = newID()
+	r.Source = "api"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	api := append(append([]Rule{}, s.api...), r)
+	if err := s.persist(api); err != nil {
+		return r, err
+	}
+	s.api = api
+	return r, nil
+}
+
+// Remove deletes an API rule.
+func (s *Set) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.config {
+		if r.ID == id {
+			return ErrReadOnly
+		}
+	}
+	for i, r := range s.api {
+		if r.ID == id {
+			api := append(append([]Rule{}, s.api[:i]...), s.api[i+1:]...)
+			if err := s.persist(api); err != nil {
+				return err
+			}
+			s.api = api
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (s *Set) persist(api []Rule) error {
+	if s.file == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(api, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.file + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return fmt.Errorf("writing acknowledgements: %w", err)
+	}
+	return os.Rename(tmp, s.file)
+}
+
+// Match returns the active rule acknowledging the drift of a project. A rule
+// without a resource covers the whole project; otherwise every changed
+// resource address must be covered by some rule. With no addresses only
+// project-wide rules apply.
+func (s *Set) Match(repo, project string, addresses []string, now time.Time) (*Rule, bool) {
+	var resourceRules []Rule
+	for _, r := range s.Rules() {
+		if !r.Active(now) || !glob.Match(r.Repo, repo) || !glob.Match(r.Project, project) {
+			continue
+		}
+		if r.Resource == "" {
+			return &r, true
+		}
+		resourceRules = append(resourceRules, r)
+	}
+	if len(resourceRules) == 0 || len(addresses) == 0 {
+		return nil, false
+	}
+
+	var first *Rule
+	for _, address := range addresses {
+		covered := false
+		for i, r := range resourceRules {
+			if glob.MatchAddress(r.Resource, address) {
+				covered = true
+				if first == nil {
+					first = &resourceRules[i]
+				}
+				break
+			}
+		}
+		if !covered {
+			return nil, false
+		}
+	}
+	return first, true
+}
+
+func newID() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
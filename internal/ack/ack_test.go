@@ -0,0 +1,72 @@
+package ack_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jukie/atlantis-drift-detection/internal/ack"
+	"github.com/stretchr/testify/assert"
+)
+
+var now = time.Date(2023, 5, 1, 2, 0, 0, 0, time.UTC)
+
+func TestMatch(t *testing.T) {
+	set, err := ack.NewSet(ack.Config{Rules: []ack.Rule{
+		{Repo: "owner/*", Project: "network", Expires: now.Add(time.Hour), Reason: "migration"},
+		{Repo: "owner/repo", Project: "compute", Resource: "aws_autoscaling_group.*", Expires: now.Add(time.Hour), Reason: "INC-123"},
+		{Repo: "owner/repo", Project: "compute", Resource: "aws_instance.web[0]", Expires: now.Add(time.Hour), Reason: "INC-124"},
+		{Repo: "owner/repo", Project: "expired", Expires: now.Add(-time.Hour), Reason: "old"},
+	}})
+	assert.NoError(t, err)
+
+	rule, ok := set.Match("owner/repo", "network", nil, now)
+	assert.True(t, ok)
+	assert.Equal(t, "migration", rule.Reason)
+	assert.Equal(t, "config-0", rule.ID)
+
+	// Every changed resource must be covered by a resource rule.
+	rule, ok = set.Match("owner/repo", "compute", []string{"aws_autoscaling_group.web", "aws_instance.web[0]"}, now)
+	assert.True(t, ok)
+	assert.Equal(t, "INC-123", rule.Reason)
+	_, ok = set.Match("owner/repo", "compute", []string{"aws_autoscaling_group.web", "aws_instance.db"}, now)
+	assert.False(t, ok)
+	_, ok = set.Match("owner/repo", "compute", nil, now)
+	assert.False(t, ok)
+
+	_, ok = set.Match("owner/repo", "expired", nil, now)
+	assert.False(t, ok)
+	_, ok = set.Match("other/repo", "network", nil, now)
+	assert.False(t, ok)
+}
+
+func TestAddRemove(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "acks.json")
+	set, err := ack.NewSet(ack.Config{
+		Rules: []ack.Rule{{Repo: "owner/repo", Project: "network", Expires: now, Reason: "migration"}},
+		File:  file,
+	})
+	assert.NoError(t, err)
+
+	_, err = set.Add(ack.Rule{Repo: "owner/repo", Project: "compute"})
+	assert.ErrorContains(t, err, "reason is required")
+
+	rule, err := set.Add(ack.Rule{Repo: "owner/repo", Project: "compute", Expires: now, Reason: "INC-123"})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, rule.ID)
+	assert.Equal(t, "api", rule.Source)
+
+	// API rules survive a restart.
+	reloaded, err := ack.NewSet(ack.Config{File: file})
+	assert.NoError(t, err)
+	assert.Equal(t, []ack.Rule{rule}, reloaded.Rules())
+
+	assert.ErrorIs(t, set.Remove("config-0"), ack.ErrReadOnly)
+	assert.ErrorIs(t, set.Remove("nope"), ack.ErrNotFound)
+	assert.NoError(t, set.Remove(rule.ID))
+	assert.Len(t, set.Rules(), 1)
+
+	reloaded, err = ack.NewSet(ack.Config{File: file})
+	assert.NoError(t, err)
+	assert.Empty(t, reloaded.Rules())
+}
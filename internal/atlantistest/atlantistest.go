@@ -0,0 +1,292 @@
+// Package atlantistest provides a fake Atlantis server implementing the
+// /api/plan and /api/apply endpoints, for tests and local demos.
+package atlantistest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+)
+
+// NoChangesOutput is the Terraform output of a plan without changes.
+const NoChangesOutput = "No changes. Your infrastructure matches the configuration.\n\nTerraform has compared your real infrastructure against your configuration\nand found no differences, so no changes are needed."
+
+// ApplyOutput is the Terraform output of a successful apply.
+const ApplyOutput = "Apply complete! Resources: 0 added, 1 changed, 0 destroyed."
+
+// PlanRequest is the payload of a POST /api/plan or /api/apply request.
+type PlanRequest struct {
+	Repository string
+	Ref        string
+	Type       string
+	PR         int
+	Paths      []Path
+}
+
+// Path is a project directory and workspace to plan.
+type Path struct {
+	Directory string
+	Workspace string
+}
+
+// Plan scripts the result of planning one project.
+type Plan struct {
+	// Project is the project name reported back to the client.
+	Project string
+	// Output is the Terraform output of a successful plan.
+	Output string
+	// Error fails the plan with this message.
+	Error string
+	// Failure is the additional failure message Atlantis reports next to
+	// Error, such as a failed workflow step.
+	Failure string
+	// Delay holds back the whole response, to simulate a slow plan.
+	Delay time.Duration
+}
+
+// NoChanges is a successful plan without changes.
+func NoChanges(project string) Plan {
+	return Plan{Project: project, Output: NoChangesOutput}
+}
+
+// Drift is a successful plan with the given Terraform output.
+func Drift(project, output string) Plan {
+	return Plan{Project: project, Output: output}
+}
+
+// Failed is a plan that fails with err.
+func Failed(project, err string) Plan {
+	return Plan{Project: project, Error: err}
+}
+
+// FailedWith is a plan that fails with err and the failure message failure.
+func FailedWith(project, err, failure string) Plan {
+	return Plan{Project: project, Error: err, Failure: failure}
+}
+
+// Server is a fake Atlantis server. Directories without a scripted plan
+// have no changes, and applies succeed unless failed with FailApply or locked
+// by another PR with Lock.
+type Server struct {
+	// URL is the base URL of the server, to be used as ATLANTIS_URL.
+	URL string
+	// Token is the expected X-Atlantis-Token header.
+	Token string
+
+	server   *httptest.Server
+	mu       sync.Mutex
+	plans    map[string]Plan
+	requests []PlanRequest
+	headers  []http.Header
+	status   int
+	// applyErrors fail the apply of a directory with the message.
+	applyErrors map[string]string
+	// locks are the locked directories with the PR holding the lock.
+	locks   map[string]int
+	applies []PlanRequest
+}
+
+// NewServer starts a fake Atlantis server accepting token. Close it when
+// done.
+func NewServer(token string) *Server {
+	s := &Server{Token: token, plans: map[string]Plan{}, applyErrors: map[string]string{}, locks: map[string]int{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/plan", s.plan)
+	mux.HandleFunc("/api/apply", s.apply)
+	s.server = httptest.NewServer(mux)
+	s.URL = s.server.URL
+	return s
+}
+
+// Close shuts the server down.
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// SetPlan scripts the result of planning the project in dir.
+func (s *Server) SetPlan(dir string, p Plan) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.plans[dir] = p
+}
+
+// FailApply makes applying the project in dir fail with err.
+func (s *Server) FailApply(dir, err string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.applyErrors[dir] = err
+}
+
+// Lock locks the project in dir for pull, as an `atlantis plan` comment on the
+// PR does. Applies of the project from any other PR fail until the end of the
+// test.
+func (s *Server) Lock(dir string, pull int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.locks[dir] = pull
+}
+
+// Fail makes every following authorized plan or apply request fail with status, for
+// example http.StatusBadGateway. A status of 0 stops failing.
+func (s *Server) Fail(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+}
+
+// Requests returns the authorized plan requests received so far.
+func (s *Server) Requests() []PlanRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]PlanRequest(nil), s.requests...)
+}
+
+// Applies returns the authorized apply requests received so far.
+func (s *Server) Applies() []PlanRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]PlanRequest(nil), s.applies...)
+}
+
+// Headers returns the headers of the requests returned by Requests.
+func (s *Server) Headers() []http.Header {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]http.Header(nil), s.headers...)
+}
+
+type projectResult struct {
+	RepoRelDir   string
+	Workspace    string
+	ProjectName  string
+	Error        interface{}
+	Failure      string
+	PlanSuccess  *planSuccess `json:",omitempty"`
+	ApplySuccess string       `json:",omitempty"`
+}
+
+type planSuccess struct {
+	TerraformOutput string
+	LockURL         string
+	RePlanCmd       string
+	ApplyCmd        string
+	HasDiverged     bool
+}
+
+type planResponse struct {
+	Error          interface{}
+	Failure        string
+	ProjectResults []projectResult
+}
+
+// decode authorizes and decodes a plan or apply request, writing an error
+// response when it fails.
+func (s *Server) decode(w http.ResponseWriter, r *http.Request) (PlanRequest, bool) {
+	var req PlanRequest
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return req, false
+	}
+	if r.Header.Get("X-Atlantis-Token") != s.Token {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return req, false
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+		return req, false
+	}
+	return req, true
+}
+
+func (s *Server) plan(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.decode(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	s.headers = append(s.headers, r.Header.Clone())
+	status := s.status
+	plans := make([]Plan, len(req.Paths))
+	for i, p := range req.Paths {
+		plan, ok := s.plans[p.Directory]
+		if !ok {
+			plan = NoChanges("")
+		}
+		plans[i] = plan
+	}
+	s.mu.Unlock()
+
+	if status != 0 {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	var delay time.Duration
+	resp := planResponse{ProjectResults: []projectResult{}}
+	for i, p := range req.Paths {
+		plan := plans[i]
+		if plan.Delay > delay {
+			delay = plan.Delay
+		}
+		workspace := p.Workspace
+		if workspace == "" {
+			workspace = "default"
+		}
+		result := projectResult{RepoRelDir: p.Directory, Workspace: workspace, ProjectName: plan.Project}
+		if plan.Error != "" {
+			result.Error, result.Failure = plan.Error, plan.Failure
+		} else {
+			result.PlanSuccess = &planSuccess{TerraformOutput: plan.Output}
+		}
+		resp.ProjectResults = append(resp.ProjectResults, result)
+	}
+
+	select {
+	case <-time.After(delay):
+	case <-r.Context().Done():
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) apply(w http.ResponseWriter, r *http.Request) {
+	req, ok := s.decode(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	s.applies = append(s.applies, req)
+	status := s.status
+	resp := planResponse{ProjectResults: []projectResult{}}
+	for _, p := range req.Paths {
+		workspace := p.Workspace
+		if workspace == "" {
+			workspace = "default"
+		}
+		result := projectResult{RepoRelDir: p.Directory, Workspace: workspace, ProjectName: s.plans[p.Directory].Project}
+		if err, ok := s.applyErrors[p.Directory]; ok {
+			result.Error = err
+		} else if pull, ok := s.locks[p.Directory]; ok && pull != req.PR {
+			result.Error = fmt.Sprintf("This project is currently locked by an unapplied plan from pull #%d", pull)
+		} else {
+			result.ApplySuccess = ApplyOutput
+		}
+		resp.ProjectResults = append(resp.ProjectResults, result)
+	}
+	s.mu.Unlock()
+
+	if status != 0 {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
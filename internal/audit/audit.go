@@ -0,0 +1,168 @@
+// Package audit keeps an append-only record of every action the drift
+// detector takes: Atlantis requests, writes to the VCS and acknowledged
+// drift.
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jukie/atlantis-drift-detection/internal/secret"
+)
+
+// Actions recorded in the audit log.
+const (
+	// ActionPlan is a plan request sent to Atlantis.
+	ActionPlan = "plan"
+	// ActionApply is an apply request sent to Atlantis.
+	ActionApply = "apply"
+	// ActionBranch is a drift branch created or reset to the ref.
+	ActionBranch = "branch"
+	// ActionCommit is a drift marker commit pushed to a drift branch.
+	ActionCommit = "commit"
+	// ActionPull is a drift PR opened or updated.
+	ActionPull = "pull"
+	// ActionComment is a comment created on a drift PR.
+	ActionComment = "comment"
+	// ActionReviewRequest is a review of a drift PR requested from code
+	// owners.
+	ActionReviewRequest = "review-request"
+	// ActionStatus is a commit status or check run created on the ref.
+	ActionStatus = "status"
+	// ActionDeleteBranch is a stale drift branch deleted.
+	ActionDeleteBranch = "delete-branch"
+	// ActionAcknowledge is drift covered by an acknowledgement rule.
+	ActionAcknowledge = "acknowledge"
+	// ActionAddAcknowledgement and ActionRemoveAcknowledgement are changes to
+	// the acknowledgement rules through the HTTP API.
+	ActionAddAcknowledgement    = "add-acknowledgement"
+	ActionRemoveAcknowledgement = "remove-acknowledgement"
+)
+
+// Results of an action.
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// Config configures the audit log.
+type Config struct {
+	// File is the JSON lines file events are appended to.
+	File string `yaml:"file"`
+	// Webhook, when set, also receives every event as a JSON POST.
+	Webhook *secret.Source `yaml:"webhook"`
+}
+
+// Event is one action taken by the drift detector.
+type Event struct {
+	Time   time.Time `json:"time"`
+	RunID  string    `json:"runId,omitempty"`
+	Action string    `json:"action"`
+	// DryRun marks actions of dry runs, which only read from the VCS.
+	DryRun bool   `json:"dryRun,omitempty"`
+	Vcs    string `json:"vcs,omitempty"`
+	Repo   string `json:"repo,omitempty"`
+	Ref    string `json:"ref,omitempty"`
+	// Projects are the projects the action is about.
+	Projects []string `json:"projects,omitempty"`
+	Branch   string   `json:"branch,omitempty"`
+	// Commit is the SHA of the commit the action created.
+	Commit  string `json:"commit,omitempty"`
+	PullURL string `json:"pullUrl,omitempty"`
+	// Detail describes the action, such as the body of a comment or the
+	// acknowledgement rule that matched.
+	Detail string `json:"detail,omitempty"`
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// WithResult sets the result of the event from the error of its action,
+// masking any secrets in the error.
+func (e Event) WithResult(err error) Event {
+	e.Result, e.Error = ResultSuccess, ""
+	if err != nil {
+		e.Result, e.Error = ResultFailure, secret.Mask(err.Error())
+	}
+	return e
+}
+
+// Logger records events. Implementations must be safe for concurrent use.
+type Logger interface {
+	Record(e Event) error
+	Close() error
+}
+
+// Log appends events to a file and posts them to an optional webhook.
+type Log struct {
+	mu      sync.Mutex
+	file    *os.File
+	webhook *secret.Source
+	client  *http.Client
+}
+
+// Open opens the audit log configured by cfg, creating its file if needed.
+func Open(cfg Config) (*Log, error) {
+	if cfg.File == "" {
+		return nil, errors.New("audit log file is required")
+	}
+	f, err := os.OpenFile(cfg.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("opening audit log: %w", err)
+	}
+	return &Log{file: f, webhook: cfg.Webhook, client: &http.Client{Timeout: 30 * time.Second}}, nil
+}
+
+// Record appends e to the file and then posts it to the webhook. The event is
+// kept in the file even when the webhook fails.
+func (l *Log) Record(e Event) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	_, err = l.file.Write(append(line, '\n'))
+	l.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("writing audit log: %w", err)
+	}
+	if l.webhook == nil {
+		return nil
+	}
+	if err := l.post(line); err != nil {
+		return fmt.Errorf("audit webhook: %s", secret.Mask(err.Error()))
+	}
+	return nil
+}
+
+func (l *Log) post(payload []byte) error {
+	url, err := l.webhook.Get()
+	if err != nil {
+		return err
+	}
+	resp, err := l.client.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// Close closes the file.
+func (l *Log) Close() error {
+	return l.file.Close()
+}
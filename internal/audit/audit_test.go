@@ -0,0 +1,59 @@
+package audit_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jukie/atlantis-drift-detection/internal/audit"
+	"github.com/jukie/atlantis-drift-detection/internal/secret"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLog(t *testing.T) {
+	var posted []audit.Event
+	status := http.StatusOK
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e audit.Event
+		_ = json.NewDecoder(r.Body).Decode(&e)
+		posted = append(posted, e)
+		w.WriteHeader(status)
+	}))
+	defer hook.Close()
+
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"action":"plan","result":"success"}`+"\n"), 0o600))
+	log, err := audit.Open(audit.Config{File: path, Webhook: secret.Literal(hook.URL + "/hook-secret")})
+	assert.NoError(t, err)
+
+	err = log.Record(audit.Event{RunID: "abc123", Action: audit.ActionComment, Repo: "owner/repo", Ref: "main", PullURL: "https://example.com/pull/1", Result: audit.ResultSuccess})
+	assert.NoError(t, err)
+	// A failing webhook is reported, but the event is in the file.
+	status = http.StatusBadGateway
+	err = log.Record(audit.Event{Action: audit.ActionApply, Result: audit.ResultFailure, Error: "apply failed"})
+	assert.ErrorContains(t, err, "audit webhook: unexpected status 502 Bad Gateway")
+	assert.NotContains(t, err.Error(), "hook-secret")
+	assert.NoError(t, log.Close())
+
+	// Events are appended to what the file held.
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	assert.Len(t, lines, 3)
+	var e audit.Event
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &e))
+	assert.Equal(t, "abc123", e.RunID)
+	assert.Equal(t, audit.ActionComment, e.Action)
+	assert.False(t, e.Time.IsZero())
+
+	assert.Len(t, posted, 2)
+	assert.Equal(t, "https://example.com/pull/1", posted[0].PullURL)
+	assert.Equal(t, e.Time, posted[0].Time)
+
+	_, err = audit.Open(audit.Config{})
+	assert.EqualError(t, err, "audit log file is required")
+}
@@ -0,0 +1,107 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jukie/atlantis-drift-detection/internal/config"
+	"github.com/jukie/atlantis-drift-detection/internal/drift"
+	"github.com/jukie/atlantis-drift-detection/internal/vcs"
+)
+
+// planFiles collects repeated --plan-json project=path flags.
+type planFiles map[string]string
+
+func (p planFiles) String() string {
+	pairs := make([]string, 0, len(p))
+	for name, path := range p {
+		pairs = append(pairs, name+"="+path)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (p planFiles) Set(value string) error {
+	name, path, ok := strings.Cut(value, "=")
+	if !ok || name == "" || path == "" {
+		return fmt.Errorf("expected project=path, got %q", value)
+	}
+	p[name] = path
+	return nil
+}
+
+// read loads every plan file, keyed by project name.
+func (p planFiles) read() (map[string][]byte, error) {
+	plans := make(map[string][]byte, len(p))
+	for name, path := range p {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading JSON plan of project %s: %w", name, err)
+		}
+		plans[name] = b
+	}
+	return plans, nil
+}
+
+func checkCmd(e *env, args []string) int {
+	var tokens tokenFlags
+	fs := e.flagSet("check")
+	tokens.register(fs)
+	ref := fs.String("ref", "", "Check this ref instead of the one in the config file")
+	planJSON := planFiles{}
+	fs.Var(planJSON, "plan-json", "Classify the `project=path` JSON plan (terraform show -json) instead of planning with Atlantis; repeatable")
+	if code := e.parse(fs, args); code >= 0 {
+		return code
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitUsage
+	}
+	name := fs.Arg(0)
+
+	driftCfg, _, targets, err := e.setup(tokens)
+	if err != nil {
+		fmt.Fprintln(e.stderr, err)
+		return exitFailure
+	}
+	if driftCfg.Store != nil {
+		defer driftCfg.Store.Close()
+	}
+	if driftCfg.Audit != nil {
+		defer driftCfg.Audit.Close()
+	}
+
+	var matched []target
+	for _, t := range targets {
+		if t.repo.Name == name && (*ref == "" || t.repo.Ref == *ref) {
+			matched = append(matched, t)
+		}
+	}
+	if len(matched) == 0 && *ref != "" {
+		// Allow checking another ref of a configured repo.
+		for _, t := range targets {
+			if t.repo.Name == name {
+				t.repo.Ref = *ref
+				matched = append(matched, t)
+				break
+			}
+		}
+	}
+	if len(matched) == 0 {
+		fmt.Fprintf(e.stderr, "repo %q is not in the config file %s\n", name, driftCfg.ConfigPath)
+		return exitFailure
+	}
+
+	check := drift.Check
+	if len(planJSON) > 0 {
+		plans, err := planJSON.read()
+		if err != nil {
+			fmt.Fprintln(e.stderr, err)
+			return exitFailure
+		}
+		check = func(client vcs.Client, repo config.Repo, driftCfg config.DriftCfg) (drift.Result, error) {
+			return drift.CheckPlans(client.VcsType(), repo, driftCfg, plans)
+		}
+	}
+	return e.finish(runAll(matched, driftCfg, check), "")
+}
@@ -0,0 +1,114 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jukie/atlantis-drift-detection/internal/config"
+	"github.com/jukie/atlantis-drift-detection/internal/drift"
+	"github.com/jukie/atlantis-drift-detection/internal/logging"
+	"github.com/jukie/atlantis-drift-detection/internal/report"
+)
+
+func cleanupCmd(e *env, args []string) int {
+	var tokens tokenFlags
+	fs := e.flagSet("cleanup")
+	tokens.register(fs)
+	maxAge := fs.Duration("max-age", 0, fmt.Sprintf("Delete drift branches without commits for this long, even with an open PR, overriding cleanup.maxAge (default %s)", config.DefaultBranchMaxAge))
+	dryRun := fs.Bool("dry-run", false, "List the stale drift branches without deleting them")
+	if code := e.parse(fs, args); code >= 0 {
+		return code
+	}
+	if *maxAge < 0 {
+		fmt.Fprintln(e.stderr, "--max-age must not be negative")
+		return exitUsage
+	}
+
+	driftCfg, servers, targets, err := e.setup(tokens)
+	if err != nil {
+		fmt.Fprintln(e.stderr, err)
+		return exitFailure
+	}
+	if driftCfg.Store != nil {
+		defer driftCfg.Store.Close()
+	}
+	if driftCfg.Audit != nil {
+		defer driftCfg.Audit.Close()
+	}
+	if *maxAge == 0 {
+		*maxAge = servers.Cleanup.BranchMaxAge()
+	}
+	driftCfg.RunID = drift.NewRunID()
+	driftCfg.DryRun = *dryRun
+
+	results := cleanupAll(targets, *maxAge, driftCfg)
+	if e.global.output == report.FormatJSON {
+		enc := json.NewEncoder(e.stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			fmt.Fprintln(e.stderr, err)
+			return exitFailure
+		}
+	} else {
+		verb := "deleted"
+		if *dryRun {
+			verb = "would delete"
+		}
+		for _, r := range results {
+			for _, b := range r.Deleted {
+				fmt.Fprintf(e.stdout, "%s: %s %s, %s\n", r.Repo, verb, b.Name, b.Reason)
+			}
+			if r.Error != "" {
+				fmt.Fprintf(e.stdout, "%s: %s\n", r.Repo, r.Error)
+			}
+		}
+	}
+	for _, r := range results {
+		if r.Error != "" {
+			return exitFailure
+		}
+	}
+	return exitOK
+}
+
+// cleanupResult lists the drift branches deleted from a repo.
+type cleanupResult struct {
+	Vcs     string                `json:"vcs"`
+	Repo    string                `json:"repo"`
+	Deleted []drift.DeletedBranch `json:"deleted"`
+	Error   string                `json:"error,omitempty"`
+}
+
+// cleanupAll deletes the stale drift branches of every repo once, even when
+// the repo is configured for several refs. A dry run of driftCfg only lists
+// them.
+func cleanupAll(targets []target, maxAge time.Duration, driftCfg config.DriftCfg) []cleanupResult {
+	var results []cleanupResult
+	seen := map[string]bool{}
+	for _, t := range targets {
+		key := t.client.VcsType() + " " + t.repo.Name
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		deleted, err := drift.Cleanup(t.client, t.repo.Name, maxAge, driftCfg)
+		result := cleanupResult{Vcs: t.client.VcsType(), Repo: t.repo.Name, Deleted: deleted}
+		if err != nil {
+			logging.Repo(driftCfg.RunID, t.client.VcsType(), t.repo.Name, "").Error("Cleaning up drift branches failed", logging.Err(err))
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// cleanupAfterRun deletes stale drift branches after the run runID when the
+// config file enables it. Dry runs never delete anything.
+func cleanupAfterRun(servers *config.VcsServers, targets []target, driftCfg config.DriftCfg, runID string) {
+	if servers.Cleanup == nil || !servers.Cleanup.Enabled || driftCfg.DryRun {
+		return
+	}
+	driftCfg.RunID = runID
+	cleanupAll(targets, servers.Cleanup.BranchMaxAge(), driftCfg)
+}
@@ -0,0 +1,202 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/jukie/atlantis-drift-detection/internal/logging"
+	"github.com/jukie/atlantis-drift-detection/internal/report"
+)
+
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+// globalOptions are accepted before the subcommand as well as after it.
+type globalOptions struct {
+	configPath string
+	logLevel   string
+	logFormat  string
+	output     string
+}
+
+func (g *globalOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&g.configPath, "config", os.Getenv("CONFIG_PATH"), "Path to the VCS config file (env CONFIG_PATH)")
+	fs.StringVar(&g.logLevel, "log-level", envOr("LOG_LEVEL", "info"), "Log level: debug, info, warn or error (env LOG_LEVEL)")
+	fs.StringVar(&g.logFormat, "log-format", envOr("LOG_FORMAT", logging.FormatText), "Log format: text or json (env LOG_FORMAT)")
+	fs.StringVar(&g.output, "output", envOr("OUTPUT_FORMAT", report.FormatText), "Output format: "+strings.Join(report.Formats, ", ")+" (env OUTPUT_FORMAT)")
+}
+
+// apply validates the global options and configures logging to w.
+func (g *globalOptions) apply(w io.Writer) error {
+	level, err := logging.ParseLevel(g.logLevel)
+	if err != nil {
+		return err
+	}
+	if err := logging.SetOutput(w, g.logFormat); err != nil {
+		return err
+	}
+	logging.SetLevel(level)
+	return report.ValidateFormat(g.output)
+}
+
+// env is what every subcommand runs with.
+type env struct {
+	global globalOptions
+	stdout io.Writer
+	stderr io.Writer
+}
+
+type command struct {
+	summary string
+	usage   string
+	help    string
+	run     func(e *env, args []string) int
+}
+
+// commands is populated in init because the commands refer back to it for
+// their help text.
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"run": {
+			summary: "Check every configured repo once and open drift PRs",
+			usage:   "run [flags]",
+			help:    "Plans every repo in the config file through Atlantis and opens a PR commenting\n`atlantis plan` for the drifted projects.",
+			run:     runCmd,
+		},
+		"serve": {
+			summary: "Run drift detection periodically as a daemon",
+			usage:   "serve [flags]",
+			help:    "Runs drift detection on a fixed interval and serves health and result\nendpoints over HTTP until interrupted.",
+			run:     serveCmd,
+		},
+		"check": {
+			summary: "Check a single repo and print the results without opening a PR",
+			usage:   "check [flags] <repo>",
+			help:    "Plans one repo from the config file and prints the per-project results.\nNothing is written to the VCS.",
+			run:     checkCmd,
+		},
+		"validate": {
+			summary: "Validate the config file",
+			usage:   "validate [flags]",
+			help:    "Loads the config file strictly and reports every problem with its line\nand column.",
+			run:     validateCmd,
+		},
+		"cleanup": {
+			summary: "Delete stale drift branches",
+			usage:   "cleanup [flags]",
+			help:    "Deletes the drift branches of every configured repo whose PRs are all closed\nor merged, or that have had no commits for longer than --max-age.",
+			run:     cleanupCmd,
+		},
+		"report": {
+			summary: "Render a stored result in the chosen output format",
+			usage:   "report [flags] <result-file>",
+			help:    "Reads a result stored with --result-file and renders it using --output.",
+			run:     reportCmd,
+		},
+	}
+}
+
+// Run executes the CLI with the given arguments (without the program name)
+// and returns the process exit code.
+func Run(args []string, stdout, stderr io.Writer) int {
+	e := &env{stdout: stdout, stderr: stderr}
+
+	fs := flag.NewFlagSet("drifter", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	e.global.register(fs)
+	fs.Usage = func() { usage(fs, stderr) }
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+
+	if fs.NArg() == 0 {
+		usage(fs, stderr)
+		return exitUsage
+	}
+	name := fs.Arg(0)
+	if name == "help" {
+		if fs.NArg() > 1 {
+			return Run([]string{fs.Arg(1), "--help"}, stdout, stderr)
+		}
+		usage(fs, stdout)
+		return exitOK
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n\n", name)
+		usage(fs, stderr)
+		return exitUsage
+	}
+	return cmd.run(e, fs.Args()[1:])
+}
+
+func usage(fs *flag.FlagSet, w io.Writer) {
+	fmt.Fprintln(w, "Usage: drifter [global flags] <command> [flags]")
+	fmt.Fprintln(w, "\nCommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-10s %s\n", name, commands[name].summary)
+	}
+	fmt.Fprintln(w, "\nGlobal flags:")
+	fs.SetOutput(w)
+	fs.PrintDefaults()
+	fmt.Fprintln(w, "\nRun 'drifter <command> --help' for the flags of a command.")
+}
+
+// flagSet returns a flag set for the named subcommand that also accepts the
+// global flags. Parsing it applies the global options.
+func (e *env) flagSet(name string) *flag.FlagSet {
+	cmd := commands[name]
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(e.stderr)
+	// Registering resets the options to their defaults; global flags given
+	// before the subcommand are kept as the starting values instead.
+	global := e.global
+	e.global.register(fs)
+	e.global = global
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: drifter %s\n\n%s\n\nFlags:\n", cmd.usage, cmd.help)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parse parses the subcommand flags and returns the exit code to stop with,
+// or -1 to continue.
+func (e *env) parse(fs *flag.FlagSet, args []string) int {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if err := e.global.apply(e.stderr); err != nil {
+		fmt.Fprintln(e.stderr, err)
+		return exitUsage
+	}
+	return -1
+}
+
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,167 @@
+package cli_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jukie/atlantis-drift-detection/internal/cli"
+	"github.com/jukie/atlantis-drift-detection/internal/report"
+	"github.com/jukie/atlantis-drift-detection/internal/vcstest"
+	"github.com/stretchr/testify/assert"
+)
+
+func run(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := cli.Run(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func TestUsage(t *testing.T) {
+	code, _, stderr := run()
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, "Usage: drifter [global flags] <command> [flags]")
+
+	code, _, stderr = run("bogus")
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, `unknown command "bogus"`)
+}
+
+func TestSubcommandHelp(t *testing.T) {
+	code, _, stderr := run("check", "--help")
+	assert.Equal(t, 0, code)
+	assert.Contains(t, stderr, "Usage: drifter check [flags] <repo>")
+	assert.Contains(t, stderr, "-ref")
+}
+
+func TestValidate(t *testing.T) {
+	valid := writeConfig(t, "github:\n  repos:\n  - name: owner/repo\n    ref: main\n")
+	code, stdout, _ := run("--config", valid, "validate")
+	assert.Equal(t, 0, code)
+	assert.Contains(t, stdout, "is valid")
+
+	invalid := writeConfig(t, "github:\n  repos:\n  - name: repo\n")
+	code, _, stderr := run("validate", "--config", invalid)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, ":3:5: repo ref is required")
+}
+
+func TestInvalidGlobalFlags(t *testing.T) {
+	code, _, stderr := run("validate", "--log-level", "loud")
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, `unknown log level "loud"`)
+
+	code, _, stderr = run("validate", "--log-format", "xml")
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, `unknown log format "xml"`)
+
+	code, _, stderr = run("--output", "yaml", "validate")
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, `unknown output format "yaml"`)
+}
+
+func TestCheck(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/plan", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"ProjectResults": [{"RepoRelDir": ".", "PlanSuccess": {"TerraformOutput": "Plan: 1 to add, 0 to change, 0 to destroy."}, "ProjectName": "project1"}]}`))
+	})
+	mux.HandleFunc("/repos/owner/repo/contents/atlantis.yaml", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	t.Setenv("ATLANTIS_URL", server.URL)
+	t.Setenv("ATLANTIS_TOKEN", "token")
+	t.Setenv("GITHUB_TOKEN", "token")
+	cfg := writeConfig(t, "github:\n  apiEndpoint: "+server.URL+"/\n  repos:\n  - name: owner/repo\n    ref: main\n")
+	resultFile := filepath.Join(t.TempDir(), "result.json")
+
+	code, stdout, _ := run("--config", cfg, "--output", "markdown", "check", "owner/repo")
+	assert.Equal(t, 0, code)
+	assert.Contains(t, stdout, "| project1 | . | drifted |")
+
+	code, _, stderr := run("--config", cfg, "check", "owner/other")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, `repo "owner/other" is not in the config file`)
+
+	// check --output json prints the same format that --result-file stores.
+	code, stdout, _ = run("--config", cfg, "--output", "json", "check", "owner/repo")
+	assert.Equal(t, 0, code)
+	assert.NoError(t, os.WriteFile(resultFile, []byte(stdout), 0644))
+	_, err := report.Load(resultFile)
+	assert.NoError(t, err)
+
+	code, stdout, _ = run("report", "--output", "text", resultFile)
+	assert.Equal(t, 0, code)
+	assert.Contains(t, stdout, "owner/repo")
+	assert.Contains(t, stdout, "drifted")
+}
+
+func TestCheckPlanJSON(t *testing.T) {
+	t.Setenv("ATLANTIS_URL", "http://atlantis.invalid")
+	t.Setenv("ATLANTIS_TOKEN", "token")
+	t.Setenv("GITHUB_TOKEN", "token")
+	cfg := writeConfig(t, "github:\n  repos:\n  - name: owner/repo\n    ref: main\n")
+	plan := filepath.Join(t.TempDir(), "plan.json")
+	assert.NoError(t, os.WriteFile(plan, []byte(`{"format_version":"1.2","resource_changes":[{"address":"aws_instance.web","type":"aws_instance","change":{"actions":["update"]}}]}`), 0644))
+
+	// Atlantis is not called when JSON plans are supplied.
+	code, stdout, _ := run("--config", cfg, "--output", "markdown", "check", "--plan-json", "network="+plan, "owner/repo")
+	assert.Equal(t, 0, code)
+	assert.Contains(t, stdout, "| network |  | pending |")
+
+	code, _, stderr := run("--config", cfg, "check", "--plan-json", plan, "owner/repo")
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, "expected project=path")
+
+	code, _, stderr = run("--config", cfg, "check", "--plan-json", "network=/does/not/exist", "owner/repo")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, "reading JSON plan of project network")
+}
+
+func TestCleanup(t *testing.T) {
+	server := vcstest.NewGithubServer()
+	defer server.Close()
+	repo := server.AddRepo("owner/repo", "main", map[string]string{"atlantis.yaml": "version: 3\n"})
+	server.Push("owner/repo", "atlantis-drift-closed", "Update date.txt", nil)
+	server.Push("owner/repo", "atlantis-drift-open", "Update date.txt", nil)
+	server.Push("owner/repo", "atlantis-drift-stale", "Update date.txt", nil)
+	repo.Pulls = []*vcstest.Pull{
+		{Number: 1, Head: "atlantis-drift-closed", Base: "main", State: vcstest.PullClosed},
+		{Number: 2, Head: "atlantis-drift-open", Base: "main", State: vcstest.PullOpen},
+	}
+
+	t.Setenv("ATLANTIS_URL", "https://atlantis.example.com")
+	t.Setenv("ATLANTIS_TOKEN", "token")
+	t.Setenv("GITHUB_TOKEN", "token")
+	cfg := writeConfig(t, "github:\n  apiEndpoint: "+server.URL+"\n  repos:\n  - name: owner/repo\n    ref: main\n  - name: owner/repo\n    ref: release\n")
+
+	code, stdout, _ := run("--config", cfg, "cleanup", "--dry-run")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "owner/repo: would delete atlantis-drift-closed, its PRs are closed\n", stdout)
+	assert.NotNil(t, repo.Head("atlantis-drift-closed"))
+
+	// Old branches are deleted even when their PR is open.
+	repo.Head("atlantis-drift-open").Date = time.Now().Add(-48 * time.Hour)
+	repo.Head("atlantis-drift-stale").Date = time.Now().Add(-48 * time.Hour)
+	code, stdout, _ = run("--config", cfg, "cleanup", "--max-age", "24h")
+	assert.Equal(t, 0, code)
+	assert.Contains(t, stdout, "owner/repo: deleted atlantis-drift-closed, its PRs are closed\n")
+	assert.Contains(t, stdout, "owner/repo: deleted atlantis-drift-open, no commits since ")
+	assert.Contains(t, stdout, "owner/repo: deleted atlantis-drift-stale, no commits since ")
+	assert.Nil(t, repo.Head("atlantis-drift-closed"))
+	assert.Nil(t, repo.Head("atlantis-drift-open"))
+	assert.Nil(t, repo.Head("atlantis-drift-stale"))
+	assert.NotNil(t, repo.Head("main"))
+}
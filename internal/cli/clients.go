@@ -0,0 +1,154 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jukie/atlantis-drift-detection/internal/ack"
+	"github.com/jukie/atlantis-drift-detection/internal/audit"
+	"github.com/jukie/atlantis-drift-detection/internal/config"
+	"github.com/jukie/atlantis-drift-detection/internal/notify"
+	"github.com/jukie/atlantis-drift-detection/internal/secret"
+	"github.com/jukie/atlantis-drift-detection/internal/state"
+	"github.com/jukie/atlantis-drift-detection/internal/vcs"
+)
+
+// tokenFlags are the VCS credential flags shared by the commands that talk to
+// a VCS.
+type tokenFlags struct {
+	githubToken, githubTokenFile, githubTokenCommand string
+	gitlabToken, gitlabTokenFile, gitlabTokenCommand string
+}
+
+func (t *tokenFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&t.gitlabToken, "gitlab-token", os.Getenv("GITLAB_TOKEN"), "API token for Gitlab")
+	fs.StringVar(&t.githubToken, "github-token", os.Getenv("GITHUB_TOKEN"), "API token for Github")
+	fs.StringVar(&t.gitlabTokenFile, "gitlab-token-file", os.Getenv("GITLAB_TOKEN_FILE"), "Path to a file containing the API token for Gitlab")
+	fs.StringVar(&t.githubTokenFile, "github-token-file", os.Getenv("GITHUB_TOKEN_FILE"), "Path to a file containing the API token for Github")
+	fs.StringVar(&t.gitlabTokenCommand, "gitlab-token-command", os.Getenv("GITLAB_TOKEN_COMMAND"), "Command printing the API token for Gitlab")
+	fs.StringVar(&t.githubTokenCommand, "github-token-command", os.Getenv("GITHUB_TOKEN_COMMAND"), "Command printing the API token for Github")
+}
+
+// target is a repo together with the client for the VCS that hosts it.
+type target struct {
+	client vcs.Client
+	repo   config.Repo
+}
+
+// setup loads the Atlantis settings and the config file, opens the state
+// store and the audit log, and builds a client for every configured VCS
+// server. The caller must close driftCfg.Store and driftCfg.Audit when they
+// are set.
+func (e *env) setup(tokens tokenFlags) (config.DriftCfg, *config.VcsServers, []target, error) {
+	driftCfg, err := config.GetDriftCfg()
+	if err != nil {
+		return driftCfg, nil, nil, err
+	}
+	if e.global.configPath != "" {
+		driftCfg.ConfigPath = e.global.configPath
+	}
+	if driftCfg.ConfigPath == "" {
+		return driftCfg, nil, nil, fmt.Errorf("no config file given, set CONFIG_PATH or pass --config")
+	}
+	servers, err := config.LoadVcsConfig(driftCfg.ConfigPath)
+	if err != nil {
+		return driftCfg, nil, nil, err
+	}
+
+	githubToken := config.SecretFrom(tokens.githubToken, tokens.githubTokenFile, tokens.githubTokenCommand)
+	gitlabToken := config.SecretFrom(tokens.gitlabToken, tokens.gitlabTokenFile, tokens.gitlabTokenCommand)
+	if servers.GithubServer != nil && githubToken == nil {
+		githubToken = servers.GithubServer.Token
+	}
+	if servers.GitlabServer != nil && gitlabToken == nil {
+		gitlabToken = servers.GitlabServer.Token
+	}
+	if err := validateTokens(gitlabToken, githubToken); err != nil {
+		return driftCfg, nil, nil, err
+	}
+
+	var targets []target
+	if servers.GithubServer != nil {
+		ghClient, err := vcs.NewGithubClient(servers.GithubServer.ApiEndpoint, githubToken)
+		if err != nil {
+			return driftCfg, nil, nil, fmt.Errorf("failed to setup github client: %w", err)
+		}
+		if ghClient.Commit, err = commitOptions(servers.GithubServer.Commit); err != nil {
+			return driftCfg, nil, nil, fmt.Errorf("github commit signing: %w", err)
+		}
+		for _, r := range servers.GithubServer.Repos {
+			targets = append(targets, target{client: ghClient, repo: r})
+		}
+	}
+	if servers.GitlabServer != nil {
+		glClient, err := vcs.NewGitlabClient(servers.GitlabServer.ApiEndpoint, gitlabToken)
+		if err != nil {
+			return driftCfg, nil, nil, fmt.Errorf("failed to setup gitlab client: %w", err)
+		}
+		if glClient.Commit, err = commitOptions(servers.GitlabServer.Commit); err != nil {
+			return driftCfg, nil, nil, fmt.Errorf("gitlab commit signing: %w", err)
+		}
+		for _, r := range servers.GitlabServer.Repos {
+			targets = append(targets, target{client: glClient, repo: r})
+		}
+	}
+	if servers.Acknowledgements != nil {
+		acks, err := ack.NewSet(*servers.Acknowledgements)
+		if err != nil {
+			return driftCfg, nil, nil, err
+		}
+		driftCfg.Acknowledgements = acks
+	}
+	if servers.Notifications != nil && len(servers.Notifications.Webhooks) > 0 {
+		driftCfg.Notifier = notify.New(*servers.Notifications)
+	}
+	if servers.State != nil {
+		store, err := state.Open(*servers.State)
+		if err != nil {
+			return driftCfg, nil, nil, err
+		}
+		driftCfg.Store = store
+	}
+	if servers.Audit != nil {
+		log, err := audit.Open(*servers.Audit)
+		if err != nil {
+			if driftCfg.Store != nil {
+				driftCfg.Store.Close()
+			}
+			return driftCfg, nil, nil, err
+		}
+		driftCfg.Audit = log
+	}
+	return driftCfg, servers, targets, nil
+}
+
+// commitOptions resolves the signing key of the commit config once, so that a
+// broken key fails the startup rather than every drift PR.
+func commitOptions(cfg config.CommitConfig) (vcs.CommitOptions, error) {
+	opts := vcs.CommitOptions{Message: cfg.Message, AuthorName: cfg.AuthorName, AuthorEmail: cfg.AuthorEmail}
+	if cfg.Signing == nil {
+		return opts, nil
+	}
+	if cfg.Signing.Format == vcs.SignWeb {
+		opts.WebSigned = true
+		return opts, nil
+	}
+	key, err := cfg.Signing.Key.Get()
+	if err != nil {
+		return opts, err
+	}
+	passphrase, err := cfg.Signing.Passphrase.Get()
+	if err != nil {
+		return opts, err
+	}
+	opts.Signer, err = vcs.NewSigner(cfg.Signing.Format, key, passphrase)
+	return opts, err
+}
+
+func validateTokens(gitlabToken, githubToken *secret.Source) error {
+	if !gitlabToken.IsSet() && !githubToken.IsSet() {
+		return fmt.Errorf("Both GitLab and GitHub tokens are not provided but at least one is required. Set GITLAB_TOKEN or GITHUB_TOKEN environment variables (or their _FILE and _COMMAND variants), pass them using the --gitlab-token and/or --github-token flags, or set a token in the VCS config file.")
+	}
+	return nil
+}
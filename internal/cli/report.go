@@ -0,0 +1,29 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/jukie/atlantis-drift-detection/internal/report"
+)
+
+func reportCmd(e *env, args []string) int {
+	fs := e.flagSet("report")
+	if code := e.parse(fs, args); code >= 0 {
+		return code
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	summary, err := report.Load(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(e.stderr, err)
+		return exitFailure
+	}
+	if err := report.Render(e.stdout, e.global.output, summary); err != nil {
+		fmt.Fprintln(e.stderr, err)
+		return exitFailure
+	}
+	return exitOK
+}
@@ -0,0 +1,81 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jukie/atlantis-drift-detection/internal/config"
+	"github.com/jukie/atlantis-drift-detection/internal/drift"
+	"github.com/jukie/atlantis-drift-detection/internal/logging"
+	"github.com/jukie/atlantis-drift-detection/internal/report"
+	"github.com/jukie/atlantis-drift-detection/internal/vcs"
+)
+
+func runCmd(e *env, args []string) int {
+	var tokens tokenFlags
+	fs := e.flagSet("run")
+	tokens.register(fs)
+	resultFile := fs.String("result-file", "", "Store the result as JSON at this path for the report command")
+	dryRun := fs.Bool("dry-run", false, "Plan and report drift without creating branches, commits, PRs or comments")
+	if code := e.parse(fs, args); code >= 0 {
+		return code
+	}
+
+	driftCfg, servers, targets, err := e.setup(tokens)
+	if err != nil {
+		fmt.Fprintln(e.stderr, err)
+		return exitFailure
+	}
+	driftCfg.DryRun = *dryRun
+	if driftCfg.Store != nil {
+		defer driftCfg.Store.Close()
+	}
+	if driftCfg.Audit != nil {
+		defer driftCfg.Audit.Close()
+	}
+	summary := runAll(targets, driftCfg, drift.Run)
+	cleanupAfterRun(servers, targets, driftCfg, summary.RunID)
+	return e.finish(summary, *resultFile)
+}
+
+// checkFunc checks a single repo, with or without side effects in the VCS.
+type checkFunc func(client vcs.Client, repo config.Repo, driftCfg config.DriftCfg) (drift.Result, error)
+
+// runAll checks every target in order. A failing repo is recorded in the
+// summary and does not stop the others.
+func runAll(targets []target, driftCfg config.DriftCfg, check checkFunc) drift.Summary {
+	summary := drift.Summary{RunID: drift.NewRunID(), StartedAt: time.Now()}
+	driftCfg.RunID = summary.RunID
+	for _, t := range targets {
+		log := logging.Repo(summary.RunID, t.client.VcsType(), t.repo.Name, t.repo.Ref)
+		log.Info("Checking repo")
+		result, err := check(t.client, t.repo, driftCfg)
+		if err != nil {
+			log.Error("Checking repo failed", logging.Err(err))
+			if result.Error == "" {
+				result.Error = err.Error()
+			}
+		}
+		summary.Results = append(summary.Results, result)
+	}
+	summary.FinishedAt = time.Now()
+	return summary
+}
+
+// finish renders the summary, optionally stores it, and returns the exit code.
+func (e *env) finish(summary drift.Summary, resultFile string) int {
+	if resultFile != "" {
+		if err := report.Save(resultFile, summary); err != nil {
+			fmt.Fprintf(e.stderr, "storing result: %v\n", err)
+			return exitFailure
+		}
+	}
+	if err := report.Render(e.stdout, e.global.output, summary); err != nil {
+		fmt.Fprintln(e.stderr, err)
+		return exitFailure
+	}
+	if summary.Failed() {
+		return exitFailure
+	}
+	return exitOK
+}
@@ -0,0 +1,240 @@
+package cli
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jukie/atlantis-drift-detection/internal/ack"
+	"github.com/jukie/atlantis-drift-detection/internal/audit"
+	"github.com/jukie/atlantis-drift-detection/internal/config"
+	"github.com/jukie/atlantis-drift-detection/internal/drift"
+	"github.com/jukie/atlantis-drift-detection/internal/logging"
+	"github.com/jukie/atlantis-drift-detection/internal/report"
+	"github.com/jukie/atlantis-drift-detection/internal/secret"
+)
+
+func serveCmd(e *env, args []string) int {
+	var tokens tokenFlags
+	fs := e.flagSet("serve")
+	tokens.register(fs)
+	interval := fs.Duration("interval", 24*time.Hour, "Time between drift detection runs")
+	listen := fs.String("listen", ":8080", "Address to serve the health and result endpoints on, empty to disable")
+	resultFile := fs.String("result-file", "", "Store the latest result as JSON at this path")
+	apiToken := fs.String("api-token", os.Getenv("DRIFTER_API_TOKEN"), "Bearer token required by the acknowledgements API, which is disabled without one")
+	apiTokenFile := fs.String("api-token-file", os.Getenv("DRIFTER_API_TOKEN_FILE"), "Path to a file containing the API bearer token")
+	apiTokenCommand := fs.String("api-token-command", os.Getenv("DRIFTER_API_TOKEN_COMMAND"), "Command printing the API bearer token")
+	dryRun := fs.Bool("dry-run", false, "Plan and report drift without creating branches, commits, PRs or comments")
+	if code := e.parse(fs, args); code >= 0 {
+		return code
+	}
+	if *interval <= 0 {
+		fmt.Fprintln(e.stderr, "--interval must be positive")
+		return exitUsage
+	}
+
+	driftCfg, servers, targets, err := e.setup(tokens)
+	if err != nil {
+		fmt.Fprintln(e.stderr, err)
+		return exitFailure
+	}
+	driftCfg.DryRun = *dryRun
+	if driftCfg.Store != nil {
+		defer driftCfg.Store.Close()
+	}
+	if driftCfg.Audit != nil {
+		defer driftCfg.Audit.Close()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	d := &daemon{apiToken: config.SecretFrom(*apiToken, *apiTokenFile, *apiTokenCommand), audit: driftCfg.Audit}
+	if d.apiToken != nil {
+		if driftCfg.Acknowledgements == nil {
+			slog.Warn("No acknowledgements file is configured, acknowledgements created through the API are lost on restart")
+			driftCfg.Acknowledgements, _ = ack.NewSet(ack.Config{})
+		}
+		d.acks = driftCfg.Acknowledgements
+	}
+	if *listen != "" {
+		srv := &http.Server{Addr: *listen, Handler: d.handler(), ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("HTTP server stopped", logging.Err(err))
+				stop()
+			}
+		}()
+		defer func() {
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			_ = srv.Shutdown(shutdownCtx)
+		}()
+		slog.Info("Serving HTTP", "addr", *listen)
+	}
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		summary := runAll(targets, driftCfg, drift.Run)
+		d.setLatest(summary)
+		cleanupAfterRun(servers, targets, driftCfg, summary.RunID)
+		if *resultFile != "" {
+			if err := report.Save(*resultFile, summary); err != nil {
+				slog.Error("Storing the result failed", logging.KeyRunID, summary.RunID, logging.Err(err))
+			}
+		}
+		slog.Info("Run finished", logging.KeyRunID, summary.RunID, "next_run_in", interval.String())
+
+		select {
+		case <-ctx.Done():
+			slog.Info("Shutting down")
+			return exitOK
+		case <-ticker.C:
+		}
+	}
+}
+
+// daemon holds the state served over HTTP while serving.
+type daemon struct {
+	mu     sync.RWMutex
+	latest *drift.Summary
+
+	// acks is nil when the acknowledgements API is disabled.
+	acks     *ack.Set
+	apiToken *secret.Source
+	// audit records changes to the acknowledgements. It is optional.
+	audit audit.Logger
+}
+
+// record appends a change to the acknowledgements to the audit log.
+func (d *daemon) record(action string, rule ack.Rule, err error) {
+	if d.audit == nil {
+		return
+	}
+	e := audit.Event{Action: action, Repo: rule.Repo, Detail: strings.TrimSpace("acknowledgement " + rule.ID)}
+	if rule.Project != "" {
+		e.Projects = []string{rule.Project}
+	}
+	if !rule.Expires.IsZero() {
+		e.Detail += fmt.Sprintf(" until %s: %s", rule.Expires.Format(time.RFC3339), rule.Reason)
+	}
+	if recErr := d.audit.Record(e.WithResult(err)); recErr != nil {
+		slog.Error("Recording in the audit log failed", "action", action, logging.Err(recErr))
+	}
+}
+
+func (d *daemon) setLatest(s drift.Summary) {
+	d.mu.Lock()
+	d.latest = &s
+	d.mu.Unlock()
+}
+
+func (d *daemon) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/results", func(w http.ResponseWriter, r *http.Request) {
+		d.mu.RLock()
+		latest := d.latest
+		d.mu.RUnlock()
+		if latest == nil {
+			http.Error(w, "no run has finished yet", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, latest)
+	})
+	if d.acks != nil {
+		mux.Handle("/api/acknowledgements", d.authorized(http.HandlerFunc(d.acknowledgements)))
+		mux.Handle("/api/acknowledgements/", d.authorized(http.HandlerFunc(d.acknowledgement)))
+	}
+	return mux
+}
+
+// authorized requires the API bearer token.
+func (d *daemon) authorized(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		want, err := d.apiToken.Get()
+		if err != nil {
+			slog.Error("Resolving the API token failed", logging.Err(err))
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || want == "" || subtle.ConstantTimeCompare([]byte(got), []byte(want)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acknowledgements lists (GET) and creates (POST) acknowledgement rules.
+func (d *daemon) acknowledgements(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, d.acks.Rules())
+	case http.MethodPost:
+		var rule ack.Rule
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&rule); err != nil {
+			http.Error(w, fmt.Sprintf("invalid acknowledgement: %v", err), http.StatusBadRequest)
+			return
+		}
+		added, err := d.acks.Add(rule)
+		if err != nil {
+			d.record(audit.ActionAddAcknowledgement, rule, err)
+			http.Error(w, fmt.Sprintf("invalid acknowledgement: %v", err), http.StatusBadRequest)
+			return
+		}
+		d.record(audit.ActionAddAcknowledgement, added, nil)
+		slog.Info("Acknowledgement added", "id", added.ID, logging.KeyRepo, added.Repo, logging.KeyProject, added.Project,
+			"expires", added.Expires.Format(time.RFC3339), "reason", added.Reason)
+		writeJSON(w, http.StatusCreated, added)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// acknowledgement deletes (DELETE) a single acknowledgement rule.
+func (d *daemon) acknowledgement(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/api/acknowledgements/")
+	err := d.acks.Remove(id)
+	d.record(audit.ActionRemoveAcknowledgement, ack.Rule{ID: id}, err)
+	switch {
+	case errors.Is(err, ack.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ack.ErrReadOnly):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		slog.Error("Removing the acknowledgement failed", "id", id, logging.Err(err))
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	default:
+		slog.Info("Acknowledgement removed", "id", id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,96 @@
+package cli
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jukie/atlantis-drift-detection/internal/ack"
+	"github.com/jukie/atlantis-drift-detection/internal/audit"
+	"github.com/jukie/atlantis-drift-detection/internal/drift"
+	"github.com/jukie/atlantis-drift-detection/internal/secret"
+	"github.com/stretchr/testify/assert"
+)
+
+func request(h http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestDaemonResults(t *testing.T) {
+	d := &daemon{}
+	h := d.handler()
+
+	assert.Equal(t, http.StatusOK, request(h, http.MethodGet, "/healthz", "", "").Code)
+	assert.Equal(t, http.StatusNotFound, request(h, http.MethodGet, "/results", "", "").Code)
+
+	d.setLatest(drift.Summary{RunID: "abc123"})
+	rec := request(h, http.MethodGet, "/results", "", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"runId":"abc123"`)
+
+	// Without an API token the acknowledgements API is not served.
+	assert.Equal(t, http.StatusNotFound, request(h, http.MethodGet, "/api/acknowledgements", "", "").Code)
+}
+
+type recordingAudit struct {
+	events []audit.Event
+}
+
+func (a *recordingAudit) Record(e audit.Event) error {
+	a.events = append(a.events, e)
+	return nil
+}
+
+func (a *recordingAudit) Close() error {
+	return nil
+}
+
+func TestDaemonAcknowledgements(t *testing.T) {
+	acks, err := ack.NewSet(ack.Config{})
+	assert.NoError(t, err)
+	log := &recordingAudit{}
+	d := &daemon{acks: acks, apiToken: secret.Literal("api-token"), audit: log}
+	h := d.handler()
+
+	assert.Equal(t, http.StatusUnauthorized, request(h, http.MethodGet, "/api/acknowledgements", "", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, request(h, http.MethodGet, "/api/acknowledgements", "wrong", "").Code)
+
+	rec := request(h, http.MethodPost, "/api/acknowledgements", "api-token", `{"repo": "owner/repo", "project": "compute"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "reason is required")
+
+	rec = request(h, http.MethodPost, "/api/acknowledgements", "api-token",
+		`{"repo": "owner/repo", "project": "compute", "resource": "aws_autoscaling_group.web", "expires": "2030-01-01T00:00:00Z", "reason": "INC-123"}`)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	rules := acks.Rules()
+	assert.Len(t, rules, 1)
+	assert.Equal(t, "INC-123", rules[0].Reason)
+
+	rec = request(h, http.MethodGet, "/api/acknowledgements", "api-token", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"reason":"INC-123"`)
+
+	assert.Equal(t, http.StatusNoContent, request(h, http.MethodDelete, "/api/acknowledgements/"+rules[0].ID, "api-token", "").Code)
+	assert.Equal(t, http.StatusNotFound, request(h, http.MethodDelete, "/api/acknowledgements/"+rules[0].ID, "api-token", "").Code)
+
+	// Every change, including the rejected ones, is audited.
+	assert.Len(t, log.events, 4)
+	assert.Equal(t, audit.ActionAddAcknowledgement, log.events[0].Action)
+	assert.Equal(t, audit.ResultFailure, log.events[0].Result)
+	assert.Equal(t, audit.Event{
+		Action:   audit.ActionAddAcknowledgement,
+		Repo:     "owner/repo",
+		Projects: []string{"compute"},
+		Detail:   "acknowledgement " + rules[0].ID + " until 2030-01-01T00:00:00Z: INC-123",
+		Result:   audit.ResultSuccess,
+	}, log.events[1])
+	assert.Equal(t, audit.Event{Action: audit.ActionRemoveAcknowledgement, Detail: "acknowledgement " + rules[0].ID, Result: audit.ResultSuccess}, log.events[2])
+	assert.Equal(t, audit.ResultFailure, log.events[3].Result)
+}
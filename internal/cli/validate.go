@@ -0,0 +1,36 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jukie/atlantis-drift-detection/internal/config"
+)
+
+func validateCmd(e *env, args []string) int {
+	fs := e.flagSet("validate")
+	if code := e.parse(fs, args); code >= 0 {
+		return code
+	}
+	configPath := e.global.configPath
+	if configPath == "" {
+		fmt.Fprintln(e.stderr, "Error: no config file given. Set CONFIG_PATH or pass --config.")
+		return exitUsage
+	}
+
+	_, err := config.LoadVcsConfig(configPath)
+	var validationErr *config.ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		for _, p := range validationErr.Problems {
+			fmt.Fprintf(e.stderr, "%s:%s\n", configPath, p)
+		}
+		fmt.Fprintf(e.stderr, "%d problem(s) found\n", len(validationErr.Problems))
+		return exitFailure
+	case err != nil:
+		fmt.Fprintln(e.stderr, err)
+		return exitFailure
+	}
+	fmt.Fprintf(e.stdout, "%s is valid\n", configPath)
+	return exitOK
+}
@@ -0,0 +1,95 @@
+// Package codeowners finds the owners of directories in a GitHub or GitLab
+// CODEOWNERS file.
+package codeowners
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/jukie/atlantis-drift-detection/internal/glob"
+)
+
+// Locations are where GitHub and GitLab look for the CODEOWNERS file, in the
+// order they are tried.
+var Locations = []string{".github/CODEOWNERS", ".gitlab/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS"}
+
+// Rule assigns owners to the paths matching a pattern.
+type Rule struct {
+	Pattern string
+	// Owners are written like in the file: @user, @org/team or an email
+	// address. A rule without owners removes the ownership of earlier rules.
+	Owners []string
+	Line   int
+}
+
+// File is a parsed CODEOWNERS file.
+type File struct {
+	Rules []Rule
+}
+
+// Parse reads a CODEOWNERS file. GitLab section headers are skipped, so the
+// rules of all sections are matched as one list and their default owners are
+// ignored.
+func Parse(data []byte) (*File, error) {
+	f := &File{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") || strings.HasPrefix(text, "[") || strings.HasPrefix(text, "^[") {
+			continue
+		}
+		fields := strings.Fields(strings.ReplaceAll(text, `\ `, "\x00"))
+		rule := Rule{Pattern: strings.ReplaceAll(strings.ReplaceAll(fields[0], "\x00", " "), `\#`, "#"), Line: line}
+		if err := glob.Validate(rule.Pattern); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if len(fields) > 1 {
+			rule.Owners = fields[1:]
+		}
+		f.Rules = append(f.Rules, rule)
+	}
+	return f, scanner.Err()
+}
+
+// Owners returns the owners of a directory relative to the repo root, which
+// are those of the last rule matching the directory or one of its parents.
+// Patterns ending in /* or /** also match the directory itself, but file
+// patterns such as *.tf match no directory.
+func (f *File) Owners(dir string) []string {
+	dir = strings.Trim(path.Clean("/"+dir), "/")
+	var owners []string
+	for _, r := range f.Rules {
+		if r.matches(dir) {
+			owners = r.Owners
+		}
+	}
+	return owners
+}
+
+func (r Rule) matches(dir string) bool {
+	pattern := r.Pattern
+	if pattern == "*" || pattern == "**" || pattern == "/**" {
+		return true
+	}
+	// Like in .gitignore, a slash other than a trailing one anchors the
+	// pattern to the repo root.
+	anchored := strings.Contains(strings.TrimSuffix(pattern, "/"), "/")
+	for _, suffix := range []string{"/**", "/*", "/"} {
+		if strings.HasSuffix(pattern, suffix) {
+			pattern = strings.TrimSuffix(pattern, suffix)
+			break
+		}
+	}
+	pattern = strings.TrimPrefix(pattern, "/")
+	for d := dir; ; d = path.Dir(d) {
+		if glob.Match(pattern, d) || !anchored && glob.Match("**/"+pattern, d) {
+			return true
+		}
+		if !strings.Contains(d, "/") {
+			return false
+		}
+	}
+}
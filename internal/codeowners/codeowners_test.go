@@ -0,0 +1,50 @@
+package codeowners_test
+
+import (
+	"testing"
+
+	"github.com/jukie/atlantis-drift-detection/internal/codeowners"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOwners(t *testing.T) {
+	f, err := codeowners.Parse([]byte(`# Default owners
+*                 @org/platform
+
+network/          @org/network
+/envs/prod/**     @org/sre ops@example.com
+docs/*            @writer
+modules/vpc       @alice @bob
+/legacy/
+\#weird\ dir      @carol
+
+[Database]
+databases         @org/dba
+`))
+	assert.NoError(t, err)
+	assert.Len(t, f.Rules, 8)
+	assert.Equal(t, 12, f.Rules[7].Line)
+
+	cases := map[string][]string{
+		".":                   {"@org/platform"},
+		"compute":             {"@org/platform"},
+		"network":             {"@org/network"},
+		"envs/network":        {"@org/network"},
+		"envs/prod":           {"@org/sre", "ops@example.com"},
+		"envs/prod/db":        {"@org/sre", "ops@example.com"},
+		"./envs/prod/":        {"@org/sre", "ops@example.com"},
+		"prod/envs/prod":      {"@org/platform"},
+		"docs":                {"@writer"},
+		"sub/docs":            {"@org/platform"},
+		"modules/vpc/subnets": {"@alice", "@bob"},
+		"legacy/app":          nil,
+		"#weird dir":          {"@carol"},
+		"infra/databases":     {"@org/dba"},
+	}
+	for dir, want := range cases {
+		assert.Equal(t, want, f.Owners(dir), dir)
+	}
+
+	_, err = codeowners.Parse([]byte("\n/docs/[ab @org/docs\n"))
+	assert.EqualError(t, err, `line 2: invalid pattern "/docs/[ab": unterminated character class`)
+}
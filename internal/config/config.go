@@ -3,68 +3,376 @@ package config
 import (
 	"fmt"
 	"os"
+	"reflect"
+	"sort"
+	"time"
 
+	"github.com/jukie/atlantis-drift-detection/internal/ack"
+	"github.com/jukie/atlantis-drift-detection/internal/audit"
+	"github.com/jukie/atlantis-drift-detection/internal/notify"
+	"github.com/jukie/atlantis-drift-detection/internal/secret"
+	"github.com/jukie/atlantis-drift-detection/internal/state"
 	"gopkg.in/yaml.v3"
 )
 
 type DriftCfg struct {
 	AtlantisUrl   string
-	AtlantisToken string
+	AtlantisToken *secret.Source
 	ConfigPath    string
+	// DryRun plans and classifies drift but never writes to the VCS.
+	DryRun bool
+	// RunID correlates everything done in one run.
+	RunID string
+	// Store keeps the results of previous runs. It is optional.
+	Store state.Store
+	// Notifier is alerted about new and changed drift. It is optional.
+	Notifier notify.Notifier
+	// Acknowledgements hold the rules for accepted drift. It is optional.
+	Acknowledgements *ack.Set
+	// ReportURL links drift PRs to the full report of the run. It is
+	// optional.
+	ReportURL string
+	// Audit records every action taken. It is optional.
+	Audit audit.Logger
 }
 type Repo struct {
 	Ref  string
 	Name string
+	// Include limits planning to the projects matching any of the filters.
+	Include []ProjectFilter `yaml:"include"`
+	// Exclude skips the projects matching any of the filters.
+	Exclude []ProjectFilter `yaml:"exclude"`
+	// Ignore lists resource changes that do not count as drift.
+	Ignore []IgnoreRule `yaml:"ignore"`
+	// Handling decides what is done about drift and pending changes.
+	Handling Handling `yaml:"handling"`
+	// Plan customizes the request sent to the Atlantis /api/plan endpoint.
+	Plan PlanOptions `yaml:"plan"`
+	// AutoApply opts projects into applying their drift through Atlantis.
+	AutoApply []AutoApplyRule `yaml:"autoApply"`
+	// CommitStatus reports drift on the head commit of Ref.
+	CommitStatus CommitStatusOptions `yaml:"commitStatus"`
+	// Pull configures the drift PR.
+	Pull PullOptions `yaml:"pull"`
+	// Marker configures the file committed to drift branches.
+	Marker MarkerOptions `yaml:"marker"`
+	// Codeowners routes drift to the owners of the drifted projects.
+	Codeowners CodeownersOptions `yaml:"codeowners"`
 }
 
+// CodeownersOptions route drift by the repo's CODEOWNERS file: the owners of
+// the directories of drifted projects are requested as reviewers of the drift
+// PR, and notifications go to the webhooks of their teams.
+type CodeownersOptions struct {
+	Enabled bool `yaml:"enabled"`
+	// Path is the CODEOWNERS file, found in the locations GitHub and GitLab
+	// use when not set.
+	Path string `yaml:"path"`
+	// Reviewers requests reviews from the owners unless set to false.
+	Reviewers *bool `yaml:"reviewers"`
+}
+
+// RequestReviews reports whether the owners are requested as reviewers.
+func (o CodeownersOptions) RequestReviews() bool {
+	return o.Enabled && (o.Reviewers == nil || *o.Reviewers)
+}
+
+// MarkerOptions configure the file committed to drift branches.
+type MarkerOptions struct {
+	// Path is relative to the repo root, vcs.DefaultMarkerPath by default.
+	Path string `yaml:"path"`
+	// Template renders the content with the fields of vcs.MarkerData,
+	// vcs.DefaultMarkerTemplate by default.
+	Template string `yaml:"template"`
+}
+
+// Drift PR modes.
+const (
+	// PullPerCommit opens a PR from a new branch for every head commit of the
+	// ref that drifts. It is the default.
+	PullPerCommit = "per-commit"
+	// PullLongLived keeps one drift PR per repo open and comments on it on
+	// every run.
+	PullLongLived = "long-lived"
+)
+
+var PullModes = []string{PullPerCommit, PullLongLived}
+
+// DefaultPullBranch is the branch of long-lived drift PRs unless configured
+// otherwise.
+const DefaultPullBranch = "atlantis-drift"
+
+// PullOptions configure the drift PR of a repo.
+type PullOptions struct {
+	Mode string `yaml:"mode"`
+	// Branch is the branch of the long-lived PR, DefaultPullBranch by
+	// default.
+	Branch string `yaml:"branch"`
+	// MergeRequest configures the drift MRs of GitLab repos.
+	MergeRequest *MergeRequestOptions `yaml:"mergeRequest"`
+}
+
+// MergeRequestOptions configure the drift MRs of a GitLab repo when they are
+// opened.
+type MergeRequestOptions struct {
+	// Reviewers and Assignees are GitLab usernames.
+	Reviewers []string `yaml:"reviewers"`
+	Assignees []string `yaml:"assignees"`
+	Labels    []string `yaml:"labels"`
+	// Milestone is the title of a milestone of the project or its groups.
+	Milestone string `yaml:"milestone"`
+	Draft     bool   `yaml:"draft"`
+	// RemoveSourceBranch and Squash are enabled unless set to false, for
+	// projects whose merge settings do not allow them.
+	RemoveSourceBranch *bool `yaml:"removeSourceBranch"`
+	Squash             *bool `yaml:"squash"`
+}
+
+// DefaultStatusContext names the commit status or check run unless
+// configured otherwise.
+const DefaultStatusContext = "atlantis/drift"
+
+// CommitStatusOptions configure the commit status, or check run on GitHub,
+// that summarizes the drift of a repo.
+type CommitStatusOptions struct {
+	Enabled bool `yaml:"enabled"`
+	// Context names the status, DefaultStatusContext by default.
+	Context string `yaml:"context"`
+	// URL is linked from the status when no drift PR was opened, for example
+	// a dashboard with the reports.
+	URL string `yaml:"url"`
+}
+
+// AutoApplyRule lets the projects matching the filter have their drift
+// applied automatically, as long as the plan stays within the limits.
+type AutoApplyRule struct {
+	ProjectFilter `yaml:",inline"`
+	// MaxChanges is the largest number of changed resources to apply.
+	MaxChanges int `yaml:"maxChanges"`
+	// AllowDestroy permits plans that delete resources.
+	AllowDestroy bool `yaml:"allowDestroy"`
+	// AllowReplace permits plans that replace resources.
+	AllowReplace bool `yaml:"allowReplace"`
+}
+
+// PlanOptions are additional options of the Atlantis plan request.
+type PlanOptions struct {
+	// PR plans in the context of this pull request number.
+	PR int `yaml:"pr"`
+	// Paths replace the projects found in the repo's atlantis.yaml.
+	Paths []PlanPath `yaml:"paths"`
+	// Headers are sent with the plan request, e.g. for a proxy in front of
+	// Atlantis.
+	Headers map[string]*secret.Source `yaml:"headers"`
+}
+
+// PlanPath is a project directory and workspace to plan.
+type PlanPath struct {
+	// Name is only used by the filters and in reports.
+	Name      string `yaml:"name"`
+	Dir       string `yaml:"dir"`
+	Workspace string `yaml:"workspace"`
+}
+
+// Handling modes for a category of changes.
+const (
+	// HandlingPR opens a drift PR and sends notifications. It is the default.
+	HandlingPR = "pr"
+	// HandlingReport only lists the projects in the run summary.
+	HandlingReport = "report"
+)
+
+var HandlingModes = []string{HandlingPR, HandlingReport}
+
+// Handling sets the handling mode per category of changes: drift made outside
+// of Terraform, and configuration changes that were merged but never applied.
+type Handling struct {
+	Drift   string `yaml:"drift"`
+	Pending string `yaml:"pending"`
+}
+
+// ProjectFilter selects Atlantis projects by name, dir and workspace glob
+// patterns. A project matches when every set field matches.
+type ProjectFilter struct {
+	Name      string `yaml:"name"`
+	Dir       string `yaml:"dir"`
+	Workspace string `yaml:"workspace"`
+}
+
+// IgnoreRule matches noisy resource changes by glob patterns on the project
+// name, resource address and resource type, and by action. Empty fields match
+// everything; a rule must set at least one of address, type or actions.
+type IgnoreRule struct {
+	Project string   `yaml:"project"`
+	Address string   `yaml:"address"`
+	Type    string   `yaml:"type"`
+	Actions []string `yaml:"actions"`
+}
+
+// Resource change actions, named after the actions in Terraform's JSON plan.
+const (
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionDelete  = "delete"
+	ActionReplace = "replace"
+	ActionRead    = "read"
+)
+
+// IgnoreActions are the actions an IgnoreRule can match.
+var IgnoreActions = []string{ActionCreate, ActionUpdate, ActionDelete, ActionReplace, ActionRead}
+
 type ServerCfg struct {
-	ApiEndpoint string `yaml:"apiEndpoint"`
-	Repos       []Repo `yaml:"repos"`
+	ApiEndpoint string         `yaml:"apiEndpoint"`
+	Token       *secret.Source `yaml:"token"`
+	Repos       []Repo         `yaml:"repos"`
+	// Commit configures the drift marker commits.
+	Commit CommitConfig `yaml:"commit"`
+}
+
+// CommitConfig configures the drift marker commits of a VCS server. Without
+// an author the commits are attributed to the owner of the token.
+type CommitConfig struct {
+	Message     string         `yaml:"message"`
+	AuthorName  string         `yaml:"authorName"`
+	AuthorEmail string         `yaml:"authorEmail"`
+	Signing     *SigningConfig `yaml:"signing"`
+}
+
+// SigningConfig signs the drift marker commits with a GPG key or an SSH key,
+// which must be registered as a signing key of the author on the VCS. GitLab
+// only supports the web format, which has GitLab sign the commits itself.
+type SigningConfig struct {
+	// Format is one of vcs.SignFormats.
+	Format string `yaml:"format"`
+	// Key is an armored OpenPGP private key or an OpenSSH private key. It is
+	// not set for the web format.
+	Key        *secret.Source `yaml:"key"`
+	Passphrase *secret.Source `yaml:"passphrase"`
 }
 
 type VcsServers struct {
-	GithubServer *ServerCfg `yaml:"github"`
-	GitlabServer *ServerCfg `yaml:"gitlab"`
+	GithubServer     *ServerCfg     `yaml:"github"`
+	GitlabServer     *ServerCfg     `yaml:"gitlab"`
+	State            *state.Config  `yaml:"state"`
+	Notifications    *notify.Config `yaml:"notifications"`
+	Acknowledgements *ack.Config    `yaml:"acknowledgements"`
+	Cleanup          *CleanupConfig `yaml:"cleanup"`
+	Audit            *audit.Config  `yaml:"audit"`
+}
+
+// DefaultBranchMaxAge is how long a drift branch may go without commits
+// before it is deleted, unless configured otherwise.
+const DefaultBranchMaxAge = 30 * 24 * time.Hour
+
+// CleanupConfig configures deleting stale drift branches, those whose PRs
+// are all closed or merged and those older than MaxAge.
+type CleanupConfig struct {
+	// Enabled deletes stale branches after every run, and not only through
+	// the cleanup command.
+	Enabled bool `yaml:"enabled"`
+	// MaxAge is DefaultBranchMaxAge when not set.
+	MaxAge time.Duration `yaml:"maxAge"`
+}
+
+// BranchMaxAge returns the configured maximum branch age or the default.
+func (c *CleanupConfig) BranchMaxAge() time.Duration {
+	if c == nil || c.MaxAge == 0 {
+		return DefaultBranchMaxAge
+	}
+	return c.MaxAge
 }
 
 func GetDriftCfg() (DriftCfg, error) {
 	var d DriftCfg
 
-	var url, token, configPath string
+	var url string
 	var ok bool
 
 	if url, ok = os.LookupEnv("ATLANTIS_URL"); !ok {
 		return d, fmt.Errorf("ATLANTIS_URL environment variable is required but not set")
 	}
+	if err := validateURL(url); err != nil {
+		return d, fmt.Errorf("ATLANTIS_URL %v", err)
+	}
 	d.AtlantisUrl = url
 
-	if token, ok = os.LookupEnv("ATLANTIS_TOKEN"); !ok {
-		return d, fmt.Errorf("ATLANTIS_TOKEN environment variable is required but not set")
+	d.AtlantisToken = SecretFromEnv("ATLANTIS_TOKEN")
+	if d.AtlantisToken == nil {
+		return d, fmt.Errorf("ATLANTIS_TOKEN, ATLANTIS_TOKEN_FILE or ATLANTIS_TOKEN_COMMAND environment variable is required but not set")
 	}
-	d.AtlantisToken = token
 
-	if configPath, ok = os.LookupEnv("CONFIG_PATH"); !ok {
-		return d, fmt.Errorf("CONFIG_PATH environment variable is required but not set")
+	// CONFIG_PATH is optional here because the CLI also accepts --config.
+	d.ConfigPath = os.Getenv("CONFIG_PATH")
+
+	if reportURL := os.Getenv("REPORT_URL"); reportURL != "" {
+		if err := validateURL(reportURL); err != nil {
+			return d, fmt.Errorf("REPORT_URL %v", err)
+		}
+		d.ReportURL = reportURL
 	}
-	d.ConfigPath = configPath
 
 	return d, nil
 }
 
+// SecretFromEnv builds a secret source from the environment variable name, or
+// from name_FILE or name_COMMAND when those are set instead. It returns nil
+// when none of them are set.
+func SecretFromEnv(name string) *secret.Source {
+	return SecretFrom(os.Getenv(name), os.Getenv(name+"_FILE"), os.Getenv(name+"_COMMAND"))
+}
+
+// SecretFrom returns a secret source for the first non-empty of a literal
+// value, a file path or a command line, or nil when all are empty.
+func SecretFrom(value, file, command string) *secret.Source {
+	switch {
+	case value != "":
+		return secret.Literal(value)
+	case file != "":
+		return secret.FromFile(file)
+	case command != "":
+		return secret.FromCommand(command)
+	}
+	return nil
+}
+
+// LoadVcsConfig reads the config file strictly: unknown fields, malformed
+// values and invalid repos are all reported together in a *ValidationError.
 func LoadVcsConfig(repoCfgPath string) (*VcsServers, error) {
 	var cfg VcsServers
-	if fileExists(repoCfgPath) {
-		f, err := os.ReadFile(repoCfgPath)
-		if err != nil {
-			return &cfg, err
-		}
-		err = yaml.Unmarshal(f, &cfg)
-		if err != nil {
-			return &cfg, err
-		}
-		return &cfg, nil
+	if !fileExists(repoCfgPath) {
+		return &cfg, fmt.Errorf("could not find config file %s", repoCfgPath)
+	}
+	f, err := os.ReadFile(repoCfgPath)
+	if err != nil {
+		return &cfg, err
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(f, &root); err != nil {
+		return &cfg, fmt.Errorf("parsing config file %s: %w", repoCfgPath, err)
+	}
+	if len(root.Content) == 0 {
+		return &cfg, &ValidationError{Path: repoCfgPath, Problems: []Problem{{Line: 1, Column: 1, Message: "config file is empty"}}}
+	}
+
+	v := &validator{root: &root}
+	v.unknownFields(root.Content[0], reflect.TypeOf(cfg))
+	if err := v.decodeErrors(root.Decode(&cfg)); err != nil {
+		return &cfg, err
+	}
+	v.validate(&cfg)
+
+	if len(v.problems) > 0 {
+		sort.SliceStable(v.problems, func(i, j int) bool {
+			a, b := v.problems[i], v.problems[j]
+			if a.Line != b.Line {
+				return a.Line < b.Line
+			}
+			return a.Column < b.Column
+		})
+		return &cfg, &ValidationError{Path: repoCfgPath, Problems: v.problems}
 	}
-	return &cfg, fmt.Errorf("could not find config file")
+	return &cfg, nil
 }
 
 func fileExists(filename string) bool {
@@ -72,5 +380,5 @@ func fileExists(filename string) bool {
 	if os.IsNotExist(err) {
 		return false
 	}
-	return !info.IsDir()
+	return err == nil && !info.IsDir()
 }
@@ -1,10 +1,16 @@
 package config_test
 
 import (
+	"errors"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/jukie/atlantis-drift-detection/internal/config"
+	"github.com/jukie/atlantis-drift-detection/internal/secret"
+	"github.com/jukie/atlantis-drift-detection/internal/vcs"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -16,7 +22,7 @@ func TestGetDriftCfg(t *testing.T) {
 
 	expectedCfg := config.DriftCfg{
 		AtlantisUrl:   "http://example.com",
-		AtlantisToken: "token",
+		AtlantisToken: secret.Literal("token"),
 		ConfigPath:    "/path/to/config.yaml",
 	}
 
@@ -25,6 +31,21 @@ func TestGetDriftCfg(t *testing.T) {
 	assert.Equal(t, expectedCfg, cfg)
 }
 
+func TestGetDriftCfgReportURL(t *testing.T) {
+	os.Setenv("ATLANTIS_URL", "http://example.com")
+	os.Setenv("ATLANTIS_TOKEN", "token")
+	os.Setenv("REPORT_URL", "https://drift.example.com/runs/{runId}")
+	defer os.Clearenv()
+
+	cfg, err := config.GetDriftCfg()
+	assert.NoError(t, err)
+	assert.Equal(t, "https://drift.example.com/runs/{runId}", cfg.ReportURL)
+
+	os.Setenv("REPORT_URL", "drift.example.com")
+	_, err = config.GetDriftCfg()
+	assert.ErrorContains(t, err, `REPORT_URL "drift.example.com" must use http or https`)
+}
+
 func TestGetDriftCfgMissingEnvVar(t *testing.T) {
 	os.Unsetenv("ATLANTIS_URL")
 	os.Unsetenv("ATLANTIS_TOKEN")
@@ -41,36 +62,29 @@ func TestLoadVcsConfig(t *testing.T) {
   apiEndpoint: https://api.github.com
   repos:
   - ref: master
-    name: repo1
+    name: owner/repo1
 gitlab:
   apiEndpoint: https://gitlab.com/api/v4
   repos:
   - ref: main
-    name: repo2
+    name: group/repo2
 `
-	tmpfile, err := os.CreateTemp("", "config")
-	assert.NoError(t, err)
-	defer os.Remove(tmpfile.Name())
-
-	err = os.WriteFile(tmpfile.Name(), []byte(cfgYAML), 0644)
-	assert.NoError(t, err)
-
 	expectedCfg := &config.VcsServers{
 		GithubServer: &config.ServerCfg{
 			ApiEndpoint: "https://api.github.com",
 			Repos: []config.Repo{
-				{Ref: "master", Name: "repo1"},
+				{Ref: "master", Name: "owner/repo1"},
 			},
 		},
 		GitlabServer: &config.ServerCfg{
 			ApiEndpoint: "https://gitlab.com/api/v4",
 			Repos: []config.Repo{
-				{Ref: "main", Name: "repo2"},
+				{Ref: "main", Name: "group/repo2"},
 			},
 		},
 	}
 
-	cfg, err := config.LoadVcsConfig(tmpfile.Name())
+	cfg, err := loadConfig(t, cfgYAML)
 	assert.NoError(t, err)
 	assert.Equal(t, expectedCfg, cfg)
 }
@@ -82,3 +96,502 @@ func TestLoadVcsConfigMissingFile(t *testing.T) {
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "could not find config file")
 }
+
+func TestSecretFromEnv(t *testing.T) {
+	os.Setenv("GITHUB_TOKEN_FILE", "/run/secrets/github")
+	defer os.Clearenv()
+
+	s := config.SecretFromEnv("GITHUB_TOKEN")
+	assert.Equal(t, secret.FromFile("/run/secrets/github"), s)
+
+	assert.Nil(t, config.SecretFromEnv("GITLAB_TOKEN"))
+}
+
+func TestLoadVcsConfigToken(t *testing.T) {
+	cfgYAML := `github:
+  apiEndpoint: https://api.github.com
+  token:
+    file: /run/secrets/github
+  repos:
+  - ref: main
+    name: owner/repo1
+`
+	cfg, err := loadConfig(t, cfgYAML)
+	assert.NoError(t, err)
+	assert.Equal(t, "/run/secrets/github", cfg.GithubServer.Token.File)
+}
+
+func TestLoadVcsConfigProblems(t *testing.T) {
+	cfgYAML := `github:
+  apiEndpoint: api.github.com
+  repos:
+  - ref: main
+    name: repo1
+  - name: owner/repo2
+    branch: main
+gitlab:
+  apiEndpoint: https://gitlab.com/api/v4
+  tokne: abc
+  repos:
+  - ref: main
+    name: group/subgroup/repo3
+`
+	_, err := loadConfig(t, cfgYAML)
+	assert.Equal(t, []string{
+		`2:16: apiEndpoint "api.github.com" must use http or https`,
+		`5:11: repo name "repo1" must be in the form owner/repo`,
+		`6:5: repo ref is required`,
+		`7:5: unknown field "branch", expected one of: autoApply, codeowners, commitStatus, exclude, handling, ignore, include, marker, name, plan, pull, ref`,
+		`10:3: unknown field "tokne", expected one of: apiEndpoint, commit, repos, token`,
+	}, problems(err))
+}
+
+func TestLoadVcsConfigTypeError(t *testing.T) {
+	cfgYAML := `github:
+  repos: owner/repo1
+`
+	_, err := loadConfig(t, cfgYAML)
+	var validationErr *config.ValidationError
+	assert.ErrorAs(t, err, &validationErr)
+	assert.Equal(t, 2, validationErr.Problems[0].Line)
+	assert.Equal(t, 10, validationErr.Problems[0].Column)
+}
+
+func TestLoadVcsConfigIgnoreRules(t *testing.T) {
+	cfgYAML := `github:
+  repos:
+  - ref: main
+    name: owner/repo1
+    ignore:
+    - type: aws_instance
+      actions: [update]
+    - project: compute
+    - address: aws_instance.*
+      actions: [modify]
+`
+	_, err := loadConfig(t, cfgYAML)
+	assert.Equal(t, []string{
+		"8:7: ignore rule must set at least one of address, type or actions",
+		`10:17: unknown action "modify", expected one of: create, update, delete, replace, read`,
+	}, problems(err))
+}
+
+func TestLoadVcsConfigHandling(t *testing.T) {
+	cfgYAML := `github:
+  repos:
+  - ref: main
+    name: owner/repo1
+    handling:
+      pending: report
+  - ref: main
+    name: owner/repo2
+    handling:
+      drift: close
+`
+	_, err := loadConfig(t, cfgYAML)
+	assert.Equal(t, []string{`10:14: unknown handling "close", expected one of: pr, report`}, problems(err))
+
+	cfgYAML = strings.Replace(cfgYAML, "drift: close", "drift: pr", 1)
+	cfg, err := loadConfig(t, cfgYAML)
+	assert.NoError(t, err)
+	assert.Equal(t, config.Handling{Pending: config.HandlingReport}, cfg.GithubServer.Repos[0].Handling)
+}
+
+func TestLoadVcsConfigPlanOptions(t *testing.T) {
+	cfgYAML := `github:
+  repos:
+  - ref: main
+    name: owner/repo1
+    plan:
+      pr: -1
+      paths:
+      - dir: /abs
+      - dir: ../outside
+      - dir: envs/prod
+        workspace: prod/eu
+      - workspace: prod
+      - dir: ./envs/staging
+      - dir: envs/staging
+        workspace: default
+      headers:
+        X-Atlantis-Token: other
+        Bad Header: value
+        X-Empty:
+          file: ""
+  - ref: main
+    name: owner/repo2
+    plan:
+      pr: 3
+      paths:
+      - dir: network
+        workspace: prod
+      headers:
+        X-Proxy-Auth:
+          command: [cat, /run/secrets/proxy]
+`
+	_, err := loadConfig(t, cfgYAML)
+	assert.Equal(t, []string{
+		"6:11: pr must be a positive pull request number",
+		`8:14: plan path dir "/abs" must be relative to the repo root`,
+		`9:14: plan path dir "../outside" must be relative to the repo root`,
+		`11:20: workspace "prod/eu" may only contain letters, digits, '_', '.' and '-'`,
+		"12:9: plan path dir is required",
+		"14:9: plan path envs/staging in workspace default is already listed at paths[4]",
+		"17:27: header X-Atlantis-Token is set by the drift detector and cannot be configured",
+		`18:21: "Bad Header" is not a valid header name`,
+		"20:11: header X-Empty needs a value",
+	}, problems(err))
+
+	cfgYAML = cfgYAML[strings.Index(cfgYAML, "  - ref: main\n    name: owner/repo2"):]
+	cfg, err := loadConfig(t, "github:\n  repos:\n"+cfgYAML)
+	assert.NoError(t, err)
+	plan := cfg.GithubServer.Repos[0].Plan
+	assert.Equal(t, 3, plan.PR)
+	assert.Equal(t, []config.PlanPath{{Dir: "network", Workspace: "prod"}}, plan.Paths)
+	assert.Equal(t, []string{"cat", "/run/secrets/proxy"}, plan.Headers["X-Proxy-Auth"].Command)
+}
+
+func TestLoadVcsConfigAutoApply(t *testing.T) {
+	cfgYAML := `github:
+  repos:
+  - ref: main
+    name: owner/repo
+    autoApply:
+    - name: network
+      maxChanges: 5
+      allowReplace: true
+    - dir: envs/**
+`
+	_, err := loadConfig(t, cfgYAML)
+	assert.Equal(t, []string{"9:7: auto-apply rule must set maxChanges to at least 1"}, problems(err))
+
+	cfg, err := loadConfig(t, strings.TrimSuffix(cfgYAML, "    - dir: envs/**\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []config.AutoApplyRule{{
+		ProjectFilter: config.ProjectFilter{Name: "network"},
+		MaxChanges:    5,
+		AllowReplace:  true,
+	}}, cfg.GithubServer.Repos[0].AutoApply)
+}
+
+func TestLoadVcsConfigCommitStatus(t *testing.T) {
+	cfgYAML := `gitlab:
+  repos:
+  - ref: main
+    name: group/project
+    commitStatus:
+      enabled: true
+      url: drift.example.com
+`
+	_, err := loadConfig(t, cfgYAML)
+	assert.ErrorContains(t, err, `7:12: url "drift.example.com" must use http or https`)
+
+	cfg, err := loadConfig(t, strings.Replace(cfgYAML, "drift.example.com", "https://drift.example.com", 1))
+	assert.NoError(t, err)
+	assert.Equal(t, config.CommitStatusOptions{Enabled: true, URL: "https://drift.example.com"}, cfg.GitlabServer.Repos[0].CommitStatus)
+}
+
+func TestLoadVcsConfigPullOptions(t *testing.T) {
+	cfgYAML := `github:
+  repos:
+  - ref: main
+    name: owner/repo1
+    pull:
+      mode: reuse
+      branch: main
+  - ref: main
+    name: owner/repo2
+    pull:
+      mode: long-lived
+      branch: drift:prod
+  - ref: main
+    name: owner/repo3
+    pull:
+      mode: long-lived
+`
+	_, err := loadConfig(t, cfgYAML)
+	assert.Equal(t, []string{
+		`6:13: unknown pull mode "reuse", expected one of: per-commit, long-lived`,
+		"7:15: pull branch must differ from the repo ref",
+		`12:15: pull branch "drift:prod" is not a valid git ref`,
+	}, problems(err))
+}
+
+func TestLoadVcsConfigCleanup(t *testing.T) {
+	cfgYAML := `github:
+  repos:
+  - ref: main
+    name: owner/repo
+cleanup:
+  enabled: true
+  maxAge: -1h
+`
+	_, err := loadConfig(t, cfgYAML)
+	assert.ErrorContains(t, err, "7:11: cleanup maxAge must not be negative")
+
+	cfg, err := loadConfig(t, strings.Replace(cfgYAML, "-1h", "168h", 1))
+	assert.NoError(t, err)
+	assert.Equal(t, &config.CleanupConfig{Enabled: true, MaxAge: 7 * 24 * time.Hour}, cfg.Cleanup)
+	assert.Equal(t, 7*24*time.Hour, cfg.Cleanup.BranchMaxAge())
+
+	var unset *config.CleanupConfig
+	assert.Equal(t, config.DefaultBranchMaxAge, unset.BranchMaxAge())
+}
+
+func TestLoadVcsConfigCommit(t *testing.T) {
+	cfgYAML := `github:
+  commit:
+    authorName: Drift Bot
+    authorEmail: drift.example.com
+    signing:
+      format: pgp
+  repos:
+  - ref: main
+    name: owner/repo
+gitlab:
+  commit:
+    authorName: Drift Bot
+    signing:
+      format: ssh
+      key:
+        file: /run/secrets/ssh-key
+  repos:
+  - ref: main
+    name: group/project
+`
+	_, err := loadConfig(t, cfgYAML)
+	assert.Equal(t, []string{
+		`4:18: commit authorEmail "drift.example.com" is not an email address`,
+		"6:7: signing key is required",
+		`6:15: unknown signing format "pgp", expected one of: gpg, ssh, web`,
+		"12:5: commit authorName and authorEmail must be set together",
+		"14:15: the GitLab commits API does not accept signatures, use the web format to have GitLab sign the commits",
+	}, problems(err))
+
+	// GitLab signs web signed commits itself, without a key.
+	cfgYAML = `github:
+  commit:
+    signing:
+      format: web
+  repos:
+  - ref: main
+    name: owner/repo
+gitlab:
+  commit:
+    signing:
+      format: web
+      key:
+        file: /run/secrets/ssh-key
+  repos:
+  - ref: main
+    name: group/project
+`
+	_, err = loadConfig(t, cfgYAML)
+	assert.Equal(t, []string{
+		"4:15: web signing is only supported on GitLab, GitHub commits are signed with a gpg or ssh key",
+		"11:7: web signed commits are signed by GitLab, key and passphrase must not be set",
+	}, problems(err))
+
+	cfg, err := loadConfig(t, "gitlab:\n  commit:\n    signing:\n      format: web\n  repos:\n  - ref: main\n    name: group/project\n")
+	assert.NoError(t, err)
+	assert.Equal(t, &config.SigningConfig{Format: vcs.SignWeb}, cfg.GitlabServer.Commit.Signing)
+
+	cfgYAML = `github:
+  commit:
+    message: "chore: drift marker"
+    authorName: Drift Bot
+    authorEmail: drift@example.com
+    signing:
+      format: gpg
+      key:
+        file: /run/secrets/gpg-key
+      passphrase:
+        command: [pass, drift-gpg]
+  repos:
+  - ref: main
+    name: owner/repo
+`
+	cfg, err = loadConfig(t, cfgYAML)
+	assert.NoError(t, err)
+	assert.Equal(t, config.CommitConfig{
+		Message:     "chore: drift marker",
+		AuthorName:  "Drift Bot",
+		AuthorEmail: "drift@example.com",
+		Signing: &config.SigningConfig{
+			Format:     vcs.SignGPG,
+			Key:        secret.FromFile("/run/secrets/gpg-key"),
+			Passphrase: &secret.Source{Command: []string{"pass", "drift-gpg"}},
+		},
+	}, cfg.GithubServer.Commit)
+}
+
+func TestLoadVcsConfigMarker(t *testing.T) {
+	cfgYAML := `github:
+  repos:
+  - ref: main
+    name: owner/repo1
+    marker:
+      path: ../outside.txt
+      template: "{{.Commit}}"
+  - ref: main
+    name: owner/repo2
+    marker:
+      path: .git/config
+      template: "{{.RunID"
+  - ref: main
+    name: owner/repo3
+    marker:
+      path: .drift/
+`
+	_, err := loadConfig(t, cfgYAML)
+	messages := problems(err)
+	assert.Len(t, messages, 5)
+	assert.Equal(t, `6:13: marker path "../outside.txt" must be relative to the repo root`, messages[0])
+	assert.Contains(t, messages[1], "7:17: marker template: ")
+	assert.Contains(t, messages[1], "can't evaluate field Commit")
+	assert.Equal(t, `11:13: marker path ".git/config" must not be inside .git`, messages[2])
+	assert.Contains(t, messages[3], "12:17: marker template: ")
+	assert.Equal(t, `16:13: marker path ".drift/" must name a file`, messages[4])
+
+	cfgYAML = `github:
+  repos:
+  - ref: main
+    name: owner/repo
+    marker:
+      path: .drift/last-run.md
+      template: "{{.RunID}}"
+`
+	cfg, err := loadConfig(t, cfgYAML)
+	assert.NoError(t, err)
+	assert.Equal(t, config.MarkerOptions{Path: ".drift/last-run.md", Template: "{{.RunID}}"}, cfg.GithubServer.Repos[0].Marker)
+}
+
+func TestLoadVcsConfigMergeRequest(t *testing.T) {
+	cfgYAML := `github:
+  repos:
+  - ref: main
+    name: owner/repo
+    pull:
+      mergeRequest:
+        draft: true
+gitlab:
+  repos:
+  - ref: main
+    name: group/project
+    pull:
+      mergeRequest:
+        reviewers: [alice, "@bob", alice]
+        assignees: [""]
+        labels: [drift, "a,b"]
+`
+	_, err := loadConfig(t, cfgYAML)
+	assert.Equal(t, []string{
+		"7:9: mergeRequest options are only supported on GitLab",
+		`14:28: reviewer username "@bob" must not start with @`,
+		"14:36: reviewer alice is listed twice",
+		"15:21: assignee username must not be empty",
+		`16:25: label "a,b" must not contain a comma`,
+	}, problems(err))
+
+	cfgYAML = `gitlab:
+  repos:
+  - ref: main
+    name: group/project
+    pull:
+      mergeRequest:
+        reviewers: [alice]
+        labels: [drift]
+        milestone: Q3
+        squash: false
+`
+	cfg, err := loadConfig(t, cfgYAML)
+	assert.NoError(t, err)
+	mr := cfg.GitlabServer.Repos[0].Pull.MergeRequest
+	assert.Equal(t, []string{"alice"}, mr.Reviewers)
+	assert.Equal(t, "Q3", mr.Milestone)
+	assert.Nil(t, mr.RemoveSourceBranch)
+	assert.False(t, *mr.Squash)
+}
+
+func TestLoadVcsConfigCodeowners(t *testing.T) {
+	cfgYAML := `github:
+  repos:
+  - ref: main
+    name: owner/repo
+    codeowners:
+      enabled: true
+      path: /CODEOWNERS
+notifications:
+  webhooks:
+  - name: network
+    url: https://hooks.example.com/network
+    owners: ["@org/network", network]
+`
+	_, err := loadConfig(t, cfgYAML)
+	assert.Equal(t, []string{
+		`7:13: codeowners path "/CODEOWNERS" must be relative to the repo root`,
+		`12:30: webhook owner "network" must be written like in CODEOWNERS: @user, @org/team or an email address`,
+	}, problems(err))
+
+	cfgYAML = `github:
+  repos:
+  - ref: main
+    name: owner/repo
+    codeowners:
+      enabled: true
+      reviewers: false
+`
+	cfg, err := loadConfig(t, cfgYAML)
+	assert.NoError(t, err)
+	assert.True(t, cfg.GithubServer.Repos[0].Codeowners.Enabled)
+	assert.False(t, cfg.GithubServer.Repos[0].Codeowners.RequestReviews())
+	assert.True(t, config.CodeownersOptions{Enabled: true}.RequestReviews())
+}
+
+func TestLoadVcsConfigAudit(t *testing.T) {
+	cfgYAML := `github:
+  repos:
+  - ref: main
+    name: owner/repo
+audit:
+  webhook:
+    value: https://audit.example.com/drift
+`
+	_, err := loadConfig(t, cfgYAML)
+	assert.ErrorContains(t, err, "audit file is required")
+
+	cfgYAML = `github:
+  repos:
+  - ref: main
+    name: owner/repo
+audit:
+  file: /var/log/drift/audit.jsonl
+  webhook:
+    value: https://audit.example.com/drift
+`
+	cfg, err := loadConfig(t, cfgYAML)
+	assert.NoError(t, err)
+	assert.Equal(t, "/var/log/drift/audit.jsonl", cfg.Audit.File)
+	assert.NotNil(t, cfg.Audit.Webhook)
+}
+
+// loadConfig loads cfgYAML from a config file.
+func loadConfig(t *testing.T, cfgYAML string) (*config.VcsServers, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(cfgYAML), 0644))
+	return config.LoadVcsConfig(path)
+}
+
+// problems returns the validation problems of err as strings.
+func problems(err error) []string {
+	var validationErr *config.ValidationError
+	if !errors.As(err, &validationErr) {
+		return nil
+	}
+	var messages []string
+	for _, p := range validationErr.Problems {
+		messages = append(messages, p.String())
+	}
+	return messages
+}
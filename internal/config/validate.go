@@ -0,0 +1,592 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	pathpkg "path"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jukie/atlantis-drift-detection/internal/glob"
+	"github.com/jukie/atlantis-drift-detection/internal/secret"
+	"github.com/jukie/atlantis-drift-detection/internal/vcs"
+	"gopkg.in/yaml.v3"
+)
+
+// Problem is a single issue found in the config file, positioned at the YAML
+// node it refers to.
+type Problem struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%d:%d: %s", p.Line, p.Column, p.Message)
+}
+
+// ValidationError carries every problem found while loading a config file.
+type ValidationError struct {
+	Path     string
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, 0, len(e.Problems)+1)
+	lines = append(lines, fmt.Sprintf("invalid config file %s:", e.Path))
+	for _, p := range e.Problems {
+		lines = append(lines, fmt.Sprintf("  %s:%s", e.Path, p))
+	}
+	return strings.Join(lines, "\n")
+}
+
+var (
+	githubRepoRe = regexp.MustCompile(`^[A-Za-z0-9-]+/[A-Za-z0-9._-]+$`)
+	gitlabRepoRe = regexp.MustCompile(`^([0-9]+|[A-Za-z0-9._-]+(/[A-Za-z0-9._-]+)+)$`)
+	yamlLineRe   = regexp.MustCompile(`^line ([0-9]+): (.*)$`)
+)
+
+// validator collects problems while walking a decoded config alongside the
+// YAML node tree it came from.
+type validator struct {
+	root     *yaml.Node
+	problems []Problem
+}
+
+// addf records a problem at the node found by path, which is a list of
+// mapping keys (string) and sequence indexes (int). When the path does not
+// exist, for example because a required key is missing, the closest existing
+// parent is used.
+func (v *validator) addf(path []interface{}, format string, args ...interface{}) {
+	n := v.lookup(path)
+	v.problems = append(v.problems, Problem{Line: n.Line, Column: n.Column, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) lookup(path []interface{}) *yaml.Node {
+	n := v.root
+	if n.Kind == yaml.DocumentNode && len(n.Content) > 0 {
+		n = n.Content[0]
+	}
+	for _, p := range path {
+		next := child(n, p)
+		if next == nil {
+			break
+		}
+		n = next
+	}
+	return n
+}
+
+func child(n *yaml.Node, p interface{}) *yaml.Node {
+	switch key := p.(type) {
+	case string:
+		if n.Kind != yaml.MappingNode {
+			return nil
+		}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if n.Content[i].Value == key {
+				return n.Content[i+1]
+			}
+		}
+	case int:
+		if n.Kind == yaml.SequenceNode && key < len(n.Content) {
+			return n.Content[key]
+		}
+	}
+	return nil
+}
+
+// at builds a node path for addf.
+func at(parts ...interface{}) []interface{} {
+	return parts
+}
+
+func extend(base []interface{}, parts ...interface{}) []interface{} {
+	return append(append([]interface{}{}, base...), parts...)
+}
+
+// unknownFields reports mapping keys that do not correspond to a field of t.
+func (v *validator) unknownFields(n *yaml.Node, t reflect.Type) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if n.Kind != yaml.MappingNode {
+			return
+		}
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, value := n.Content[i], n.Content[i+1]
+			ft, ok := fields[key.Value]
+			if !ok {
+				v.problems = append(v.problems, Problem{
+					Line:    key.Line,
+					Column:  key.Column,
+					Message: fmt.Sprintf("unknown field %q, expected one of: %s", key.Value, strings.Join(sortedKeys(fields), ", ")),
+				})
+				continue
+			}
+			v.unknownFields(value, ft)
+		}
+	case reflect.Slice:
+		if n.Kind != yaml.SequenceNode {
+			return
+		}
+		for _, item := range n.Content {
+			v.unknownFields(item, t.Elem())
+		}
+	case reflect.Map:
+		if n.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 1; i < len(n.Content); i += 2 {
+			v.unknownFields(n.Content[i], t.Elem())
+		}
+	}
+}
+
+// yamlFields maps the YAML keys of a struct to their field types, following
+// the same naming rules as yaml.v3.
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag := f.Tag.Get("yaml")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if strings.Contains(opts, "inline") {
+			for k, ft := range yamlFields(f.Type) {
+				fields[k] = ft
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+func sortedKeys(m map[string]reflect.Type) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// decodeErrors converts yaml.v3 type errors, which only carry a line number,
+// into problems positioned at the last node on that line, which is the value
+// that failed to decode.
+func (v *validator) decodeErrors(err error) error {
+	typeErr, ok := err.(*yaml.TypeError)
+	if !ok {
+		return err
+	}
+	for _, msg := range typeErr.Errors {
+		m := yamlLineRe.FindStringSubmatch(msg)
+		if m == nil {
+			v.problems = append(v.problems, Problem{Message: msg})
+			continue
+		}
+		line, _ := strconv.Atoi(m[1])
+		v.problems = append(v.problems, Problem{Line: line, Column: lastColumn(v.root, line), Message: m[2]})
+	}
+	return nil
+}
+
+func lastColumn(n *yaml.Node, line int) int {
+	col := 0
+	if n.Line == line && n.Kind != yaml.DocumentNode {
+		col = n.Column
+	}
+	for _, c := range n.Content {
+		if c := lastColumn(c, line); c > col {
+			col = c
+		}
+	}
+	return col
+}
+
+func (v *validator) validate(cfg *VcsServers) {
+	if cfg.GithubServer == nil && cfg.GitlabServer == nil {
+		v.addf(at(), "at least one of github or gitlab must be configured")
+	}
+	v.server(at("github"), cfg.GithubServer, githubRepoRe, "owner/repo")
+	v.server(at("gitlab"), cfg.GitlabServer, gitlabRepoRe, "group/project or a numeric project ID")
+	if cfg.GithubServer != nil {
+		for i, r := range cfg.GithubServer.Repos {
+			if r.Pull.MergeRequest != nil {
+				v.addf(at("github", "repos", i, "pull", "mergeRequest"), "mergeRequest options are only supported on GitLab")
+			}
+		}
+	}
+	if cfg.GithubServer != nil && cfg.GithubServer.Commit.Signing != nil && cfg.GithubServer.Commit.Signing.Format == vcs.SignWeb {
+		v.addf(at("github", "commit", "signing", "format"), "web signing is only supported on GitLab, GitHub commits are signed with a gpg or ssh key")
+	}
+	if cfg.GitlabServer != nil && cfg.GitlabServer.Commit.Signing != nil && cfg.GitlabServer.Commit.Signing.Format != vcs.SignWeb {
+		v.addf(at("gitlab", "commit", "signing", "format"), "the GitLab commits API does not accept signatures, use the web format to have GitLab sign the commits")
+	}
+	if cfg.State != nil {
+		if err := cfg.State.Validate(); err != nil {
+			v.addf(at("state", "backend"), "%v", err)
+		}
+	}
+	if cfg.Acknowledgements != nil {
+		for i, r := range cfg.Acknowledgements.Rules {
+			if err := r.Validate(); err != nil {
+				v.addf(at("acknowledgements", "rules", i), "acknowledgement: %v", err)
+			}
+		}
+	}
+	if cfg.Audit != nil {
+		if cfg.Audit.File == "" {
+			v.addf(at("audit"), "audit file is required")
+		}
+		if cfg.Audit.Webhook != nil {
+			if err := cfg.Audit.Webhook.Validate(); err != nil {
+				v.addf(at("audit", "webhook"), "webhook: %v", err)
+			} else if cfg.Audit.Webhook.Value != "" {
+				if err := validateURL(cfg.Audit.Webhook.Value); err != nil {
+					v.addf(at("audit", "webhook"), "webhook %v", err)
+				}
+			}
+		}
+	}
+	if cfg.Cleanup != nil && cfg.Cleanup.MaxAge < 0 {
+		v.addf(at("cleanup", "maxAge"), "cleanup maxAge must not be negative")
+	}
+	if cfg.Notifications != nil {
+		for i, hook := range cfg.Notifications.Webhooks {
+			hp := at("notifications", "webhooks", i)
+			if hook.Name == "" {
+				v.addf(hp, "webhook name is required")
+			}
+			if hook.URL == nil {
+				v.addf(hp, "webhook url is required")
+			} else if err := hook.URL.Validate(); err != nil {
+				v.addf(extend(hp, "url"), "url: %v", err)
+			} else if hook.URL.Value != "" {
+				if err := validateURL(hook.URL.Value); err != nil {
+					v.addf(extend(hp, "url"), "url %v", err)
+				}
+			}
+			for j, owner := range hook.Owners {
+				if !strings.Contains(owner, "@") || strings.HasSuffix(owner, "@") {
+					v.addf(extend(hp, "owners", j), "webhook owner %q must be written like in CODEOWNERS: @user, @org/team or an email address", owner)
+				}
+			}
+		}
+	}
+}
+
+func (v *validator) server(p []interface{}, s *ServerCfg, nameRe *regexp.Regexp, nameFormat string) {
+	if s == nil {
+		return
+	}
+	if s.ApiEndpoint != "" {
+		if err := validateURL(s.ApiEndpoint); err != nil {
+			v.addf(extend(p, "apiEndpoint"), "apiEndpoint %v", err)
+		}
+	}
+	if s.Token != nil {
+		if err := s.Token.Validate(); err != nil {
+			v.addf(extend(p, "token"), "token: %v", err)
+		}
+	}
+	v.commit(extend(p, "commit"), s.Commit)
+	if len(s.Repos) == 0 {
+		v.addf(extend(p, "repos"), "no repos configured")
+	}
+	seen := map[string]int{}
+	for i, r := range s.Repos {
+		rp := extend(p, "repos", i)
+		switch {
+		case r.Name == "":
+			v.addf(rp, "repo name is required")
+		case !nameRe.MatchString(r.Name):
+			v.addf(extend(rp, "name"), "repo name %q must be in the form %s", r.Name, nameFormat)
+		}
+		switch {
+		case r.Ref == "":
+			v.addf(rp, "repo ref is required")
+		case strings.ContainsAny(r.Ref, " \t~^:?*[\\"):
+			v.addf(extend(rp, "ref"), "repo ref %q is not a valid git ref", r.Ref)
+		}
+		v.filters(extend(rp, "include"), r.Include)
+		v.filters(extend(rp, "exclude"), r.Exclude)
+		v.ignoreRules(extend(rp, "ignore"), r.Ignore)
+		v.handling(extend(rp, "handling"), r.Handling)
+		v.planOptions(extend(rp, "plan"), r.Plan)
+		v.autoApply(extend(rp, "autoApply"), r.AutoApply)
+		v.pullOptions(extend(rp, "pull"), r)
+		v.marker(extend(rp, "marker"), r.Marker)
+		if err := repoFilePath(r.Codeowners.Path); err != nil {
+			v.addf(extend(rp, "codeowners", "path"), "codeowners path %v", err)
+		}
+		if r.CommitStatus.URL != "" {
+			if err := validateURL(r.CommitStatus.URL); err != nil {
+				v.addf(extend(rp, "commitStatus", "url"), "url %v", err)
+			}
+		}
+		key := r.Name + "@" + r.Ref
+		if prev, ok := seen[key]; ok && r.Name != "" {
+			v.addf(rp, "repo %s is already configured at repos[%d]", key, prev)
+		}
+		seen[key] = i
+	}
+}
+
+func (v *validator) filters(p []interface{}, filters []ProjectFilter) {
+	for i, f := range filters {
+		fp := extend(p, i)
+		if f.Name == "" && f.Dir == "" && f.Workspace == "" {
+			v.addf(fp, "filter must set at least one of name, dir or workspace")
+		}
+		for key, pattern := range map[string]string{"name": f.Name, "dir": f.Dir, "workspace": f.Workspace} {
+			if err := glob.Validate(pattern); err != nil {
+				v.addf(extend(fp, key), "%v", err)
+			}
+		}
+	}
+}
+
+func (v *validator) commit(p []interface{}, c CommitConfig) {
+	if (c.AuthorName == "") != (c.AuthorEmail == "") {
+		v.addf(p, "commit authorName and authorEmail must be set together")
+	}
+	if c.AuthorEmail != "" && !strings.Contains(c.AuthorEmail, "@") {
+		v.addf(extend(p, "authorEmail"), "commit authorEmail %q is not an email address", c.AuthorEmail)
+	}
+	if c.Signing == nil {
+		return
+	}
+	sp := extend(p, "signing")
+	if c.Signing.Format == vcs.SignWeb {
+		if c.Signing.Key != nil || c.Signing.Passphrase != nil {
+			v.addf(sp, "web signed commits are signed by GitLab, key and passphrase must not be set")
+		}
+		return
+	}
+	if c.AuthorName == "" && c.AuthorEmail == "" {
+		v.addf(sp, "commit signing requires authorName and authorEmail")
+	}
+	if !slices.Contains(vcs.SignFormats, c.Signing.Format) {
+		v.addf(extend(sp, "format"), "unknown signing format %q, expected one of: %s", c.Signing.Format, strings.Join(vcs.SignFormats, ", "))
+	}
+	if !c.Signing.Key.IsSet() {
+		v.addf(sp, "signing key is required")
+	} else if err := c.Signing.Key.Validate(); err != nil {
+		v.addf(extend(sp, "key"), "key: %v", err)
+	}
+	if c.Signing.Passphrase != nil {
+		if err := c.Signing.Passphrase.Validate(); err != nil {
+			v.addf(extend(sp, "passphrase"), "passphrase: %v", err)
+		}
+	}
+}
+
+func (v *validator) marker(p []interface{}, m MarkerOptions) {
+	if err := repoFilePath(m.Path); err != nil {
+		v.addf(extend(p, "path"), "marker path %v", err)
+	}
+	if m.Template != "" {
+		if _, err := vcs.RenderMarker("", m.Template, vcs.MarkerData{}); err != nil {
+			v.addf(extend(p, "template"), "marker template: %v", err)
+		}
+	}
+}
+
+// repoFilePath checks that a path names a file in the repo, outside of .git.
+// An empty path is valid.
+func repoFilePath(path string) error {
+	cleaned := pathpkg.Clean(path)
+	switch {
+	case path == "":
+	case pathpkg.IsAbs(path) || cleaned == ".." || strings.HasPrefix(cleaned, "../"):
+		return fmt.Errorf("%q must be relative to the repo root", path)
+	case cleaned == "." || strings.HasSuffix(path, "/"):
+		return fmt.Errorf("%q must name a file", path)
+	case cleaned == ".git" || strings.HasPrefix(cleaned, ".git/"):
+		return fmt.Errorf("%q must not be inside .git", path)
+	}
+	return nil
+}
+
+func (v *validator) pullOptions(p []interface{}, r Repo) {
+	if r.Pull.Mode != "" && !slices.Contains(PullModes, r.Pull.Mode) {
+		v.addf(extend(p, "mode"), "unknown pull mode %q, expected one of: %s", r.Pull.Mode, strings.Join(PullModes, ", "))
+	}
+	switch {
+	case r.Pull.Branch == "":
+	case strings.ContainsAny(r.Pull.Branch, " \t~^:?*[\\"):
+		v.addf(extend(p, "branch"), "pull branch %q is not a valid git ref", r.Pull.Branch)
+	case r.Pull.Branch == r.Ref:
+		v.addf(extend(p, "branch"), "pull branch must differ from the repo ref")
+	}
+	if mr := r.Pull.MergeRequest; mr != nil {
+		mp := extend(p, "mergeRequest")
+		v.usernames(extend(mp, "reviewers"), "reviewer", mr.Reviewers)
+		v.usernames(extend(mp, "assignees"), "assignee", mr.Assignees)
+		for i, label := range mr.Labels {
+			switch {
+			case strings.TrimSpace(label) == "":
+				v.addf(extend(mp, "labels", i), "label must not be empty")
+			case strings.Contains(label, ","):
+				v.addf(extend(mp, "labels", i), "label %q must not contain a comma", label)
+			}
+		}
+	}
+}
+
+func (v *validator) usernames(p []interface{}, kind string, names []string) {
+	seen := map[string]bool{}
+	for i, name := range names {
+		switch {
+		case strings.TrimSpace(name) == "":
+			v.addf(extend(p, i), "%s username must not be empty", kind)
+		case strings.HasPrefix(name, "@"):
+			v.addf(extend(p, i), "%s username %q must not start with @", kind, name)
+		case seen[name]:
+			v.addf(extend(p, i), "%s %s is listed twice", kind, name)
+		}
+		seen[name] = true
+	}
+}
+
+func (v *validator) autoApply(p []interface{}, rules []AutoApplyRule) {
+	filters := make([]ProjectFilter, 0, len(rules))
+	for i, r := range rules {
+		filters = append(filters, r.ProjectFilter)
+		if r.MaxChanges < 1 {
+			v.addf(extend(p, i), "auto-apply rule must set maxChanges to at least 1")
+		}
+	}
+	v.filters(p, filters)
+}
+
+func (v *validator) ignoreRules(p []interface{}, rules []IgnoreRule) {
+	for i, r := range rules {
+		rp := extend(p, i)
+		if r.Address == "" && r.Type == "" && len(r.Actions) == 0 {
+			v.addf(rp, "ignore rule must set at least one of address, type or actions")
+		}
+		for key, pattern := range map[string]string{"project": r.Project, "type": r.Type} {
+			if err := glob.Validate(pattern); err != nil {
+				v.addf(extend(rp, key), "%v", err)
+			}
+		}
+		if err := glob.ValidateAddress(r.Address); err != nil {
+			v.addf(extend(rp, "address"), "%v", err)
+		}
+		for j, action := range r.Actions {
+			if !slices.Contains(IgnoreActions, action) {
+				v.addf(extend(rp, "actions", j), "unknown action %q, expected one of: %s", action, strings.Join(IgnoreActions, ", "))
+			}
+		}
+	}
+}
+
+func (v *validator) handling(p []interface{}, h Handling) {
+	for key, mode := range map[string]string{"drift": h.Drift, "pending": h.Pending} {
+		if mode != "" && !slices.Contains(HandlingModes, mode) {
+			v.addf(extend(p, key), "unknown handling %q, expected one of: %s", mode, strings.Join(HandlingModes, ", "))
+		}
+	}
+}
+
+var (
+	// workspaceRe matches the workspace names Atlantis accepts.
+	workspaceRe = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+	// headerNameRe matches an HTTP header field name (RFC 9110 token).
+	headerNameRe = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+	// reservedHeaders are set by the drift detector itself.
+	reservedHeaders = []string{"X-Atlantis-Token", "Content-Type", "Content-Length", "Host"}
+)
+
+func (v *validator) planOptions(p []interface{}, o PlanOptions) {
+	if o.PR < 0 {
+		v.addf(extend(p, "pr"), "pr must be a positive pull request number")
+	}
+	seen := map[string]int{}
+	for i, path := range o.Paths {
+		pp := extend(p, "paths", i)
+		cleaned := pathpkg.Clean(path.Dir)
+		switch {
+		case path.Dir == "":
+			v.addf(pp, "plan path dir is required")
+		case pathpkg.IsAbs(path.Dir) || cleaned == ".." || strings.HasPrefix(cleaned, "../"):
+			v.addf(extend(pp, "dir"), "plan path dir %q must be relative to the repo root", path.Dir)
+		}
+		if path.Workspace != "" && (!workspaceRe.MatchString(path.Workspace) || path.Workspace == "." || path.Workspace == "..") {
+			v.addf(extend(pp, "workspace"), "workspace %q may only contain letters, digits, '_', '.' and '-'", path.Workspace)
+		}
+		workspace := path.Workspace
+		if workspace == "" {
+			workspace = "default"
+		}
+		key := cleaned + " " + workspace
+		if prev, ok := seen[key]; ok && path.Dir != "" {
+			v.addf(pp, "plan path %s in workspace %s is already listed at paths[%d]", path.Dir, workspace, prev)
+		}
+		seen[key] = i
+	}
+	for _, name := range sortedHeaderNames(o.Headers) {
+		hp := extend(p, "headers", name)
+		switch {
+		case !headerNameRe.MatchString(name):
+			v.addf(hp, "%q is not a valid header name", name)
+		case containsFold(reservedHeaders, name):
+			v.addf(hp, "header %s is set by the drift detector and cannot be configured", name)
+		}
+		if src := o.Headers[name]; src == nil || !src.IsSet() {
+			v.addf(hp, "header %s needs a value", name)
+		} else if err := src.Validate(); err != nil {
+			v.addf(hp, "header %s: %v", name, err)
+		}
+	}
+}
+
+func sortedHeaderNames(headers map[string]*secret.Source) []string {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func validateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("is not a valid URL: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%q must use http or https", raw)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%q has no host", raw)
+	}
+	return nil
+}
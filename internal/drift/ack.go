@@ -0,0 +1,24 @@
+package drift
+
+import (
+	"time"
+
+	"github.com/jukie/atlantis-drift-detection/internal/ack"
+)
+
+// applyAcknowledgements marks the drifted and pending projects covered by an active
+// acknowledgement rule so that they get no PR or notification.
+func applyAcknowledgements(set *ack.Set, result *Result, now time.Time) {
+	for i := range result.Projects {
+		p := &result.Projects[i]
+		if p.Status != StatusDrifted && p.Status != StatusPending {
+			continue
+		}
+		rule, ok := set.Match(result.Repo, projectName(*p), Addresses(p.Changes), now)
+		if !ok {
+			continue
+		}
+		p.Status = StatusAcknowledged
+		p.Acknowledgement = rule
+	}
+}
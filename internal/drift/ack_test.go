@@ -0,0 +1,80 @@
+package drift_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jukie/atlantis-drift-detection/internal/ack"
+	"github.com/jukie/atlantis-drift-detection/internal/config"
+	"github.com/jukie/atlantis-drift-detection/internal/drift"
+	"github.com/jukie/atlantis-drift-detection/internal/secret"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunAcknowledged(t *testing.T) {
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"ProjectResults": [
+			{"PlanSuccess": {"TerraformOutput": "  # aws_autoscaling_group.web will be updated in-place\nPlan: 0 to add, 1 to change, 0 to destroy."}, "ProjectName": "compute"},
+			{"PlanSuccess": {"TerraformOutput": "  # aws_instance.db will be updated in-place\nPlan: 0 to add, 1 to change, 0 to destroy."}, "ProjectName": "database"}
+		]}`))
+	})
+	testServer := httptest.NewServer(testHandler)
+	defer testServer.Close()
+
+	acks, err := ack.NewSet(ack.Config{Rules: []ack.Rule{{
+		Repo:     "test-repo",
+		Project:  "*",
+		Resource: "aws_autoscaling_group.*",
+		Expires:  time.Now().Add(time.Hour),
+		Reason:   "Scaled manually during INC-123",
+	}}})
+	assert.NoError(t, err)
+
+	client := &MockClient{}
+	driftCfg := config.DriftCfg{
+		AtlantisUrl:      testServer.URL,
+		AtlantisToken:    secret.Literal("test-token"),
+		Acknowledgements: acks,
+	}
+	result, err := drift.Run(client, config.Repo{Name: "test-repo", Ref: "test-ref"}, driftCfg)
+	assert.NoError(t, err)
+
+	assert.Equal(t, drift.StatusAcknowledged, result.Projects[0].Status)
+	assert.Equal(t, "Scaled manually during INC-123", result.Projects[0].Acknowledgement.Reason)
+	assert.Equal(t, drift.StatusPending, result.Projects[1].Status)
+	assert.Equal(t, []string{"database"}, result.Actionable(config.Handling{}))
+	assert.Equal(t, 1, client.comments)
+}
+
+func TestRunAcknowledgedUnnamedProjects(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"ProjectResults": [
+			{"PlanSuccess": {"TerraformOutput": "  # aws_instance.web will be updated in-place\nPlan: 0 to add, 1 to change, 0 to destroy."}, "RepoRelDir": "."},
+			{"PlanSuccess": {"TerraformOutput": "  # aws_vpc.main will be updated in-place\nPlan: 0 to add, 1 to change, 0 to destroy."}, "RepoRelDir": "network"}
+		]}`))
+	}))
+	defer testServer.Close()
+
+	// Projects without a name are acknowledged by their directory alone.
+	acks, err := ack.NewSet(ack.Config{Rules: []ack.Rule{{
+		Repo:    "test-repo",
+		Project: "network",
+		Expires: time.Now().Add(time.Hour),
+		Reason:  "Migrating the VPC",
+	}}})
+	assert.NoError(t, err)
+
+	driftCfg := config.DriftCfg{
+		AtlantisUrl:      testServer.URL,
+		AtlantisToken:    secret.Literal("test-token"),
+		Acknowledgements: acks,
+	}
+	result, err := drift.Run(&MockClient{}, config.Repo{Name: "test-repo", Ref: "test-ref"}, driftCfg)
+	assert.NoError(t, err)
+
+	assert.Equal(t, drift.StatusPending, result.Projects[0].Status)
+	assert.Equal(t, drift.StatusAcknowledged, result.Projects[1].Status)
+	assert.Equal(t, "Migrating the VPC", result.Projects[1].Acknowledgement.Reason)
+}
@@ -0,0 +1,189 @@
+package drift
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jukie/atlantis-drift-detection/internal/audit"
+	"github.com/jukie/atlantis-drift-detection/internal/config"
+	"github.com/jukie/atlantis-drift-detection/internal/logging"
+	"github.com/jukie/atlantis-drift-detection/internal/vcs"
+)
+
+// ApplyStatus is the outcome of automatically applying a project's drift.
+type ApplyStatus string
+
+const (
+	ApplyApplied ApplyStatus = "applied"
+	ApplyFailed  ApplyStatus = "failed"
+	// ApplySkipped marks projects whose plan exceeds the limits of their
+	// auto-apply rule.
+	ApplySkipped ApplyStatus = "skipped"
+	// ApplyDryRun marks projects that would have been applied.
+	ApplyDryRun ApplyStatus = "dry-run"
+)
+
+// ApplyOutcome records an automatic apply of a project.
+type ApplyOutcome struct {
+	Status ApplyStatus `json:"status"`
+	// Reason explains a skipped or failed apply.
+	Reason string `json:"reason,omitempty"`
+	Output string `json:"output,omitempty"`
+}
+
+// resolved reports whether the apply resolved the drift, or would have in a
+// dry run.
+func (o *ApplyOutcome) resolved() bool {
+	return o != nil && (o.Status == ApplyApplied || o.Status == ApplyDryRun)
+}
+
+type ApplyApiResponse struct {
+	Error          interface{}
+	Failure        string
+	ProjectResults []struct {
+		RepoRelDir   string
+		Workspace    string
+		ProjectName  string
+		Error        interface{}
+		Failure      string
+		ApplySuccess string
+	}
+}
+
+// autoApply applies the actionable drifted projects matching an auto-apply
+// rule of the repo through Atlantis and records the outcome on each project.
+// It reports whether any project has an outcome.
+func autoApply(client vcs.Client, repo config.Repo, driftCfg config.DriftCfg, result *Result) bool {
+	if len(repo.AutoApply) == 0 {
+		return false
+	}
+	log := logger(driftCfg, client.VcsType(), repo)
+	applied := false
+	for i := range result.Projects {
+		p := &result.Projects[i]
+		if p.Status != StatusDrifted || p.Suppressed {
+			continue
+		}
+		rule, ok := autoApplyRule(repo.AutoApply, *p)
+		if !ok {
+			continue
+		}
+		applied = true
+		if reason := exceedsLimits(rule, *p); reason != "" {
+			log.Info("Not applying automatically", logging.KeyProject, projectName(*p), "reason", reason)
+			p.AutoApply = &ApplyOutcome{Status: ApplySkipped, Reason: reason}
+			continue
+		}
+		if driftCfg.DryRun {
+			log.Info("Dry run: would apply automatically", logging.KeyProject, projectName(*p))
+			p.AutoApply = &ApplyOutcome{Status: ApplyDryRun}
+			continue
+		}
+		log.Info("Applying automatically", logging.KeyProject, projectName(*p))
+		p.AutoApply = applyProject(client, repo, driftCfg, *p)
+		event := auditEvent(audit.ActionApply, client.VcsType(), repo)
+		event.Projects = []string{projectName(*p)}
+		var err error
+		if p.AutoApply.Status == ApplyFailed {
+			err = errors.New(p.AutoApply.Reason)
+		}
+		record(driftCfg, event, err)
+	}
+	return applied
+}
+
+func autoApplyRule(rules []config.AutoApplyRule, p ProjectResult) (config.AutoApplyRule, bool) {
+	path := Path{Name: p.Name, Directory: p.Dir, Workspace: p.Workspace}
+	for _, r := range rules {
+		if matches(r.ProjectFilter, path) {
+			return r, true
+		}
+	}
+	return config.AutoApplyRule{}, false
+}
+
+// exceedsLimits returns why the project's plan may not be applied under rule,
+// or "" when it may. Ignored changes count as well since they are applied too.
+func exceedsLimits(rule config.AutoApplyRule, p ProjectResult) string {
+	changes := append(append([]ResourceChange{}, p.Changes...), p.Ignored...)
+	if len(changes) == 0 {
+		return "the plan could not be parsed into resource changes"
+	}
+	var deletes, replaces int
+	for _, c := range changes {
+		switch c.Action {
+		case config.ActionDelete:
+			deletes++
+		case config.ActionReplace:
+			replaces++
+		}
+	}
+	switch {
+	case deletes > 0 && !rule.AllowDestroy:
+		return fmt.Sprintf("the plan destroys %d resource(s)", deletes)
+	case replaces > 0 && !rule.AllowReplace:
+		return fmt.Sprintf("the plan replaces %d resource(s)", replaces)
+	case len(changes) > rule.MaxChanges:
+		return fmt.Sprintf("the plan changes %d resources, more than the limit of %d", len(changes), rule.MaxChanges)
+	}
+	return ""
+}
+
+func applyProject(client vcs.Client, repo config.Repo, driftCfg config.DriftCfg, p ProjectResult) *ApplyOutcome {
+	failed := func(err error) *ApplyOutcome {
+		logger(driftCfg, client.VcsType(), repo).Error("Applying failed", logging.KeyProject, projectName(p), logging.Err(err))
+		return &ApplyOutcome{Status: ApplyFailed, Reason: err.Error()}
+	}
+	token, err := driftCfg.AtlantisToken.Get()
+	if err != nil {
+		return failed(fmt.Errorf("resolving Atlantis token: %w", err))
+	}
+	headers, err := planHeaders(repo)
+	if err != nil {
+		return failed(err)
+	}
+	req, err := BuildPlanReq(repo.Name, repo.Ref, client.VcsType(), repo.Plan.PR, []Path{{Directory: p.Dir, Workspace: p.Workspace}})
+	if err != nil {
+		return failed(err)
+	}
+	var resp ApplyApiResponse
+	if err := httpPost(driftCfg.AtlantisUrl+"/api/apply", token, headers, req, &resp); err != nil {
+		return failed(err)
+	}
+	if resp.Error != nil || resp.Failure != "" {
+		return failed(fmt.Errorf("%v %s", resp.Error, resp.Failure))
+	}
+	var output []string
+	for _, r := range resp.ProjectResults {
+		if r.Error != nil || r.Failure != "" {
+			msg := fmt.Sprint(r.Error)
+			if r.Failure != "" {
+				msg = strings.TrimPrefix(msg+": "+r.Failure, "<nil>: ")
+			}
+			return failed(fmt.Errorf("%s", msg))
+		}
+		output = append(output, r.ApplySuccess)
+	}
+	if len(output) == 0 {
+		return failed(fmt.Errorf("Atlantis applied no project for %s", p.Dir))
+	}
+	return &ApplyOutcome{Status: ApplyApplied, Output: strings.Join(output, "\n")}
+}
+
+// ApplyComment summarizes the auto-apply outcomes for the drift PR.
+func ApplyComment(result Result) string {
+	var b strings.Builder
+	b.WriteString("Drift auto-apply results:\n")
+	for _, p := range result.Projects {
+		if p.AutoApply == nil {
+			continue
+		}
+		fmt.Fprintf(&b, "\n- **%s**: %s", projectName(p), p.AutoApply.Status)
+		if p.AutoApply.Reason != "" {
+			fmt.Fprintf(&b, ", %s", p.AutoApply.Reason)
+		}
+	}
+	b.WriteString("\n")
+	return b.String()
+}
@@ -0,0 +1,161 @@
+package drift_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/jukie/atlantis-drift-detection/internal/atlantistest"
+	"github.com/jukie/atlantis-drift-detection/internal/config"
+	"github.com/jukie/atlantis-drift-detection/internal/drift"
+	"github.com/jukie/atlantis-drift-detection/internal/secret"
+	"github.com/jukie/atlantis-drift-detection/internal/state"
+	"github.com/stretchr/testify/assert"
+)
+
+// commentingClient records the comments posted through vcs.Commenter. Its
+// plan comments lock the commented projects in atlantis, whose directories
+// are named like the projects.
+type commentingClient struct {
+	atlantisCfgClient
+	atlantis *atlantistest.Server
+	bodies   []string
+}
+
+func (c *commentingClient) CommentOnPull(repo string, pull int, driftedProjects []string) error {
+	for _, p := range driftedProjects {
+		c.atlantis.Lock(p, pull)
+	}
+	return c.MockClient.CommentOnPull(repo, pull, driftedProjects)
+}
+
+func (c *commentingClient) Comment(repo string, pull int, body string) error {
+	c.bodies = append(c.bodies, body)
+	return nil
+}
+
+// driftPlan is a text plan in which every address in changes drifted and is
+// changed back with the given action.
+func driftPlan(changes ...[2]string) string {
+	out := "Note: Objects have changed outside of Terraform\n\n"
+	for _, c := range changes {
+		out += "  # " + c[0] + " has changed\n"
+	}
+	out += "\nTerraform will perform the following actions:\n\n"
+	for _, c := range changes {
+		out += "  # " + c[0] + " " + c[1] + "\n"
+	}
+	return out + "\nPlan: 0 to add, 1 to change, 0 to destroy."
+}
+
+func TestRunAutoApply(t *testing.T) {
+	const atlantisCfg = "version: 3\nprojects:\n- name: network\n  dir: network\n- name: compute\n  dir: compute\n  workspace: prod\n- name: database\n  dir: database\n"
+	repo := config.Repo{Name: "owner/repo", Ref: "main", AutoApply: []config.AutoApplyRule{
+		{ProjectFilter: config.ProjectFilter{Name: "network"}, MaxChanges: 1},
+		{ProjectFilter: config.ProjectFilter{Name: "*"}, MaxChanges: 10, AllowReplace: true},
+	}}
+	setup := func(t *testing.T) (*atlantistest.Server, *commentingClient, config.DriftCfg) {
+		atlantis := atlantistest.NewServer("test-token")
+		t.Cleanup(atlantis.Close)
+		atlantis.SetPlan("network", atlantistest.Drift("network", driftPlan(
+			[2]string{"aws_route.a", "will be updated in-place"},
+			[2]string{"aws_route.b", "will be updated in-place"},
+		)))
+		atlantis.SetPlan("compute", atlantistest.Drift("compute", driftPlan(
+			[2]string{"aws_instance.web", "must be replaced"},
+		)))
+		atlantis.SetPlan("database", atlantistest.Drift("database", driftPlan(
+			[2]string{"aws_db_instance.main", "will be destroyed"},
+		)))
+		driftCfg := config.DriftCfg{AtlantisUrl: atlantis.URL, AtlantisToken: secret.Literal("test-token")}
+		return atlantis, &commentingClient{atlantisCfgClient: atlantisCfgClient{atlantisCfg: atlantisCfg}, atlantis: atlantis}, driftCfg
+	}
+
+	t.Run("apply", func(t *testing.T) {
+		atlantis, client, driftCfg := setup(t)
+
+		result, err := drift.Run(client, repo, driftCfg)
+		assert.NoError(t, err)
+		assert.Equal(t, &drift.ApplyOutcome{Status: drift.ApplySkipped, Reason: "the plan changes 2 resources, more than the limit of 1"}, result.Projects[0].AutoApply)
+		assert.Equal(t, &drift.ApplyOutcome{Status: drift.ApplyApplied, Output: atlantistest.ApplyOutput}, result.Projects[1].AutoApply)
+		assert.Equal(t, &drift.ApplyOutcome{Status: drift.ApplySkipped, Reason: "the plan destroys 1 resource(s)"}, result.Projects[2].AutoApply)
+		assert.Equal(t, []atlantistest.PlanRequest{{
+			Repository: "owner/repo",
+			Ref:        "main",
+			Type:       "github",
+			Paths:      []atlantistest.Path{{Directory: "compute", Workspace: "prod"}},
+		}}, atlantis.Applies())
+
+		// The applied project is left out of the PR, and the skipped ones
+		// are not marked as handled by it so that later runs retry them.
+		assert.Equal(t, 1, client.pulls)
+		assert.Contains(t, client.body, "<summary>network</summary>")
+		assert.NotContains(t, client.body, "<summary>compute</summary>")
+		for _, p := range result.Projects {
+			assert.Empty(t, p.PullURL, p.Name)
+		}
+		assert.Len(t, client.bodies, 1)
+		assert.Contains(t, client.bodies[0], "- **compute**: applied")
+		assert.Contains(t, client.bodies[0], "- **database**: skipped, the plan destroys 1 resource(s)")
+	})
+
+	t.Run("applied only", func(t *testing.T) {
+		atlantis, client, driftCfg := setup(t)
+		atlantis.SetPlan("network", atlantistest.NoChanges("network"))
+		atlantis.SetPlan("database", atlantistest.NoChanges("database"))
+
+		result, err := drift.Run(client, repo, driftCfg)
+		assert.NoError(t, err)
+		assert.Equal(t, drift.ApplyApplied, result.Projects[1].AutoApply.Status)
+		assert.Empty(t, result.Actionable(repo.Handling))
+		assert.Equal(t, 0, client.pulls)
+		assert.Empty(t, client.bodies)
+	})
+
+	t.Run("apply error", func(t *testing.T) {
+		atlantis, client, driftCfg := setup(t)
+		atlantis.FailApply("compute", "exit status 1")
+
+		result, err := drift.Run(client, repo, driftCfg)
+		assert.NoError(t, err)
+		assert.Equal(t, &drift.ApplyOutcome{Status: drift.ApplyFailed, Reason: "exit status 1"}, result.Projects[1].AutoApply)
+		assert.Contains(t, client.body, "<summary>compute</summary>")
+	})
+
+	t.Run("failed apply is retried", func(t *testing.T) {
+		atlantis, client, driftCfg := setup(t)
+		atlantis.FailApply("compute", "exit status 1")
+		store, err := state.OpenFile(filepath.Join(t.TempDir(), "state.jsonl"))
+		assert.NoError(t, err)
+		defer store.Close()
+		driftCfg.Store = store
+
+		_, err = drift.Run(client, repo, driftCfg)
+		assert.NoError(t, err)
+		result, err := drift.Run(client, repo, driftCfg)
+		assert.NoError(t, err)
+		assert.False(t, result.Projects[1].Suppressed)
+		assert.Len(t, atlantis.Applies(), 2)
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		atlantis, client, driftCfg := setup(t)
+		driftCfg.DryRun = true
+
+		result, err := drift.Run(client, repo, driftCfg)
+		assert.NoError(t, err)
+		assert.Equal(t, &drift.ApplyOutcome{Status: drift.ApplyDryRun}, result.Projects[1].AutoApply)
+		assert.Empty(t, atlantis.Applies())
+		assert.Empty(t, client.bodies)
+	})
+
+	t.Run("pending changes", func(t *testing.T) {
+		atlantis, client, driftCfg := setup(t)
+		atlantis.SetPlan("compute", atlantistest.Drift("compute", "  # aws_instance.web will be updated in-place\nPlan: 0 to add, 1 to change, 0 to destroy."))
+
+		result, err := drift.Run(client, repo, driftCfg)
+		assert.NoError(t, err)
+		assert.Equal(t, drift.StatusPending, result.Projects[1].Status)
+		assert.Nil(t, result.Projects[1].AutoApply)
+		assert.Empty(t, atlantis.Applies())
+	})
+}
@@ -0,0 +1,74 @@
+package drift
+
+import (
+	"github.com/jukie/atlantis-drift-detection/internal/audit"
+	"github.com/jukie/atlantis-drift-detection/internal/config"
+	"github.com/jukie/atlantis-drift-detection/internal/logging"
+	"github.com/jukie/atlantis-drift-detection/internal/vcs"
+)
+
+// auditEvent starts an audit event about an action on the repo.
+func auditEvent(action, vcsType string, repo config.Repo) audit.Event {
+	return audit.Event{Action: action, Vcs: vcsType, Repo: repo.Name, Ref: repo.Ref}
+}
+
+// pathName names a planned path like projectName names its result.
+func pathName(p Path) string {
+	if p.Name != "" {
+		return p.Name
+	}
+	return p.Directory
+}
+
+// recordPull records the changes made to open a drift PR: the branch, the
+// drift marker commit and the PR, one event each. A failure is recorded on
+// the marker commit when none was made, and on the PR otherwise.
+func recordPull(driftCfg config.DriftCfg, vcsType string, repo config.Repo, projects []string, marker vcs.Marker, changes vcs.PullChanges, err error) {
+	event := func(action string) audit.Event {
+		e := auditEvent(action, vcsType, repo)
+		e.Projects, e.Branch = projects, changes.Branch
+		return e
+	}
+	if changes.Commit == "" {
+		commit := event(audit.ActionCommit)
+		commit.Detail = "drift marker commit of " + marker.Path
+		record(driftCfg, commit, err)
+		return
+	}
+	if changes.Committed {
+		branch := event(audit.ActionBranch)
+		branch.Detail = "reset to " + repo.Ref
+		if changes.BranchCreated {
+			branch.Detail = "created from " + repo.Ref
+		}
+		record(driftCfg, branch, nil)
+		commit := event(audit.ActionCommit)
+		commit.Commit, commit.Detail = changes.Commit, "drift marker commit of "+marker.Path
+		record(driftCfg, commit, nil)
+	}
+	pull := event(audit.ActionPull)
+	pull.PullURL = changes.URL
+	switch {
+	case err != nil:
+	case changes.PullCreated:
+		pull.Detail = "opened"
+	default:
+		pull.Detail = "updated"
+	}
+	record(driftCfg, pull, err)
+}
+
+// record completes e with the run and the outcome err of the action and
+// appends it to the audit log, when one is configured. Failing to record is
+// logged but does not fail the action.
+func record(driftCfg config.DriftCfg, e audit.Event, err error) {
+	if driftCfg.Audit == nil {
+		return
+	}
+	e = e.WithResult(err)
+	e.RunID = driftCfg.RunID
+	e.DryRun = driftCfg.DryRun
+	if recErr := driftCfg.Audit.Record(e); recErr != nil {
+		logging.Repo(e.RunID, e.Vcs, e.Repo, e.Ref).Error("Recording in the audit log failed", "action", e.Action, logging.Err(recErr))
+	}
+}
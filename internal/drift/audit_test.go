@@ -0,0 +1,132 @@
+package drift_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jukie/atlantis-drift-detection/internal/ack"
+	"github.com/jukie/atlantis-drift-detection/internal/audit"
+	"github.com/jukie/atlantis-drift-detection/internal/config"
+	"github.com/jukie/atlantis-drift-detection/internal/drift"
+	"github.com/jukie/atlantis-drift-detection/internal/secret"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingAudit keeps the events recorded in the audit log.
+type recordingAudit struct {
+	events []audit.Event
+}
+
+func (a *recordingAudit) Record(e audit.Event) error {
+	a.events = append(a.events, e)
+	return nil
+}
+
+func (a *recordingAudit) Close() error {
+	return nil
+}
+
+func (a *recordingAudit) actions() []string {
+	var actions []string
+	for _, e := range a.events {
+		actions = append(actions, e.Action)
+	}
+	return actions
+}
+
+func TestRunAudit(t *testing.T) {
+	fail := false
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			http.Error(w, "boom", http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte(`{"ProjectResults": [
+			{"PlanSuccess": {"TerraformOutput": "  # aws_autoscaling_group.web will be updated in-place\nPlan: 0 to add, 1 to change, 0 to destroy."}, "ProjectName": "compute"},
+			{"PlanSuccess": {"TerraformOutput": "  # aws_instance.db will be updated in-place\nPlan: 0 to add, 1 to change, 0 to destroy."}, "ProjectName": "database"}
+		]}`))
+	}))
+	defer testServer.Close()
+
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	acks, err := ack.NewSet(ack.Config{Rules: []ack.Rule{{
+		Repo:     "test-repo",
+		Project:  "compute",
+		Resource: "aws_autoscaling_group.*",
+		Expires:  expires,
+		Reason:   "Scaled manually during INC-123",
+	}}})
+	assert.NoError(t, err)
+
+	log := &recordingAudit{}
+	driftCfg := config.DriftCfg{
+		AtlantisUrl:      testServer.URL,
+		AtlantisToken:    secret.Literal("test-token"),
+		Acknowledgements: acks,
+		Audit:            log,
+		RunID:            "abc123",
+	}
+	repo := config.Repo{Name: "test-repo", Ref: "test-ref"}
+	_, err = drift.Run(&MockClient{}, repo, driftCfg)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{audit.ActionPlan, audit.ActionAcknowledge, audit.ActionBranch, audit.ActionCommit, audit.ActionPull, audit.ActionComment}, log.actions())
+	for _, e := range log.events {
+		assert.Equal(t, "abc123", e.RunID)
+		assert.Equal(t, "github", e.Vcs)
+		assert.Equal(t, "test-repo", e.Repo)
+		assert.Equal(t, "test-ref", e.Ref)
+		assert.Equal(t, audit.ResultSuccess, e.Result)
+	}
+	assert.Equal(t, []string{"compute"}, log.events[1].Projects)
+	assert.Equal(t, "acknowledged until 2030-01-02T03:04:05Z: Scaled manually during INC-123", log.events[1].Detail)
+	assert.Equal(t, "atlantis-drift-abc123", log.events[2].Branch)
+	assert.Equal(t, "created from test-ref", log.events[2].Detail)
+	assert.Equal(t, "atlantis-drift-abc123", log.events[3].Branch)
+	assert.Equal(t, "def456", log.events[3].Commit)
+	assert.Equal(t, []string{"database"}, log.events[4].Projects)
+	assert.Equal(t, "atlantis-drift-abc123", log.events[4].Branch)
+	assert.Equal(t, "https://example.com/pull/1", log.events[4].PullURL)
+	assert.Equal(t, "opened", log.events[4].Detail)
+
+	// A PR that cannot be opened fails only its own event.
+	log.events = nil
+	_, err = drift.Run(&MockClient{pullErr: errors.New("boom")}, repo, driftCfg)
+	assert.Error(t, err)
+	assert.Equal(t, []string{audit.ActionPlan, audit.ActionAcknowledge, audit.ActionBranch, audit.ActionCommit, audit.ActionPull}, log.actions())
+	assert.Equal(t, audit.ResultSuccess, log.events[3].Result)
+	assert.Equal(t, audit.ResultFailure, log.events[4].Result)
+	assert.Equal(t, "boom", log.events[4].Error)
+
+	// Failed requests are recorded too, and dry runs are marked.
+	log.events = nil
+	fail = true
+	driftCfg.DryRun = true
+	_, err = drift.Run(&MockClient{}, repo, driftCfg)
+	assert.Error(t, err)
+	assert.Len(t, log.events, 1)
+	assert.Equal(t, audit.ActionPlan, log.events[0].Action)
+	assert.Equal(t, audit.ResultFailure, log.events[0].Result)
+	assert.True(t, log.events[0].DryRun)
+	assert.NotEmpty(t, log.events[0].Error)
+}
+
+// failingAudit cannot record anything.
+type failingAudit struct{}
+
+func (failingAudit) Record(audit.Event) error { return errors.New("disk full") }
+func (failingAudit) Close() error             { return nil }
+
+func TestRunAuditFailureDoesNotFailRun(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"ProjectResults": [{"PlanSuccess": {"TerraformOutput": "No changes."}, "ProjectName": "compute"}]}`))
+	}))
+	defer testServer.Close()
+
+	driftCfg := config.DriftCfg{AtlantisUrl: testServer.URL, AtlantisToken: secret.Literal("test-token"), Audit: failingAudit{}}
+	_, err := drift.Run(&MockClient{}, config.Repo{Name: "test-repo", Ref: "test-ref"}, driftCfg)
+	assert.NoError(t, err)
+}
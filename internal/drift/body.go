@@ -0,0 +1,164 @@
+package drift
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jukie/atlantis-drift-detection/internal/config"
+)
+
+// planSummaryRe matches the summary line of a Terraform plan.
+var planSummaryRe = regexp.MustCompile(`Plan: (\d+) to add, (\d+) to change, (\d+) to destroy`)
+
+// truncatedMarker ends plan output that was shortened to fit the PR body.
+const truncatedMarker = "\n... (truncated, see the full report)"
+
+// reportURL is the configured report URL of the run, with "{runId}" replaced
+// by the run ID.
+func reportURL(driftCfg config.DriftCfg) string {
+	return strings.ReplaceAll(driftCfg.ReportURL, "{runId}", driftCfg.RunID)
+}
+
+// PullBody renders the markdown description of the drift PR: a table of the
+// projects the PR handles with their change counts, followed by their plan
+// output in collapsible sections. Outputs are shortened so that the body
+// stays within limit bytes, the longest outputs first.
+func PullBody(result Result, h config.Handling, reportURL string, limit int) string {
+	var projects []ProjectResult
+	for _, p := range result.Projects {
+		if inPull(h, p) {
+			projects = append(projects, p)
+		}
+	}
+
+	var head strings.Builder
+	fmt.Fprintf(&head, "Atlantis found drift in `%s@%s`.\n\n", result.Repo, result.Ref)
+	head.WriteString("| Project | Dir | Workspace | Status | Add | Change | Destroy |\n")
+	head.WriteString("|---------|-----|-----------|--------|----:|-------:|--------:|\n")
+	for _, p := range projects {
+		add, change, destroy := changeCounts(p)
+		fmt.Fprintf(&head, "| %s | %s | %s | %s | %d | %d | %d |\n", projectName(p), p.Dir, p.Workspace, p.Status, add, change, destroy)
+	}
+	foot := ""
+	if reportURL != "" {
+		foot = fmt.Sprintf("\nFull report: %s\n", reportURL)
+	}
+
+	opens := make([]string, len(projects))
+	outputs := make([]string, len(projects))
+	const closing = "\n```\n\n</details>\n"
+	budget := limit - head.Len() - len(foot)
+	for i, p := range projects {
+		opens[i] = fmt.Sprintf("\n<details><summary>%s</summary>\n\n```diff\n", projectName(p))
+		outputs[i] = trimOutput(p.Output)
+		if outputs[i] == "" {
+			// JSON plans have no text output to show, only their changes.
+			var lines []string
+			for _, c := range p.Changes {
+				lines = append(lines, c.Action+" "+c.Address)
+			}
+			outputs[i] = strings.Join(lines, "\n")
+		}
+		budget -= len(opens[i]) + len(closing)
+	}
+	if budget < 0 {
+		return truncateBody(head.String()+foot, limit)
+	}
+
+	// Short outputs are kept whole and leave their unused share to the
+	// longer ones.
+	order := make([]int, len(outputs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return len(outputs[order[a]]) < len(outputs[order[b]]) })
+	for n, i := range order {
+		share := budget / (len(order) - n)
+		outputs[i] = shorten(outputs[i], share)
+		budget -= len(outputs[i])
+	}
+
+	var b strings.Builder
+	b.WriteString(head.String())
+	for i := range projects {
+		b.WriteString(opens[i])
+		b.WriteString(outputs[i])
+		b.WriteString(closing)
+	}
+	b.WriteString(foot)
+	return b.String()
+}
+
+// changeCounts returns the add, change and destroy counts of the project's
+// plan, from Terraform's summary line when the output has one.
+func changeCounts(p ProjectResult) (add, change, destroy int) {
+	if m := planSummaryRe.FindStringSubmatch(p.Output); m != nil {
+		add, _ = strconv.Atoi(m[1])
+		change, _ = strconv.Atoi(m[2])
+		destroy, _ = strconv.Atoi(m[3])
+		return add, change, destroy
+	}
+	for _, c := range append(append([]ResourceChange{}, p.Changes...), p.Ignored...) {
+		switch c.Action {
+		case config.ActionCreate:
+			add++
+		case config.ActionUpdate:
+			change++
+		case config.ActionDelete:
+			destroy++
+		case config.ActionReplace:
+			add++
+			destroy++
+		}
+	}
+	return add, change, destroy
+}
+
+// trimOutput removes colors, progress output and any JSON plan from Terraform
+// output.
+func trimOutput(output string) string {
+	output = ansiRe.ReplaceAllString(output, "")
+	if i := strings.Index(output, jsonPlanMarker); i >= 0 {
+		output = output[:i]
+	}
+	var lines []string
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if planNoiseRe.MatchString(strings.TrimSpace(line)) {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return strings.Trim(strings.Join(lines, "\n"), "\n")
+}
+
+// shorten cuts output at a line break so that it fits into n bytes together
+// with truncatedMarker.
+func shorten(output string, n int) string {
+	if len(output) <= n {
+		return output
+	}
+	n -= len(truncatedMarker)
+	if n <= 0 {
+		return ""
+	}
+	cut := strings.LastIndex(output[:n], "\n")
+	if cut < 0 {
+		cut = 0
+	}
+	return output[:cut] + truncatedMarker
+}
+
+// truncateBody cuts body to limit bytes at a line break.
+func truncateBody(body string, limit int) string {
+	if len(body) <= limit {
+		return body
+	}
+	if cut := strings.LastIndex(body[:limit], "\n"); cut >= 0 {
+		return body[:cut+1]
+	}
+	return ""
+}
@@ -0,0 +1,93 @@
+package drift_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jukie/atlantis-drift-detection/internal/atlantistest"
+	"github.com/jukie/atlantis-drift-detection/internal/config"
+	"github.com/jukie/atlantis-drift-detection/internal/drift"
+	"github.com/jukie/atlantis-drift-detection/internal/secret"
+	"github.com/stretchr/testify/assert"
+)
+
+func bodyResult() drift.Result {
+	return drift.Result{Repo: "owner/repo", Ref: "main", Projects: []drift.ProjectResult{
+		{Name: "network", Dir: "network", Workspace: "default", Status: drift.StatusClean},
+		{
+			Name:      "compute",
+			Dir:       "compute",
+			Workspace: "prod",
+			Status:    drift.StatusDrifted,
+			Output:    "aws_instance.web: Refreshing state... [id=i-123]\n\n  # aws_instance.web will be updated in-place\nPlan: 0 to add, 1 to change, 0 to destroy.\n",
+		},
+		{
+			Name:    "database",
+			Dir:     "database",
+			Status:  drift.StatusPending,
+			Output:  `{"format_version":"1.1"}`,
+			Changes: []drift.ResourceChange{{Address: "aws_db_instance.main", Action: config.ActionReplace}},
+		},
+		{Name: "legacy", Dir: "legacy", Status: drift.StatusDrifted, Suppressed: true},
+	}}
+}
+
+func TestPullBody(t *testing.T) {
+	body := drift.PullBody(bodyResult(), config.Handling{}, "https://drift.example.com/runs/abc", 65536)
+	assert.Equal(t, "Atlantis found drift in `owner/repo@main`.\n\n"+
+		"| Project | Dir | Workspace | Status | Add | Change | Destroy |\n"+
+		"|---------|-----|-----------|--------|----:|-------:|--------:|\n"+
+		"| compute | compute | prod | drifted | 0 | 1 | 0 |\n"+
+		"| database | database |  | pending | 1 | 0 | 1 |\n"+
+		"\n<details><summary>compute</summary>\n\n```diff\n"+
+		"  # aws_instance.web will be updated in-place\nPlan: 0 to add, 1 to change, 0 to destroy."+
+		"\n```\n\n</details>\n"+
+		"\n<details><summary>database</summary>\n\n```diff\n"+
+		"replace aws_db_instance.main"+
+		"\n```\n\n</details>\n"+
+		"\nFull report: https://drift.example.com/runs/abc\n", body)
+
+	body = drift.PullBody(bodyResult(), config.Handling{Pending: config.HandlingReport}, "", 65536)
+	assert.NotContains(t, body, "database")
+	assert.NotContains(t, body, "Full report")
+}
+
+func TestPullBodyTruncated(t *testing.T) {
+	result := bodyResult()
+	result.Projects[1].Output = strings.Repeat("  ~ tags = {}\n", 1000)
+	result.Projects[2].Output = "  # aws_db_instance.main must be replaced\n"
+
+	body := drift.PullBody(result, config.Handling{}, "https://drift.example.com", 2000)
+	assert.LessOrEqual(t, len(body), 2000)
+	assert.Contains(t, body, "  ~ tags = {}\n... (truncated, see the full report)\n```")
+	assert.Contains(t, body, "  # aws_db_instance.main must be replaced\n```", "short outputs are kept whole")
+	assert.True(t, strings.HasSuffix(body, "Full report: https://drift.example.com\n"))
+
+	body = drift.PullBody(result, config.Handling{}, "", 300)
+	assert.LessOrEqual(t, len(body), 300)
+	assert.NotContains(t, body, "<details>")
+}
+
+func TestRunPullBody(t *testing.T) {
+	atlantis := atlantistest.NewServer("test-token")
+	defer atlantis.Close()
+	atlantis.SetPlan("network", atlantistest.Drift("network", "  # aws_route.a will be updated in-place\nPlan: 0 to add, 1 to change, 0 to destroy."))
+	driftCfg := config.DriftCfg{
+		AtlantisUrl:   atlantis.URL,
+		AtlantisToken: secret.Literal("test-token"),
+		RunID:         "abc123",
+		ReportURL:     "https://drift.example.com/runs/{runId}",
+	}
+	repo := config.Repo{Name: "owner/repo", Ref: "main"}
+	client := &atlantisCfgClient{atlantisCfg: "version: 3\nprojects:\n- name: network\n  dir: network\n"}
+
+	_, err := drift.Run(client, repo, driftCfg)
+	assert.NoError(t, err)
+	assert.Contains(t, client.body, "| network | network | default | pending | 0 | 1 | 0 |")
+	assert.Contains(t, client.body, "Full report: https://drift.example.com/runs/abc123")
+
+	driftCfg.DryRun = true
+	result, err := drift.Run(client, repo, driftCfg)
+	assert.NoError(t, err)
+	assert.Contains(t, result.PlannedPull.Body, "<details><summary>network</summary>")
+}
@@ -0,0 +1,73 @@
+package drift
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jukie/atlantis-drift-detection/internal/audit"
+	"github.com/jukie/atlantis-drift-detection/internal/config"
+	"github.com/jukie/atlantis-drift-detection/internal/vcs"
+)
+
+// DeletedBranch is a stale drift branch removed by Cleanup.
+type DeletedBranch struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// Cleanup deletes the drift branches of repo whose PRs are all closed or
+// merged, and those without commits for longer than maxAge whatever the state
+// of their PRs. Deleting the branch of an open PR closes the PR. A dry run of
+// driftCfg only lists the branches that would be deleted. Failing deletions do
+// not stop the others, and every deletion is recorded in the audit log of
+// driftCfg.
+func Cleanup(client vcs.Client, repo string, maxAge time.Duration, driftCfg config.DriftCfg) ([]DeletedBranch, error) {
+	branches, err := client.DriftBranches(repo)
+	if err != nil {
+		return nil, fmt.Errorf("listing drift branches of %s: %w", repo, err)
+	}
+	log := logger(driftCfg, client.VcsType(), config.Repo{Name: repo})
+	now := time.Now()
+	var deleted []DeletedBranch
+	var errs []error
+	for _, b := range branches {
+		reason := staleReason(b, maxAge, now)
+		if reason == "" {
+			continue
+		}
+		if driftCfg.DryRun {
+			log.Info("Dry run: would delete drift branch", "branch", b.Name, "reason", reason)
+			deleted = append(deleted, DeletedBranch{Name: b.Name, Reason: reason})
+			continue
+		}
+		err := client.DeleteBranch(repo, b.Name)
+		event := auditEvent(audit.ActionDeleteBranch, client.VcsType(), config.Repo{Name: repo})
+		event.Branch, event.Detail = b.Name, reason
+		record(driftCfg, event, err)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("deleting %s of %s: %w", b.Name, repo, err))
+			continue
+		}
+		log.Info("Deleted drift branch", "branch", b.Name, "reason", reason)
+		deleted = append(deleted, DeletedBranch{Name: b.Name, Reason: reason})
+	}
+	return deleted, errors.Join(errs...)
+}
+
+// staleReason explains why b should be deleted, or is empty when it should be
+// kept.
+func staleReason(b vcs.Branch, maxAge time.Duration, now time.Time) string {
+	if maxAge > 0 && now.Sub(b.Updated) > maxAge {
+		return fmt.Sprintf("no commits since %s", b.Updated.Format(time.DateOnly))
+	}
+	if len(b.Pulls) == 0 {
+		return ""
+	}
+	for _, state := range b.Pulls {
+		if state == vcs.PullOpen {
+			return ""
+		}
+	}
+	return "its PRs are closed"
+}
@@ -0,0 +1,51 @@
+package drift_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jukie/atlantis-drift-detection/internal/audit"
+	"github.com/jukie/atlantis-drift-detection/internal/config"
+	"github.com/jukie/atlantis-drift-detection/internal/drift"
+	"github.com/jukie/atlantis-drift-detection/internal/vcs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCleanup(t *testing.T) {
+	now := time.Now()
+	old := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	client := &MockClient{branches: []vcs.Branch{
+		{Name: "atlantis-drift-open", Updated: now, Pulls: []string{vcs.PullOpen}},
+		{Name: "atlantis-drift-merged", Updated: now, Pulls: []string{vcs.PullMerged}},
+		{Name: "atlantis-drift-reopened", Updated: now, Pulls: []string{vcs.PullClosed, vcs.PullOpen}},
+		{Name: "atlantis-drift-nopull", Updated: now.Add(-time.Hour)},
+		{Name: "atlantis-drift-old", Updated: old, Pulls: []string{vcs.PullClosed}},
+		{Name: "atlantis-drift-oldnopull", Updated: old},
+		{Name: "atlantis-drift-oldopen", Updated: old, Pulls: []string{vcs.PullOpen}},
+	}}
+
+	deleted, err := drift.Cleanup(client, "owner/repo", 24*time.Hour, config.DriftCfg{DryRun: true})
+	assert.NoError(t, err)
+	want := []drift.DeletedBranch{
+		{Name: "atlantis-drift-merged", Reason: "its PRs are closed"},
+		{Name: "atlantis-drift-old", Reason: "no commits since 2023-01-02"},
+		{Name: "atlantis-drift-oldnopull", Reason: "no commits since 2023-01-02"},
+		{Name: "atlantis-drift-oldopen", Reason: "no commits since 2023-01-02"},
+	}
+	assert.Equal(t, want, deleted)
+	assert.Empty(t, client.deleted)
+
+	log := &recordingAudit{}
+	deleted, err = drift.Cleanup(client, "owner/repo", 24*time.Hour, config.DriftCfg{RunID: "abc123", Audit: log})
+	assert.NoError(t, err)
+	assert.Equal(t, want, deleted)
+	assert.Equal(t, []string{"atlantis-drift-merged", "atlantis-drift-old", "atlantis-drift-oldnopull", "atlantis-drift-oldopen"}, client.deleted)
+	assert.Len(t, log.events, 4)
+	assert.Equal(t, audit.Event{RunID: "abc123", Action: audit.ActionDeleteBranch, Vcs: "github", Repo: "owner/repo", Branch: "atlantis-drift-merged", Detail: "its PRs are closed", Result: audit.ResultSuccess}, log.events[0])
+
+	// Without a maximum age only branches with closed PRs go.
+	client.deleted = nil
+	_, err = drift.Cleanup(client, "owner/repo", 0, config.DriftCfg{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"atlantis-drift-merged", "atlantis-drift-old"}, client.deleted)
+}
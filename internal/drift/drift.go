@@ -4,19 +4,39 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"net/http/httputil"
 	"regexp"
+	"sort"
 	"time"
 
+	"github.com/jukie/atlantis-drift-detection/internal/audit"
 	"github.com/jukie/atlantis-drift-detection/internal/config"
+	"github.com/jukie/atlantis-drift-detection/internal/logging"
+	"github.com/jukie/atlantis-drift-detection/internal/notify"
+	"github.com/jukie/atlantis-drift-detection/internal/secret"
 	"github.com/jukie/atlantis-drift-detection/internal/vcs"
 	"gopkg.in/yaml.v3"
 )
 
 const atlantisCfgFile = "atlantis.yaml"
 
+// CommentDelay is how long DriftHandler waits between opening the drift PR
+// and commenting on it. When testing with Gitlab EE an immediate comment would
+// confuse Atlantis.
+var CommentDelay = 15 * time.Second
+
+// PlanTimeout bounds a plan or apply request to Atlantis, which only responds
+// once every requested project is done.
+var PlanTimeout = time.Hour
+
+// defaultWorkspace is the workspace Atlantis uses when none is configured.
+const defaultWorkspace = "default"
+
 type Path struct {
+	// Name is only used for filtering; the plan API has no project name field.
+	Name      string `yaml:"name" json:"-"`
 	Directory string `yaml:"dir"`
 	Workspace string
 }
@@ -26,6 +46,7 @@ type PlanApiResponse struct {
 	Failure        string
 	ProjectResults []struct {
 		RepoRelDir  string
+		Workspace   string
 		Error       interface{}
 		Failure     string
 		PlanSuccess struct {
@@ -39,25 +60,43 @@ type PlanApiRequest struct {
 	Repository string
 	Ref        string
 	Type       string
-	Paths      []Path
+	// PR is the pull request to plan in the context of, if any.
+	PR    int `json:",omitempty"`
+	Paths []Path
 }
 
-func BuildPlanReq(client vcs.Client, repo, ref, vcsType string) ([]byte, error) {
-	planInput := PlanApiRequest{
-		Repository: repo,
-		Ref:        ref,
-		Type:       vcsType,
-		Paths: []Path{{
-			Directory: ".",
-		}},
+// ProjectPaths returns the paths configured in the repo's plan options, or
+// else the projects in the repo's atlantis.yaml, or the repo root when it has
+// none, split by the repo's filters.
+func ProjectPaths(client vcs.Client, repo config.Repo) (planned, skipped []Path) {
+	if len(repo.Plan.Paths) > 0 {
+		paths := make([]Path, 0, len(repo.Plan.Paths))
+		for _, p := range repo.Plan.Paths {
+			paths = append(paths, Path{Name: p.Name, Directory: p.Dir, Workspace: p.Workspace})
+		}
+		return FilterPaths(paths, repo)
 	}
-	hasRepoCfg, atlantisCfgBytes, _ := client.GetFileContent(repo, atlantisCfgFile, ref)
+	paths := []Path{{
+		Directory: ".",
+	}}
+	hasRepoCfg, atlantisCfgBytes, _ := client.GetFileContent(repo.Name, atlantisCfgFile, repo.Ref)
 	if hasRepoCfg {
 		var projects struct {
 			Paths []Path `yaml:"projects"`
 		}
 		_ = yaml.Unmarshal(atlantisCfgBytes, &projects)
-		planInput.Paths = projects.Paths
+		paths = projects.Paths
+	}
+	return FilterPaths(paths, repo)
+}
+
+func BuildPlanReq(repo, ref, vcsType string, pr int, paths []Path) ([]byte, error) {
+	planInput := PlanApiRequest{
+		Repository: repo,
+		Ref:        ref,
+		Type:       vcsType,
+		PR:         pr,
+		Paths:      paths,
 	}
 
 	json_data, err := json.Marshal(planInput)
@@ -67,77 +106,354 @@ func BuildPlanReq(client vcs.Client, repo, ref, vcsType string) ([]byte, error)
 	return json_data, nil
 }
 
-func Run(client vcs.Client, repo config.Repo, driftCfg config.DriftCfg) error {
-	resp, err := ApiPlan(client, repo, driftCfg.AtlantisUrl, driftCfg.AtlantisToken)
-	if err != nil {
-		return err
+// Check plans every project of the repo through Atlantis and classifies the
+// results without making any changes in the VCS.
+func Check(client vcs.Client, repo config.Repo, driftCfg config.DriftCfg) (result Result, err error) {
+	result = Result{
+		Vcs:       client.VcsType(),
+		Repo:      repo.Name,
+		Ref:       repo.Ref,
+		StartedAt: time.Now(),
 	}
-	driftedProjects, err := DriftChecker(resp)
+	defer func() { result.FinishedAt = time.Now() }()
+
+	// The token is resolved on every run so rotated secrets are picked up.
+	token, err := driftCfg.AtlantisToken.Get()
 	if err != nil {
-		return err
+		err = fmt.Errorf("resolving Atlantis token: %w", err)
+		result.Error = err.Error()
+		return result, err
+	}
+	paths, skipped := ProjectPaths(client, repo)
+	for _, p := range skipped {
+		result.Projects = append(result.Projects, ProjectResult{
+			Name:      p.Name,
+			Dir:       p.Directory,
+			Workspace: p.Workspace,
+			Status:    StatusSkipped,
+		})
+	}
+	if len(paths) == 0 && len(skipped) > 0 {
+		logger(driftCfg, client.VcsType(), repo).Info("All projects are excluded by the repo's filters")
+		return result, nil
 	}
-	err = DriftHandler(client, driftedProjects, repo)
+
+	resp, err := ApiPlan(client, repo, paths, driftCfg.AtlantisUrl, token)
+	event := auditEvent(audit.ActionPlan, client.VcsType(), repo)
+	for _, p := range paths {
+		event.Projects = append(event.Projects, pathName(p))
+	}
+	record(driftCfg, event, err)
 	if err != nil {
-		return err
+		result.Error = err.Error()
+		return result, err
+	}
+	result.Projects = append(Classify(resp), result.Projects...)
+	return result, evaluate(repo, driftCfg, &result)
+}
+
+// CheckPlans classifies JSON plans produced outside Atlantis, keyed by
+// project name, and applies the same rules as Check. Atlantis is not called.
+func CheckPlans(vcsType string, repo config.Repo, driftCfg config.DriftCfg, plans map[string][]byte) (result Result, err error) {
+	result = Result{
+		Vcs:       vcsType,
+		Repo:      repo.Name,
+		Ref:       repo.Ref,
+		StartedAt: time.Now(),
+	}
+	defer func() { result.FinishedAt = time.Now() }()
+
+	names := make([]string, 0, len(plans))
+	for name := range plans {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		pr := ProjectResult{Name: name}
+		changes, err := ParseJSONPlan(plans[name])
+		if err != nil {
+			pr.Status = StatusFailed
+			pr.Error = err.Error()
+		} else {
+			setJSONChanges(&pr, changes)
+		}
+		result.Projects = append(result.Projects, pr)
+	}
+	return result, evaluate(repo, driftCfg, &result)
+}
+
+// evaluate applies ignore rules, acknowledgements and history to classified
+// results and reports failed projects as an error.
+func evaluate(repo config.Repo, driftCfg config.DriftCfg, result *Result) error {
+	log := logger(driftCfg, result.Vcs, repo)
+	applyIgnoreRules(repo.Ignore, result)
+	if driftCfg.Acknowledgements != nil {
+		applyAcknowledgements(driftCfg.Acknowledgements, result, time.Now())
+		for _, p := range result.Projects {
+			if p.Acknowledgement == nil {
+				continue
+			}
+			expires := p.Acknowledgement.Expires.Format(time.RFC3339)
+			log.Info("Drift is acknowledged", logging.KeyProject, projectName(p), "expires", expires, "reason", p.Acknowledgement.Reason)
+			event := audit.Event{Action: audit.ActionAcknowledge, Vcs: result.Vcs, Repo: result.Repo, Ref: result.Ref, Projects: []string{projectName(p)}}
+			event.Detail = fmt.Sprintf("acknowledged until %s: %s", expires, p.Acknowledgement.Reason)
+			record(driftCfg, event, nil)
+		}
+	}
+	if driftCfg.Store != nil {
+		if err := compareWithHistory(driftCfg.Store, result); err != nil {
+			log.Warn("Comparing with previous runs failed", logging.Err(err))
+		}
+	}
+	if failed := result.ProjectNames(StatusFailed); len(failed) > 0 {
+		return fmt.Errorf("plan execution failed for following projects: %s", failed)
 	}
 	return nil
 }
 
-func ApiPlan(client vcs.Client, r config.Repo, atlantisHost, atlantisToken string) (PlanApiResponse, error) {
-	planReq, err := BuildPlanReq(client, r.Name, r.Ref, client.VcsType())
+// Run checks the repo, applies the drift matching its auto-apply rules and
+// opens a drift PR for the remaining drifted projects. Applying comes first
+// since the PR's plan comment makes Atlantis lock the projects. Drift that is
+// identical to the previous run is reported but not acted on. In dry-run mode
+// the PR is only described in the result and no commit status is reported.
+func Run(client vcs.Client, repo config.Repo, driftCfg config.DriftCfg) (Result, error) {
+	result, err := Check(client, repo, driftCfg)
+	if err != nil {
+		return result, err
+	}
+
+	log := logger(driftCfg, client.VcsType(), repo)
+	raiseOutlivedDrift(client, &result, log)
+	assignOwners(client, repo, driftCfg, &result)
+	applied := autoApply(client, repo, driftCfg, &result)
+	actionable := result.Actionable(repo.Handling)
+	suppressed, reported := 0, 0
+	for _, p := range result.Projects {
+		switch {
+		case p.Status != StatusDrifted && p.Status != StatusPending:
+		case p.Suppressed:
+			suppressed++
+		case !handledByPR(repo.Handling, p.Status):
+			reported++
+		}
+	}
+	if suppressed > 0 {
+		log.Info("Suppressing projects with unchanged ongoing drift", "count", suppressed)
+	}
+	if reported > 0 {
+		log.Info("Only reporting projects as configured by the repo's handling", "count", reported)
+	}
+	pull := 0
+	if driftCfg.DryRun {
+		result.DryRun = true
+		result.PlannedPull, err = planPull(client, actionable, repo, log)
+		if result.PlannedPull != nil {
+			result.PlannedPull.Body = PullBody(result, repo.Handling, reportURL(driftCfg), client.MaxBodyLength())
+			if repo.Codeowners.RequestReviews() {
+				result.PlannedPull.Reviewers = reviewers(result, repo.Handling)
+			}
+		}
+	} else {
+		pr := vcs.PullRequest{Body: PullBody(result, repo.Handling, reportURL(driftCfg), client.MaxBodyLength())}
+		pr.MergeRequest = mergeRequest(repo)
+		pr.Marker, err = marker(repo, driftCfg.RunID, actionable)
+		if err == nil {
+			pull, result.PullURL, err = openPull(client, actionable, repo, pr, driftCfg)
+		}
+		if pull != 0 && repo.Codeowners.RequestReviews() {
+			requestReviews(client, repo, driftCfg, pull, result.PullURL, reviewers(result, repo.Handling))
+		}
+	}
 	if err != nil {
-		return PlanApiResponse{}, err
+		result.Error = err.Error()
+	}
+	if applied && pull != 0 {
+		if commenter, ok := client.(vcs.Commenter); ok {
+			comment := ApplyComment(result)
+			commentErr := commenter.Comment(repo.Name, pull, comment)
+			event := auditEvent(audit.ActionComment, client.VcsType(), repo)
+			event.PullURL, event.Detail = result.PullURL, comment
+			record(driftCfg, event, commentErr)
+			if commentErr != nil {
+				log.Error("Commenting the auto-apply results failed", "pull_url", result.PullURL, logging.Err(commentErr))
+			}
+		}
+	}
+	if result.PullURL != "" {
+		for i, p := range result.Projects {
+			// Projects whose apply failed or was skipped are not marked as
+			// handled by the PR, so that later runs retry them instead of
+			// suppressing them as ongoing.
+			if inPull(repo.Handling, p) && p.AutoApply == nil {
+				result.Projects[i].PullURL = result.PullURL
+			}
+		}
+	}
+
+	if !driftCfg.DryRun {
+		reportStatus(client, repo, driftCfg, result)
+	}
+
+	if driftCfg.Notifier != nil && len(actionable) > 0 && !driftCfg.DryRun {
+		notifyErr := driftCfg.Notifier.Notify(notify.Message{
+			RunID:    driftCfg.RunID,
+			Vcs:      result.Vcs,
+			Repo:     result.Repo,
+			Ref:      result.Ref,
+			Projects: result.pullProjectNames(repo.Handling),
+			PullURL:  result.PullURL,
+			Owners:   projectOwners(result, repo.Handling),
+		})
+		if notifyErr != nil {
+			log.Error("Notifying failed", logging.Err(notifyErr))
+		}
+	}
+
+	// Dry runs are not recorded so that a later real run still sees the drift
+	// as new.
+	if driftCfg.Store != nil && !driftCfg.DryRun {
+		if saveErr := saveHistory(driftCfg.Store, driftCfg.RunID, result); saveErr != nil {
+			log.Error("Storing the results failed", logging.Err(saveErr))
+		}
 	}
-	return httpPost(atlantisHost+"/api/plan", atlantisToken, planReq)
+	result.FinishedAt = time.Now()
+	return result, err
 }
 
-func httpPost(url, token string, reqBody []byte) (PlanApiResponse, error) {
+func ApiPlan(client vcs.Client, r config.Repo, paths []Path, atlantisHost, atlantisToken string) (PlanApiResponse, error) {
 	var planResp PlanApiResponse
+	planReq, err := BuildPlanReq(r.Name, r.Ref, client.VcsType(), r.Plan.PR, paths)
+	if err != nil {
+		return planResp, err
+	}
+	headers, err := planHeaders(r)
+	if err != nil {
+		return planResp, err
+	}
+	err = httpPost(atlantisHost+"/api/plan", atlantisToken, headers, planReq, &planResp)
+	return planResp, err
+}
+
+// planHeaders resolves the configured headers of the repo's Atlantis
+// requests. Like the token they are resolved on every request.
+func planHeaders(r config.Repo) (http.Header, error) {
+	headers := http.Header{}
+	for name, src := range r.Plan.Headers {
+		value, err := src.Get()
+		if err != nil {
+			return nil, fmt.Errorf("resolving plan header %s: %w", name, err)
+		}
+		headers.Set(name, value)
+	}
+	return headers, nil
+}
 
+// httpPost sends reqBody to the Atlantis API and decodes the response into
+// resp.
+func httpPost(url, token string, headers http.Header, reqBody []byte, resp interface{}) error {
 	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(reqBody))
 	if err != nil {
-		return planResp, err
+		return err
+	}
+	for name, values := range headers {
+		req.Header[name] = values
 	}
 	req.Header.Set("X-Atlantis-Token", token)
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	client := &http.Client{Timeout: PlanTimeout}
+	httpResp, err := client.Do(req)
 	if err != nil {
-		return planResp, err
+		return err
 	}
+	defer httpResp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		dump, err := httputil.DumpResponse(resp, true)
+	if httpResp.StatusCode != http.StatusOK {
+		dump, err := httputil.DumpResponse(httpResp, true)
 		if err != nil {
-			return planResp, fmt.Errorf("issue during http request to Atlantis server:\nRequest body: %v\nAdditional error: %q", string(reqBody), err)
+			return fmt.Errorf("issue during http request to Atlantis server:\nRequest body: %v\nAdditional error: %q", secret.Mask(string(reqBody)), err)
 		}
-		return planResp, fmt.Errorf("issue during http request to Atlantis server\nRequest body: %v\nResponse dump: %v", string(reqBody), string(dump))
+		return fmt.Errorf("issue during http request to Atlantis server\nRequest body: %v\nResponse dump: %v", secret.Mask(string(reqBody)), secret.Mask(string(dump)))
 	}
 
-	defer resp.Body.Close()
-	err = json.NewDecoder(resp.Body).Decode(&planResp)
+	if err := json.NewDecoder(httpResp.Body).Decode(resp); err != nil {
+		return fmt.Errorf("issue parsing response from Atlantis: %v", err)
+	}
+	return nil
+}
 
-	if err != nil {
-		return planResp, fmt.Errorf("issue parsing response from Atlantis: %v", err)
+// Classify turns the Atlantis plan response into per-project results.
+func Classify(res PlanApiResponse) []ProjectResult {
+	results := make([]ProjectResult, 0, len(res.ProjectResults))
+	for _, p := range res.ProjectResults {
+		pr := ProjectResult{
+			Name:      p.ProjectName,
+			Dir:       p.RepoRelDir,
+			Workspace: p.Workspace,
+		}
+		if p.Error != nil {
+			pr.Status = StatusFailed
+			pr.Error = fmt.Sprint(p.Error)
+			if p.Failure != "" {
+				pr.Error += ": " + p.Failure
+			}
+		} else {
+			classifyOutput(&pr, p.PlanSuccess.TerraformOutput)
+		}
+		results = append(results, pr)
+	}
+	return results
+}
+
+var noChangesRe = regexp.MustCompile("No changes. Your infrastructure matches the configuration")
+
+// classifyOutput sets the status and changes of a project from its plan
+// output. A JSON plan in the output is preferred; the text parser is the
+// fallback.
+func classifyOutput(pr *ProjectResult, output string) {
+	pr.Output = output
+	if changes, ok := findJSONPlan(output); ok {
+		setJSONChanges(pr, changes)
+		return
+	}
+	pr.PlanFormat = PlanFormatText
+	pr.PlanHash = PlanHash(output)
+	pr.Status = StatusClean
+	if !noChangesRe.MatchString(output) {
+		pr.Changes = ParseTextPlan(output)
+		// Output that could not be parsed into changes is treated as drift.
+		pr.Status = StatusDrifted
+		if len(pr.Changes) > 0 {
+			pr.Status = changesStatus(pr.Changes)
+		}
+	}
+}
+
+func setJSONChanges(pr *ProjectResult, changes []ResourceChange) {
+	pr.PlanFormat = PlanFormatJSON
+	// JSON plans carry timestamps, so the hash is computed from the changes.
+	pr.PlanHash = changesHash(changes)
+	pr.Status = StatusClean
+	if len(changes) > 0 {
+		pr.Status = changesStatus(changes)
+		pr.Changes = changes
 	}
-	return planResp, nil
 }
 
-func DriftChecker(res PlanApiResponse) ([]string, error) {
-	r := regexp.MustCompile("No changes. Your infrastructure matches the configuration")
+// DriftChecker returns the drifted projects of res, logging each drifted or
+// failed project to log. It fails when any plan failed.
+func DriftChecker(res PlanApiResponse, log *slog.Logger) ([]string, error) {
 	failedProjects := []string{}
 	driftedProjects := []string{}
 	var err error
-	for _, p := range res.ProjectResults {
-		if p.Error != nil {
-			fmt.Printf("Errors during plan: %v\nFailure message: %s\n", p.Error, p.Failure)
-			failedProjects = append(failedProjects, p.ProjectName)
-			continue
-		}
-		if !r.Match([]byte(p.PlanSuccess.TerraformOutput)) {
-			fmt.Printf("Found drifted project %s\n", p.ProjectName)
-			driftedProjects = append(driftedProjects, p.ProjectName)
+	for _, p := range Classify(res) {
+		switch p.Status {
+		case StatusFailed:
+			log.Error("Errors during plan", logging.KeyProject, p.Name, "plan_error", p.Error)
+			failedProjects = append(failedProjects, p.Name)
+		case StatusDrifted:
+			log.Info("Found drifted project", logging.KeyProject, p.Name)
+			driftedProjects = append(driftedProjects, p.Name)
 		}
 	}
 	if len(failedProjects) > 0 {
@@ -146,26 +462,133 @@ func DriftChecker(res PlanApiResponse) ([]string, error) {
 	return driftedProjects, err
 }
 
-func DriftHandler(client vcs.Client, driftedProjects []string, repo config.Repo) error {
+// DriftHandler opens the PR pr for the drifted projects and comments on it so
+// that Atlantis plans them. It returns the URL of the PR, if one was created.
+func DriftHandler(client vcs.Client, driftedProjects []string, repo config.Repo, pr vcs.PullRequest) (string, error) {
+	_, url, err := openPull(client, driftedProjects, repo, pr, config.DriftCfg{})
+	return url, err
+}
+
+// openPull is DriftHandler, also returning the number of the PR and
+// recording what it creates in the audit log of driftCfg.
+func openPull(client vcs.Client, driftedProjects []string, repo config.Repo, pr vcs.PullRequest, driftCfg config.DriftCfg) (int, string, error) {
+	log := logger(driftCfg, client.VcsType(), repo)
 	if len(driftedProjects) < 1 {
-		fmt.Println("No drifted projects found, party on. ༼つ▀̿_▀̿ ༽つ")
-		return nil
+		log.Info("No drifted projects found")
+		return 0, "", nil
 	}
 
-	fmt.Printf("Drift detected for the following projects: %s\n", driftedProjects)
+	log.Info("Drift detected", "projects", driftedProjects)
 
-	pull, url, err := client.CreatePull(repo.Name, repo.Ref)
+	var changes vcs.PullChanges
+	var err error
+	if repo.Pull.Mode == config.PullLongLived {
+		reuser, ok := client.(vcs.PullReuser)
+		if !ok {
+			return 0, "", fmt.Errorf("%s does not support long-lived drift PRs", client.VcsType())
+		}
+		changes, err = reuser.ReusePull(repo.Name, repo.Ref, pullBranch(repo), pr)
+	} else {
+		changes, err = client.CreatePull(repo.Name, repo.Ref, pr)
+	}
+	recordPull(driftCfg, client.VcsType(), repo, driftedProjects, pr.Marker, changes, err)
 	if err != nil {
-		return err
+		return 0, "", err
 	}
+	pull, url := changes.Number, changes.URL
 
-	fmt.Printf("MR can be seen here: %s\n", url)
+	log.Info("Opened the drift PR", "pull_url", url)
 
-	// When testing with Gitlab EE an immediate call would confuse Atlantis
-	time.Sleep(15 * time.Second)
+	time.Sleep(CommentDelay)
 	err = client.CommentOnPull(repo.Name, pull, driftedProjects)
+	event := auditEvent(audit.ActionComment, client.VcsType(), repo)
+	event.Projects, event.PullURL, event.Detail = driftedProjects, url, vcs.PlanComment(driftedProjects)
+	record(driftCfg, event, err)
 	if err != nil {
-		return fmt.Errorf("issue creating MR comment: %q", err)
+		return pull, url, fmt.Errorf("issue creating MR comment: %q", err)
 	}
-	return nil
+	return pull, url, nil
+}
+
+// PullPlan describes the PR and comment DriftHandler would create.
+type PullPlan struct {
+	Branch  string `json:"branch"`
+	Base    string `json:"base"`
+	Title   string `json:"title"`
+	Body    string `json:"body"`
+	Comment string `json:"comment"`
+	// Reviewers are the code owners that would be requested to review.
+	Reviewers []string `json:"reviewers,omitempty"`
+}
+
+// DryRunHandler reports what DriftHandler would do for the drifted projects
+// without writing anything to the VCS. It returns nil when there is no drift.
+func DryRunHandler(client vcs.Client, driftedProjects []string, repo config.Repo) (*PullPlan, error) {
+	return planPull(client, driftedProjects, repo, logger(config.DriftCfg{}, client.VcsType(), repo))
+}
+
+// planPull is DryRunHandler, logging to log.
+func planPull(client vcs.Client, driftedProjects []string, repo config.Repo, log *slog.Logger) (*PullPlan, error) {
+	if len(driftedProjects) < 1 {
+		log.Info("No drifted projects found")
+		return nil, nil
+	}
+
+	branch := pullBranch(repo)
+	if repo.Pull.Mode != config.PullLongLived {
+		var err error
+		branch, err = client.DriftBranch(repo.Name, repo.Ref)
+		if err != nil {
+			return nil, err
+		}
+	}
+	plan := &PullPlan{
+		Branch:  branch,
+		Base:    repo.Ref,
+		Title:   vcs.PullTitle,
+		Comment: vcs.PlanComment(driftedProjects),
+	}
+	log.Info("Dry run: drift detected", "projects", driftedProjects)
+	log.Info("Dry run: would open a drift PR", "branch", plan.Branch, "base", plan.Base, "title", plan.Title, "comment", plan.Comment)
+	return plan, nil
+}
+
+// marker renders the drift marker of the repo for the drifted projects.
+func marker(repo config.Repo, runID string, driftedProjects []string) (vcs.Marker, error) {
+	m, err := vcs.RenderMarker(repo.Marker.Path, repo.Marker.Template, vcs.MarkerData{
+		RunID:     runID,
+		Repo:      repo.Name,
+		Ref:       repo.Ref,
+		Projects:  driftedProjects,
+		Timestamp: time.Now().UTC(),
+	})
+	if err != nil {
+		return m, fmt.Errorf("rendering the drift marker: %w", err)
+	}
+	return m, nil
+}
+
+// mergeRequest returns the configured options of GitLab drift MRs.
+func mergeRequest(repo config.Repo) vcs.MergeRequestOptions {
+	mr := repo.Pull.MergeRequest
+	if mr == nil {
+		return vcs.MergeRequestOptions{}
+	}
+	return vcs.MergeRequestOptions{
+		Reviewers:          mr.Reviewers,
+		Assignees:          mr.Assignees,
+		Labels:             mr.Labels,
+		Milestone:          mr.Milestone,
+		Draft:              mr.Draft,
+		RemoveSourceBranch: mr.RemoveSourceBranch,
+		Squash:             mr.Squash,
+	}
+}
+
+// pullBranch is the branch of the repo's long-lived drift PR.
+func pullBranch(repo config.Repo) string {
+	if repo.Pull.Branch != "" {
+		return repo.Pull.Branch
+	}
+	return config.DefaultPullBranch
 }
@@ -1,18 +1,37 @@
 package drift_test
 
 import (
+	"bytes"
 	"encoding/json"
+	"log/slog"
 	"net/http"
-	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/jukie/atlantis-drift-detection/internal/atlantistest"
 	"github.com/jukie/atlantis-drift-detection/internal/config"
 	"github.com/jukie/atlantis-drift-detection/internal/drift"
+	"github.com/jukie/atlantis-drift-detection/internal/logging"
+	"github.com/jukie/atlantis-drift-detection/internal/secret"
+	"github.com/jukie/atlantis-drift-detection/internal/vcs"
 	"github.com/stretchr/testify/assert"
 )
 
+func init() {
+	drift.CommentDelay = 0
+}
+
 // MockClient is a mock implementation of vcs.Client.
 type MockClient struct {
+	pulls    int
+	comments int
+	// pullErr fails CreatePull.
+	pullErr  error
+	body     string
+	marker   vcs.Marker
+	mr       vcs.MergeRequestOptions
+	branches []vcs.Branch
+	deleted  []string
 }
 
 func (m *MockClient) GetFileContent(repo, path, ref string) (bool, []byte, error) {
@@ -25,24 +44,53 @@ func (m *MockClient) VcsType() string {
 	return "github"
 }
 
-func (m *MockClient) CreatePull(repo, ref string) (int, string, error) {
+func (m *MockClient) DriftBranch(repo, ref string) (string, error) {
+	// Mock the behavior of DriftBranch here.
+	return "atlantis-drift-abc123", nil
+}
+
+func (m *MockClient) MaxBodyLength() int {
+	return 65536
+}
+
+func (m *MockClient) CreatePull(repo, ref string, pull vcs.PullRequest) (vcs.PullChanges, error) {
 	// Mock the behavior of CreatePull here.
-	return 1, "https://example.com/pull/1", nil
+	changes := vcs.PullChanges{Branch: "atlantis-drift-abc123", BranchCreated: true, Commit: "def456", Committed: true}
+	if m.pullErr != nil {
+		return changes, m.pullErr
+	}
+	m.pulls++
+	m.body = pull.Body
+	m.marker = pull.Marker
+	m.mr = pull.MergeRequest
+	changes.Number, changes.URL, changes.PullCreated = 1, "https://example.com/pull/1", true
+	return changes, nil
+}
+
+func (m *MockClient) DriftBranches(repo string) ([]vcs.Branch, error) {
+	return m.branches, nil
+}
+
+func (m *MockClient) DeleteBranch(repo, branch string) error {
+	m.deleted = append(m.deleted, branch)
+	return nil
 }
 
 func (m *MockClient) CommentOnPull(repo string, pullID int, driftedProjects []string) error {
 	// Mock the behavior of CommentOnPull here.
+	m.comments++
 	return nil
 }
 
 func TestBuildPlanReq(t *testing.T) {
-	mockClient := &MockClient{}
 	repo := "test-repo"
 	ref := "test-ref"
 	vcsType := "github"
+	paths := []drift.Path{{Name: "project1", Directory: "network", Workspace: "default"}}
 
-	req, err := drift.BuildPlanReq(mockClient, repo, ref, vcsType)
+	req, err := drift.BuildPlanReq(repo, ref, vcsType, 0, paths)
 	assert.NoError(t, err)
+	assert.NotContains(t, string(req), "project1", "project names are not part of the plan API")
 
 	var planReq drift.PlanApiRequest
 	err = json.Unmarshal(req, &planReq)
@@ -51,6 +99,49 @@ func TestBuildPlanReq(t *testing.T) {
 	assert.Equal(t, repo, planReq.Repository)
 	assert.Equal(t, ref, planReq.Ref)
 	assert.Equal(t, vcsType, planReq.Type)
+	assert.Equal(t, []drift.Path{{Directory: "network", Workspace: "default"}}, planReq.Paths)
+	assert.NotContains(t, string(req), `"PR"`)
+
+	req, err = drift.BuildPlanReq(repo, ref, vcsType, 12, paths)
+	assert.NoError(t, err)
+	assert.Contains(t, string(req), `"PR":12`)
+}
+
+func TestRunPlanOptions(t *testing.T) {
+	atlantis := atlantistest.NewServer("test-token")
+	defer atlantis.Close()
+
+	repo := config.Repo{
+		Name:    "owner/repo",
+		Ref:     "main",
+		Exclude: []config.ProjectFilter{{Name: "legacy"}},
+		Plan: config.PlanOptions{
+			PR: 12,
+			Paths: []config.PlanPath{
+				{Dir: "envs/prod", Workspace: "prod"},
+				{Dir: "envs/staging"},
+				{Name: "legacy", Dir: "legacy"},
+			},
+			Headers: map[string]*secret.Source{"X-Proxy-Auth": secret.Literal("proxy-secret")},
+		},
+	}
+	driftCfg := config.DriftCfg{AtlantisUrl: atlantis.URL, AtlantisToken: secret.Literal("test-token")}
+	// The configured paths replace the projects of atlantis.yaml.
+	client := &atlantisCfgClient{atlantisCfg: "version: 3\nprojects:\n- dir: network\n"}
+	result, err := drift.Run(client, repo, driftCfg)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"legacy"}, result.ProjectNames(drift.StatusSkipped))
+
+	assert.Equal(t, []atlantistest.PlanRequest{{
+		Repository: "owner/repo",
+		Ref:        "main",
+		Type:       "github",
+		PR:         12,
+		Paths:      []atlantistest.Path{{Directory: "envs/prod", Workspace: "prod"}, {Directory: "envs/staging"}},
+	}}, atlantis.Requests())
+	header := atlantis.Headers()[0]
+	assert.Equal(t, "proxy-secret", header.Get("X-Proxy-Auth"))
+	assert.Equal(t, "test-token", header.Get("X-Atlantis-Token"))
 }
 
 func TestDriftChecker(t *testing.T) {
@@ -58,6 +149,7 @@ func TestDriftChecker(t *testing.T) {
 	res := drift.PlanApiResponse{
 		ProjectResults: []struct {
 			RepoRelDir  string
+			Workspace   string
 			Error       interface{}
 			Failure     string
 			PlanSuccess struct{ TerraformOutput string }
@@ -69,79 +161,304 @@ func TestDriftChecker(t *testing.T) {
 			},
 		},
 	}
-	driftedProjects, err := drift.DriftChecker(res)
+	var out bytes.Buffer
+	log := slog.New(slog.NewTextHandler(&out, nil)).With(logging.KeyRunID, "abc123", logging.KeyRepo, "test-repo")
+	driftedProjects, err := drift.DriftChecker(res, log)
 	assert.NoError(t, err)
 	assert.Empty(t, driftedProjects)
 
 	// Test case 2: drift detected.
 	res.ProjectResults[0].PlanSuccess.TerraformOutput = "1 to add, 0 to change, 0 to destroy"
-	driftedProjects, err = drift.DriftChecker(res)
+	driftedProjects, err = drift.DriftChecker(res, log)
 	assert.NoError(t, err)
 	assert.NotEmpty(t, driftedProjects)
 	assert.Equal(t, "project1", driftedProjects[0])
+	// Log lines carry the fields of the run.
+	assert.Contains(t, out.String(), "msg=\"Found drifted project\" run_id=abc123 repo=test-repo project=project1")
 }
 
 func TestRun(t *testing.T) {
-	mockClient := &MockClient{}
+	atlantis := atlantistest.NewServer("test-token")
+	defer atlantis.Close()
+	atlantis.SetPlan(".", atlantistest.NoChanges("project1"))
+
+	driftCfg := config.DriftCfg{
+		AtlantisUrl:   atlantis.URL,
+		AtlantisToken: secret.Literal("test-token"),
+	}
+	client := &atlantisCfgClient{atlantisCfg: "version: 3\nprojects:\n- name: project1\n  dir: .\n"}
+	result, err := drift.Run(client, config.Repo{Name: "test-repo", Ref: "test-ref"}, driftCfg)
+	assert.NoError(t, err)
+	assert.Equal(t, []drift.ProjectResult{{
+		Name:       "project1",
+		Dir:        ".",
+		Workspace:  "default",
+		Status:     drift.StatusClean,
+		Output:     atlantistest.NoChangesOutput,
+		PlanFormat: drift.PlanFormatText,
+		PlanHash:   drift.PlanHash(atlantistest.NoChangesOutput),
+	}}, result.Projects)
+	assert.Empty(t, result.PullURL)
+	assert.Equal(t, 0, client.pulls)
+}
+
+func TestApiPlan(t *testing.T) {
+	atlantis := atlantistest.NewServer("test-token")
+	defer atlantis.Close()
+	atlantis.SetPlan(".", atlantistest.NoChanges("project1"))
+
 	repo := config.Repo{
 		Name: "test-repo",
 		Ref:  "test-ref",
 	}
-	driftCfg := config.DriftCfg{
-		AtlantisUrl:   "http://localhost:4141",
-		AtlantisToken: "test-token",
+	planResp, err := drift.ApiPlan(&MockClient{}, repo, []drift.Path{{Directory: "."}}, atlantis.URL, "test-token")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(planResp.ProjectResults))
+	assert.Equal(t, atlantistest.NoChangesOutput, planResp.ProjectResults[0].PlanSuccess.TerraformOutput)
+	assert.Equal(t, "project1", planResp.ProjectResults[0].ProjectName)
+	assert.Equal(t, []atlantistest.PlanRequest{{Repository: "test-repo", Ref: "test-ref", Type: "github", Paths: []atlantistest.Path{{Directory: "."}}}}, atlantis.Requests())
+}
+
+func TestDriftHandler(t *testing.T) {
+	mockClient := &MockClient{}
+	driftedProjects := []string{"project1"}
+	repo := config.Repo{
+		Name: "test-repo",
+		Ref:  "test-ref",
 	}
 
-	// Note: In a real test scenario, you should replace the httptest.NewServer with a mock implementation of the Atlantis server.
-	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte(`{"ProjectResults": [{"PlanSuccess": {"TerraformOutput": "No changes. Your infrastructure matches the configuration"}, "ProjectName": "project1"}]}`))
-	})
-	testServer := httptest.NewServer(testHandler)
-	defer testServer.Close()
+	url, err := drift.DriftHandler(mockClient, driftedProjects, repo, vcs.PullRequest{Body: "body"})
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/pull/1", url)
+}
 
-	driftCfg.AtlantisUrl = testServer.URL
+func TestCheckFailedProject(t *testing.T) {
+	atlantis := atlantistest.NewServer("test-token")
+	defer atlantis.Close()
+	atlantis.SetPlan("project1", atlantistest.FailedWith("project1", "exit status 1", "init failed"))
+	atlantis.SetPlan("project2", atlantistest.Drift("project2", "Plan: 1 to add"))
 
-	err := drift.Run(mockClient, repo, driftCfg)
-	assert.NoError(t, err)
+	driftCfg := config.DriftCfg{
+		AtlantisUrl:   atlantis.URL,
+		AtlantisToken: secret.Literal("test-token"),
+	}
+	client := &atlantisCfgClient{atlantisCfg: "version: 3\nprojects:\n- dir: project1\n- dir: project2\n"}
+	result, err := drift.Check(client, config.Repo{Name: "test-repo", Ref: "test-ref"}, driftCfg)
+	assert.Error(t, err)
+	assert.True(t, result.Failed())
+	assert.Equal(t, []string{"project1"}, result.ProjectNames(drift.StatusFailed))
+	assert.Equal(t, []string{"project2"}, result.ProjectNames(drift.StatusDrifted))
+	assert.Equal(t, "exit status 1: init failed", result.Projects[0].Error)
 }
 
-func TestApiPlan(t *testing.T) {
+func TestDryRunHandler(t *testing.T) {
 	mockClient := &MockClient{}
 	repo := config.Repo{
 		Name: "test-repo",
 		Ref:  "test-ref",
 	}
-	atlantisHost := "http://localhost:4141"
-	atlantisToken := "test-token"
-
-	// Note: In a real test scenario, you should replace the httptest.NewServer with a mock implementation of the Atlantis server.
-	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte(`{"ProjectResults": [{"PlanSuccess": {"TerraformOutput": "No changes. Your infrastructure matches the configuration"}, "ProjectName": "project1"}]}`))
-	})
-	testServer := httptest.NewServer(testHandler)
-	defer testServer.Close()
 
-	atlantisHost = testServer.URL
+	plan, err := drift.DryRunHandler(mockClient, []string{"project1", "project2"}, repo)
+	assert.NoError(t, err)
+	assert.Equal(t, &drift.PullPlan{
+		Branch:  "atlantis-drift-abc123",
+		Base:    "test-ref",
+		Title:   "Atlantis drift detector",
+		Comment: "atlantis plan -p project1|project2",
+	}, plan)
 
-	planResp, err := drift.ApiPlan(mockClient, repo, atlantisHost, atlantisToken)
+	plan, err = drift.DryRunHandler(mockClient, []string{}, repo)
 	assert.NoError(t, err)
-	assert.Equal(t, 1, len(planResp.ProjectResults))
-	assert.Equal(t, "No changes. Your infrastructure matches the configuration", planResp.ProjectResults[0].PlanSuccess.TerraformOutput)
-	assert.Equal(t, "project1", planResp.ProjectResults[0].ProjectName)
+	assert.Nil(t, plan)
 }
 
-func TestDriftHandler(t *testing.T) {
-	mockClient := &MockClient{}
-	driftedProjects := []string{"project1"}
+func TestRunDryRun(t *testing.T) {
+	mockClient := &atlantisCfgClient{atlantisCfg: "version: 3\nprojects:\n- dir: .\n"}
 	repo := config.Repo{
 		Name: "test-repo",
 		Ref:  "test-ref",
 	}
 
-	err := drift.DriftHandler(mockClient, driftedProjects, repo)
+	atlantis := atlantistest.NewServer("test-token")
+	defer atlantis.Close()
+	atlantis.SetPlan(".", atlantistest.Drift("project1", "Plan: 1 to add"))
+
+	driftCfg := config.DriftCfg{
+		AtlantisUrl:   atlantis.URL,
+		AtlantisToken: secret.Literal("test-token"),
+		DryRun:        true,
+	}
+
+	result, err := drift.Run(mockClient, repo, driftCfg)
+	assert.NoError(t, err)
+	assert.True(t, result.DryRun)
+	assert.Equal(t, "atlantis-drift-abc123", result.PlannedPull.Branch)
+	assert.Empty(t, result.PullURL)
+	assert.Zero(t, mockClient.pulls)
+	assert.Zero(t, mockClient.comments)
+}
+
+func TestRunAgainstFakeAtlantis(t *testing.T) {
+	const atlantisCfg = "version: 3\nprojects:\n- name: network\n  dir: network\n- name: compute\n  dir: compute\n  workspace: prod\n"
+	repo := config.Repo{Name: "owner/repo", Ref: "main"}
+	setup := func(t *testing.T) (*atlantistest.Server, *atlantisCfgClient, config.DriftCfg) {
+		atlantis := atlantistest.NewServer("test-token")
+		t.Cleanup(atlantis.Close)
+		driftCfg := config.DriftCfg{AtlantisUrl: atlantis.URL, AtlantisToken: secret.Literal("test-token")}
+		return atlantis, &atlantisCfgClient{atlantisCfg: atlantisCfg}, driftCfg
+	}
+
+	t.Run("no changes", func(t *testing.T) {
+		atlantis, client, driftCfg := setup(t)
+		atlantis.SetPlan("network", atlantistest.NoChanges("network"))
+		atlantis.SetPlan("compute", atlantistest.NoChanges("compute"))
+
+		result, err := drift.Run(client, repo, driftCfg)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"network", "compute"}, result.ProjectNames(drift.StatusClean))
+		assert.Equal(t, 0, client.pulls)
+		assert.Equal(t, []atlantistest.PlanRequest{{
+			Repository: "owner/repo",
+			Ref:        "main",
+			Type:       "github",
+			Paths:      []atlantistest.Path{{Directory: "network"}, {Directory: "compute", Workspace: "prod"}},
+		}}, atlantis.Requests())
+	})
+
+	t.Run("drift", func(t *testing.T) {
+		atlantis, client, driftCfg := setup(t)
+		atlantis.SetPlan("network", atlantistest.NoChanges("network"))
+		atlantis.SetPlan("compute", atlantistest.Drift("compute", "  # aws_instance.web will be updated in-place\nPlan: 0 to add, 1 to change, 0 to destroy."))
+
+		result, err := drift.Run(client, repo, driftCfg)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"compute"}, result.Actionable(repo.Handling))
+		assert.Equal(t, "prod", result.Projects[1].Workspace)
+		assert.Equal(t, "https://example.com/pull/1", result.PullURL)
+		assert.Equal(t, 1, client.pulls)
+		assert.Equal(t, 1, client.comments)
+	})
+
+	t.Run("plan error", func(t *testing.T) {
+		atlantis, client, driftCfg := setup(t)
+		atlantis.SetPlan("network", atlantistest.Failed("network", "exit status 1"))
+
+		result, err := drift.Run(client, repo, driftCfg)
+		assert.ErrorContains(t, err, "plan execution failed for following projects: [network]")
+		assert.Equal(t, []string{"network"}, result.ProjectNames(drift.StatusFailed))
+		assert.Equal(t, 0, client.pulls)
+	})
+
+	t.Run("slow plan", func(t *testing.T) {
+		atlantis, client, driftCfg := setup(t)
+		defer func(timeout time.Duration) { drift.PlanTimeout = timeout }(drift.PlanTimeout)
+		drift.PlanTimeout = 50 * time.Millisecond
+		atlantis.SetPlan("network", atlantistest.Plan{Project: "network", Output: atlantistest.NoChangesOutput, Delay: time.Second})
+
+		result, err := drift.Run(client, repo, driftCfg)
+		assert.ErrorContains(t, err, "Client.Timeout exceeded")
+		assert.True(t, result.Failed())
+		assert.Equal(t, 0, client.pulls)
+	})
+
+	t.Run("server error", func(t *testing.T) {
+		atlantis, client, driftCfg := setup(t)
+		atlantis.Fail(http.StatusBadGateway)
+
+		result, err := drift.Run(client, repo, driftCfg)
+		assert.ErrorContains(t, err, "502 Bad Gateway")
+		assert.True(t, result.Failed())
+		assert.Len(t, atlantis.Requests(), 1)
+	})
+
+	t.Run("wrong token", func(t *testing.T) {
+		atlantis, client, driftCfg := setup(t)
+		driftCfg.AtlantisToken = secret.Literal("wrong-token")
+
+		_, err := drift.Run(client, repo, driftCfg)
+		assert.ErrorContains(t, err, "401 Unauthorized")
+		assert.Empty(t, atlantis.Requests())
+	})
+}
+
+// reusingClient keeps a single drift PR through vcs.PullReuser.
+type reusingClient struct {
+	atlantisCfgClient
+	branches []string
+}
+
+func (c *reusingClient) ReusePull(repo, ref, branch string, pull vcs.PullRequest) (vcs.PullChanges, error) {
+	c.branches = append(c.branches, branch)
+	return vcs.PullChanges{Branch: branch, Commit: "def456", Number: 7, URL: "https://example.com/pull/7"}, nil
+}
+
+func TestRunLongLivedPull(t *testing.T) {
+	atlantis := atlantistest.NewServer("test-token")
+	defer atlantis.Close()
+	atlantis.SetPlan("network", atlantistest.Drift("network", "  # aws_route.a will be updated in-place\nPlan: 0 to add, 1 to change, 0 to destroy."))
+	driftCfg := config.DriftCfg{AtlantisUrl: atlantis.URL, AtlantisToken: secret.Literal("test-token")}
+	repo := config.Repo{Name: "owner/repo", Ref: "main", Pull: config.PullOptions{Mode: config.PullLongLived}}
+	const atlantisCfg = "version: 3\nprojects:\n- name: network\n  dir: network\n"
+
+	client := &reusingClient{atlantisCfgClient: atlantisCfgClient{atlantisCfg: atlantisCfg}}
+	for i := 0; i < 2; i++ {
+		result, err := drift.Run(client, repo, driftCfg)
+		assert.NoError(t, err)
+		assert.Equal(t, "https://example.com/pull/7", result.PullURL)
+	}
+	assert.Equal(t, []string{"atlantis-drift", "atlantis-drift"}, client.branches)
+	assert.Equal(t, 0, client.pulls)
+	assert.Equal(t, 2, client.comments, "every run comments on the same PR")
+
+	repo.Pull.Branch = "drift"
+	driftCfg.DryRun = true
+	result, err := drift.Run(client, repo, driftCfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "drift", result.PlannedPull.Branch)
+
+	driftCfg.DryRun = false
+	_, err = drift.Run(&atlantisCfgClient{atlantisCfg: atlantisCfg}, repo, driftCfg)
+	assert.ErrorContains(t, err, "github does not support long-lived drift PRs")
+}
+
+func TestRunMarker(t *testing.T) {
+	atlantis := atlantistest.NewServer("test-token")
+	defer atlantis.Close()
+	atlantis.SetPlan("network", atlantistest.Drift("network", "  # aws_route.a will be updated in-place\nPlan: 0 to add, 1 to change, 0 to destroy."))
+	driftCfg := config.DriftCfg{AtlantisUrl: atlantis.URL, AtlantisToken: secret.Literal("test-token"), RunID: "abc123"}
+	repo := config.Repo{Name: "owner/repo", Ref: "main", Marker: config.MarkerOptions{
+		Path:     ".drift/last-run",
+		Template: "{{.RunID}} {{.Repo}}@{{.Ref}}:{{range .Projects}} {{.}}{{end}}",
+	}}
+
+	client := &atlantisCfgClient{atlantisCfg: "version: 3\nprojects:\n- name: network\n  dir: network\n"}
+	_, err := drift.Run(client, repo, driftCfg)
+	assert.NoError(t, err)
+	assert.Equal(t, vcs.Marker{Path: ".drift/last-run", Content: "abc123 owner/repo@main: network"}, client.marker)
+
+	// A template failing to render opens no PR.
+	repo.Marker.Template = "{{index .Projects 5}}"
+	result, err := drift.Run(client, repo, driftCfg)
+	assert.ErrorContains(t, err, "rendering the drift marker")
+	assert.Equal(t, 1, client.pulls)
+	assert.Empty(t, result.PullURL)
+}
+
+func TestRunMergeRequest(t *testing.T) {
+	atlantis := atlantistest.NewServer("test-token")
+	defer atlantis.Close()
+	atlantis.SetPlan("network", atlantistest.Drift("network", "  # aws_route.a will be updated in-place\nPlan: 0 to add, 1 to change, 0 to destroy."))
+	driftCfg := config.DriftCfg{AtlantisUrl: atlantis.URL, AtlantisToken: secret.Literal("test-token")}
+	squash := false
+	repo := config.Repo{Name: "group/project", Ref: "main", Pull: config.PullOptions{MergeRequest: &config.MergeRequestOptions{
+		Reviewers: []string{"alice"},
+		Labels:    []string{"drift"},
+		Draft:     true,
+		Squash:    &squash,
+	}}}
+
+	client := &atlantisCfgClient{atlantisCfg: "version: 3\nprojects:\n- name: network\n  dir: network\n"}
+	_, err := drift.Run(client, repo, driftCfg)
 	assert.NoError(t, err)
+	assert.Equal(t, vcs.MergeRequestOptions{Reviewers: []string{"alice"}, Labels: []string{"drift"}, Draft: true, Squash: &squash}, client.mr)
 }
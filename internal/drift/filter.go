@@ -0,0 +1,39 @@
+package drift
+
+import (
+	"github.com/jukie/atlantis-drift-detection/internal/config"
+	"github.com/jukie/atlantis-drift-detection/internal/glob"
+)
+
+// FilterPaths applies the include and exclude filters of the repo. A path is
+// planned when it matches any include filter (or there are none) and no
+// exclude filter.
+func FilterPaths(paths []Path, repo config.Repo) (planned, skipped []Path) {
+	for _, p := range paths {
+		if (len(repo.Include) == 0 || matchesAny(repo.Include, p)) && !matchesAny(repo.Exclude, p) {
+			planned = append(planned, p)
+		} else {
+			skipped = append(skipped, p)
+		}
+	}
+	return planned, skipped
+}
+
+func matchesAny(filters []config.ProjectFilter, p Path) bool {
+	for _, f := range filters {
+		if matches(f, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func matches(f config.ProjectFilter, p Path) bool {
+	workspace := p.Workspace
+	if workspace == "" {
+		workspace = defaultWorkspace
+	}
+	return (f.Name == "" || glob.Match(f.Name, p.Name)) &&
+		(f.Dir == "" || glob.Match(f.Dir, p.Directory)) &&
+		(f.Workspace == "" || glob.Match(f.Workspace, workspace))
+}
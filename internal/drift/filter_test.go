@@ -0,0 +1,103 @@
+package drift_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jukie/atlantis-drift-detection/internal/config"
+	"github.com/jukie/atlantis-drift-detection/internal/drift"
+	"github.com/jukie/atlantis-drift-detection/internal/secret"
+	"github.com/stretchr/testify/assert"
+)
+
+// atlantisCfgClient serves a fixed atlantis.yaml.
+type atlantisCfgClient struct {
+	MockClient
+	atlantisCfg string
+}
+
+func (c *atlantisCfgClient) GetFileContent(repo, path, ref string) (bool, []byte, error) {
+	return true, []byte(c.atlantisCfg), nil
+}
+
+const filterAtlantisCfg = `version: 3
+projects:
+- name: network
+  dir: network
+- name: compute-prod
+  dir: envs/prod/compute
+  workspace: prod
+- name: compute-staging
+  dir: envs/staging/compute
+  workspace: staging
+- name: legacy
+  dir: legacy
+`
+
+func TestFilterPaths(t *testing.T) {
+	paths := []drift.Path{
+		{Name: "network", Directory: "network"},
+		{Name: "compute-prod", Directory: "envs/prod/compute", Workspace: "prod"},
+		{Name: "compute-staging", Directory: "envs/staging/compute", Workspace: "staging"},
+		{Name: "legacy", Directory: "legacy"},
+	}
+
+	planned, skipped := drift.FilterPaths(paths, config.Repo{})
+	assert.Equal(t, paths, planned)
+	assert.Empty(t, skipped)
+
+	planned, skipped = drift.FilterPaths(paths, config.Repo{
+		Include: []config.ProjectFilter{{Dir: "envs/**"}, {Workspace: "default"}},
+		Exclude: []config.ProjectFilter{{Name: "legacy"}, {Dir: "envs/*/compute", Workspace: "staging"}},
+	})
+	assert.Equal(t, []drift.Path{paths[0], paths[1]}, planned)
+	assert.Equal(t, []drift.Path{paths[2], paths[3]}, skipped)
+}
+
+func TestCheckSkippedProjects(t *testing.T) {
+	client := &atlantisCfgClient{atlantisCfg: filterAtlantisCfg}
+	repo := config.Repo{
+		Name:    "test-repo",
+		Ref:     "test-ref",
+		Exclude: []config.ProjectFilter{{Name: "compute-*"}},
+	}
+
+	var planReq drift.PlanApiRequest
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&planReq)
+		_, _ = w.Write([]byte(`{"ProjectResults": [{"PlanSuccess": {"TerraformOutput": "No changes. Your infrastructure matches the configuration"}, "ProjectName": "network"}, {"PlanSuccess": {"TerraformOutput": "No changes. Your infrastructure matches the configuration"}, "ProjectName": "legacy"}]}`))
+	})
+	testServer := httptest.NewServer(testHandler)
+	defer testServer.Close()
+
+	driftCfg := config.DriftCfg{
+		AtlantisUrl:   testServer.URL,
+		AtlantisToken: secret.Literal("test-token"),
+	}
+
+	result, err := drift.Check(client, repo, driftCfg)
+	assert.NoError(t, err)
+	assert.Equal(t, []drift.Path{{Directory: "network"}, {Directory: "legacy"}}, planReq.Paths)
+	assert.Equal(t, []string{"compute-prod", "compute-staging"}, result.ProjectNames(drift.StatusSkipped))
+	assert.Equal(t, []string{"network", "legacy"}, result.ProjectNames(drift.StatusClean))
+}
+
+func TestCheckAllProjectsSkipped(t *testing.T) {
+	client := &atlantisCfgClient{atlantisCfg: filterAtlantisCfg}
+	repo := config.Repo{
+		Name:    "test-repo",
+		Ref:     "test-ref",
+		Include: []config.ProjectFilter{{Name: "nothing"}},
+	}
+	driftCfg := config.DriftCfg{
+		AtlantisUrl:   "http://127.0.0.1:0",
+		AtlantisToken: secret.Literal("test-token"),
+	}
+
+	result, err := drift.Check(client, repo, driftCfg)
+	assert.NoError(t, err)
+	assert.Len(t, result.ProjectNames(drift.StatusSkipped), 4)
+	assert.False(t, result.FinishedAt.IsZero())
+}
@@ -0,0 +1,159 @@
+package drift
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jukie/atlantis-drift-detection/internal/logging"
+	"github.com/jukie/atlantis-drift-detection/internal/state"
+	"github.com/jukie/atlantis-drift-detection/internal/vcs"
+)
+
+var (
+	ansiRe = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+	// planNoiseRe matches lines that differ between runs without the planned
+	// changes differing, such as refresh progress and state locking.
+	planNoiseRe = regexp.MustCompile(`(Refreshing state\.\.\.|: Reading\.\.\.|: Read complete after|: Still reading\.\.\.|Acquiring state lock|Releasing state lock|^Running plan in|^Initializing)`)
+)
+
+// NormalizePlan strips colors, progress output and whitespace differences
+// from Terraform output so that identical change sets compare equal.
+func NormalizePlan(output string) string {
+	output = ansiRe.ReplaceAllString(output, "")
+	var lines []string
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || planNoiseRe.MatchString(strings.TrimSpace(line)) {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// PlanHash identifies the change set in Terraform output.
+func PlanHash(output string) string {
+	sum := sha256.Sum256([]byte(NormalizePlan(output)))
+	return hex.EncodeToString(sum[:])
+}
+
+// projectKey identifies a project across runs.
+func projectKey(p ProjectResult) string {
+	if p.Name != "" {
+		return p.Name
+	}
+	workspace := p.Workspace
+	if workspace == "" {
+		workspace = defaultWorkspace
+	}
+	return p.Dir + ":" + workspace
+}
+
+// compareWithHistory sets how each project's drift changed since the last
+// stored run, and how long drifted projects have been drifting. Ongoing drift
+// is suppressed only when an earlier run opened a PR for it; Run raises it
+// again once that PR is no longer open.
+func compareWithHistory(store state.Store, result *Result) error {
+	latest, err := store.Latest(result.Vcs, result.Repo, result.Ref)
+	if err != nil {
+		return err
+	}
+	previous := map[string]*state.Record{}
+	for i := range latest {
+		previous[latest[i].Project] = &latest[i]
+	}
+
+	for i := range result.Projects {
+		p := &result.Projects[i]
+		if p.Status != StatusClean && p.Status != StatusDrifted && p.Status != StatusPending {
+			continue
+		}
+		key := projectKey(*p)
+		prev := previous[key]
+		p.Change = state.Compare(prev, string(p.Status), p.PlanHash)
+		if prev != nil {
+			p.PreviousPlanHash = prev.PlanHash
+		}
+		if p.Change != state.ChangeOngoing && p.Change != state.ChangeChanged {
+			continue
+		}
+		if p.Change == state.ChangeOngoing && prev.PullURL != "" {
+			// Identical drift was already handled by an earlier run. Drift
+			// whose PR could not be opened is handled again.
+			p.Suppressed = true
+			p.PullURL = prev.PullURL
+		}
+		history, err := store.History(result.Vcs, result.Repo, result.Ref, key)
+		if err != nil {
+			return err
+		}
+		if since := state.DriftingSince(history); !since.IsZero() {
+			p.DriftingSince = &since
+		}
+	}
+	return nil
+}
+
+// raiseOutlivedDrift stops suppressing ongoing drift whose PR was closed or
+// merged without resolving it. Drift whose PR state cannot be looked up stays
+// suppressed.
+func raiseOutlivedDrift(client vcs.Client, result *Result, log *slog.Logger) {
+	stater, ok := client.(vcs.PullStater)
+	if !ok {
+		return
+	}
+	states := map[string]string{}
+	for i := range result.Projects {
+		p := &result.Projects[i]
+		if !p.Suppressed {
+			continue
+		}
+		state, ok := states[p.PullURL]
+		if !ok {
+			var err error
+			state, err = stater.PullState(result.Repo, p.PullURL)
+			if err != nil {
+				log.Warn("Looking up the state of the drift PR failed", "pull_url", p.PullURL, logging.Err(err))
+				state = vcs.PullOpen
+			}
+			states[p.PullURL] = state
+		}
+		if state != vcs.PullOpen {
+			p.Suppressed, p.PullURL = false, ""
+		}
+	}
+}
+
+// saveHistory stores the clean, drifted, pending and acknowledged projects of
+// the result. Skipped and failed projects carry no plan and are not recorded.
+func saveHistory(store state.Store, runID string, result Result) error {
+	var records []state.Record
+	now := time.Now()
+	for _, p := range result.Projects {
+		if p.Status != StatusClean && p.Status != StatusDrifted && p.Status != StatusPending && p.Status != StatusAcknowledged {
+			continue
+		}
+		records = append(records, state.Record{
+			RunID:     runID,
+			Time:      now,
+			Vcs:       result.Vcs,
+			Repo:      result.Repo,
+			Ref:       result.Ref,
+			Project:   projectKey(p),
+			Dir:       p.Dir,
+			Workspace: p.Workspace,
+			Status:    string(p.Status),
+			PlanHash:  p.PlanHash,
+			PullURL:   p.PullURL,
+		})
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	logging.Repo(runID, result.Vcs, result.Repo, result.Ref).Debug("Storing project results", "count", len(records))
+	return store.Save(records)
+}
@@ -0,0 +1,181 @@
+package drift_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/jukie/atlantis-drift-detection/internal/config"
+	"github.com/jukie/atlantis-drift-detection/internal/drift"
+	"github.com/jukie/atlantis-drift-detection/internal/notify"
+	"github.com/jukie/atlantis-drift-detection/internal/secret"
+	"github.com/jukie/atlantis-drift-detection/internal/state"
+	"github.com/jukie/atlantis-drift-detection/internal/vcs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanHash(t *testing.T) {
+	a := "\x1b[1maws_instance.web: Refreshing state... [id=i-123]\x1b[0m\n\n  # aws_instance.web will be updated in-place\nPlan: 0 to add, 1 to change, 0 to destroy.\n"
+	b := "aws_instance.web: Refreshing state... [id=i-456]\n  # aws_instance.web will be updated in-place   \nPlan: 0 to add, 1 to change, 0 to destroy."
+	c := "  # aws_instance.web will be destroyed\nPlan: 0 to add, 0 to change, 1 to destroy."
+
+	assert.Equal(t, drift.PlanHash(a), drift.PlanHash(b))
+	assert.NotEqual(t, drift.PlanHash(a), drift.PlanHash(c))
+}
+
+func TestCheckHistory(t *testing.T) {
+	output := "No changes. Your infrastructure matches the configuration"
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"ProjectResults": [{"PlanSuccess": {"TerraformOutput": "` + output + `"}, "ProjectName": "project1"}]}`))
+	})
+	testServer := httptest.NewServer(testHandler)
+	defer testServer.Close()
+
+	store, err := state.OpenFile(filepath.Join(t.TempDir(), "state.jsonl"))
+	assert.NoError(t, err)
+	defer store.Close()
+
+	repo := config.Repo{Name: "test-repo", Ref: "test-ref"}
+	driftCfg := config.DriftCfg{
+		AtlantisUrl:   testServer.URL,
+		AtlantisToken: secret.Literal("test-token"),
+		Store:         store,
+		DryRun:        true,
+	}
+	client := &MockClient{}
+	notifier := &recordingNotifier{}
+	driftCfg.Notifier = notifier
+	run := func(runID string) drift.ProjectResult {
+		driftCfg.RunID = runID
+		result, err := drift.Run(client, repo, driftCfg)
+		assert.NoError(t, err)
+		return result.Projects[0]
+	}
+
+	// Dry runs are compared with the history but not recorded.
+	output = "Plan: 1 to add, 0 to change, 0 to destroy."
+	assert.Equal(t, state.ChangeNew, run("dry").Change)
+
+	assert.Empty(t, notifier.messages)
+
+	driftCfg.DryRun = false
+	p := run("run1")
+	assert.Equal(t, state.ChangeNew, p.Change)
+	assert.Equal(t, "https://example.com/pull/1", p.PullURL)
+	assert.Equal(t, 1, client.pulls)
+	assert.Len(t, notifier.messages, 1)
+
+	// Identical ongoing drift is reported but not acted on again.
+	p = run("run2")
+	assert.Equal(t, state.ChangeOngoing, p.Change)
+	assert.True(t, p.Suppressed)
+	assert.NotNil(t, p.DriftingSince)
+	assert.Equal(t, p.PlanHash, p.PreviousPlanHash)
+	assert.Equal(t, "https://example.com/pull/1", p.PullURL)
+	assert.Equal(t, 1, client.pulls)
+	assert.Len(t, notifier.messages, 1)
+
+	// A different change set is handled again.
+	output = "Plan: 2 to add, 0 to change, 0 to destroy."
+	p = run("run3")
+	assert.Equal(t, state.ChangeChanged, p.Change)
+	assert.False(t, p.Suppressed)
+	assert.Equal(t, 2, client.pulls)
+	assert.Len(t, notifier.messages, 2)
+
+	output = "No changes. Your infrastructure matches the configuration"
+	driftCfg.DryRun = true
+	assert.Equal(t, state.ChangeResolved, run("run4").Change)
+
+	history, err := store.History("github", "test-repo", "test-ref", "project1")
+	assert.NoError(t, err)
+	assert.Len(t, history, 3)
+	assert.Equal(t, "run1", history[0].RunID)
+	assert.Equal(t, "https://example.com/pull/1", history[1].PullURL)
+}
+
+func TestCheckHistoryFailedPull(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"ProjectResults": [{"PlanSuccess": {"TerraformOutput": "Plan: 1 to add, 0 to change, 0 to destroy."}, "ProjectName": "project1"}]}`))
+	}))
+	defer testServer.Close()
+
+	store, err := state.OpenFile(filepath.Join(t.TempDir(), "state.jsonl"))
+	assert.NoError(t, err)
+	defer store.Close()
+
+	repo := config.Repo{Name: "test-repo", Ref: "test-ref"}
+	notifier := &recordingNotifier{}
+	driftCfg := config.DriftCfg{AtlantisUrl: testServer.URL, AtlantisToken: secret.Literal("test-token"), Store: store, Notifier: notifier}
+	client := &MockClient{pullErr: errors.New("A pull request already exists")}
+	_, err = drift.Run(client, repo, driftCfg)
+	assert.Error(t, err)
+
+	// Drift whose PR could not be opened is not suppressed as ongoing.
+	client.pullErr = nil
+	result, err := drift.Run(client, repo, driftCfg)
+	assert.NoError(t, err)
+	assert.Equal(t, state.ChangeOngoing, result.Projects[0].Change)
+	assert.False(t, result.Projects[0].Suppressed)
+	assert.Equal(t, "https://example.com/pull/1", result.PullURL)
+	assert.Equal(t, 1, client.pulls)
+	assert.Len(t, notifier.messages, 2)
+
+	result, err = drift.Run(client, repo, driftCfg)
+	assert.NoError(t, err)
+	assert.True(t, result.Projects[0].Suppressed)
+	assert.Equal(t, 1, client.pulls)
+}
+
+// pullStateClient reports the state of its PRs.
+type pullStateClient struct {
+	MockClient
+	state string
+}
+
+func (c *pullStateClient) PullState(repo, url string) (string, error) {
+	return c.state, nil
+}
+
+func TestRunHistoryClosedPull(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"ProjectResults": [{"PlanSuccess": {"TerraformOutput": "Plan: 1 to add, 0 to change, 0 to destroy."}, "ProjectName": "project1"}]}`))
+	}))
+	defer testServer.Close()
+
+	store, err := state.OpenFile(filepath.Join(t.TempDir(), "state.jsonl"))
+	assert.NoError(t, err)
+	defer store.Close()
+
+	repo := config.Repo{Name: "test-repo", Ref: "test-ref"}
+	notifier := &recordingNotifier{}
+	driftCfg := config.DriftCfg{AtlantisUrl: testServer.URL, AtlantisToken: secret.Literal("test-token"), Store: store, Notifier: notifier}
+	client := &pullStateClient{state: vcs.PullOpen}
+	for i := 0; i < 2; i++ {
+		_, err = drift.Run(client, repo, driftCfg)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 1, client.pulls)
+
+	// Drift that outlived its PR is raised again.
+	for _, closed := range []string{vcs.PullClosed, vcs.PullMerged} {
+		client.state = closed
+		result, err := drift.Run(client, repo, driftCfg)
+		assert.NoError(t, err)
+		assert.Equal(t, state.ChangeOngoing, result.Projects[0].Change)
+		assert.False(t, result.Projects[0].Suppressed)
+	}
+	assert.Equal(t, 3, client.pulls)
+	assert.Len(t, notifier.messages, 3)
+}
+
+type recordingNotifier struct {
+	messages []notify.Message
+}
+
+func (n *recordingNotifier) Notify(m notify.Message) error {
+	n.messages = append(n.messages, m)
+	return nil
+}
@@ -0,0 +1,77 @@
+package drift
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+	"sort"
+
+	"github.com/jukie/atlantis-drift-detection/internal/config"
+	"github.com/jukie/atlantis-drift-detection/internal/glob"
+)
+
+// applyIgnoreRules removes the resource changes matching the repo's ignore
+// rules from drifted and pending projects. A project whose changes are all
+// ignored is classified as clean. Projects whose plan could not be parsed into resource
+// changes are left alone.
+func applyIgnoreRules(rules []config.IgnoreRule, result *Result) {
+	if len(rules) == 0 {
+		return
+	}
+	for i := range result.Projects {
+		p := &result.Projects[i]
+		if (p.Status != StatusDrifted && p.Status != StatusPending) || len(p.Changes) == 0 {
+			continue
+		}
+		var kept []ResourceChange
+		for _, c := range p.Changes {
+			if ignored(rules, projectName(*p), c) {
+				p.Ignored = append(p.Ignored, c)
+			} else {
+				kept = append(kept, c)
+			}
+		}
+		if len(p.Ignored) == 0 {
+			continue
+		}
+		p.Changes = kept
+		// The ignored changes are often the ones that differ from night to
+		// night, so they must not affect the comparison with previous runs.
+		p.PlanHash = changesHash(kept)
+		p.Status = StatusClean
+		if len(kept) > 0 {
+			p.Status = changesStatus(kept)
+		}
+	}
+}
+
+func ignored(rules []config.IgnoreRule, project string, c ResourceChange) bool {
+	for _, r := range rules {
+		if (r.Project == "" || glob.Match(r.Project, project)) &&
+			(r.Address == "" || glob.MatchAddress(r.Address, c.Address)) &&
+			(r.Type == "" || glob.Match(r.Type, c.Type)) &&
+			(len(r.Actions) == 0 || slices.Contains(r.Actions, c.Action)) {
+			return true
+		}
+	}
+	return false
+}
+
+// changesHash identifies a set of resource changes, including their changed
+// values, regardless of order.
+func changesHash(changes []ResourceChange) string {
+	lines := make([]string, 0, len(changes))
+	for _, c := range changes {
+		line := c.Action + " " + c.Address
+		if c.Diff != "" {
+			line += "\n" + c.Diff
+		}
+		lines = append(lines, line)
+	}
+	sort.Strings(lines)
+	h := sha256.New()
+	for _, line := range lines {
+		h.Write([]byte(line + "\n"))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,111 @@
+package drift_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jukie/atlantis-drift-detection/internal/config"
+	"github.com/jukie/atlantis-drift-detection/internal/drift"
+	"github.com/jukie/atlantis-drift-detection/internal/secret"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckIgnoreRules(t *testing.T) {
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"ProjectResults": [
+			{"PlanSuccess": {"TerraformOutput": "  # aws_instance.web will be updated in-place\n  # aws_lambda_function.sync will be updated in-place\nPlan: 0 to add, 2 to change, 0 to destroy."}, "ProjectName": "compute"},
+			{"PlanSuccess": {"TerraformOutput": "  # aws_instance.db will be updated in-place\n  # aws_instance.cache will be destroyed\nPlan: 0 to add, 1 to change, 1 to destroy."}, "ProjectName": "database"},
+			{"PlanSuccess": {"TerraformOutput": "Plan: something unparseable"}, "ProjectName": "legacy"}
+		]}`))
+	})
+	testServer := httptest.NewServer(testHandler)
+	defer testServer.Close()
+
+	repo := config.Repo{
+		Name: "test-repo",
+		Ref:  "test-ref",
+		Ignore: []config.IgnoreRule{
+			{Type: "aws_instance", Actions: []string{"update"}},
+			{Project: "compute", Address: "aws_lambda_function.*"},
+		},
+	}
+	driftCfg := config.DriftCfg{
+		AtlantisUrl:   testServer.URL,
+		AtlantisToken: secret.Literal("test-token"),
+	}
+	result, err := drift.Check(&MockClient{}, repo, driftCfg)
+	assert.NoError(t, err)
+
+	compute, database, legacy := result.Projects[0], result.Projects[1], result.Projects[2]
+	assert.Equal(t, drift.StatusClean, compute.Status)
+	assert.Empty(t, compute.Changes)
+	assert.Len(t, compute.Ignored, 2)
+
+	assert.Equal(t, drift.StatusPending, database.Status)
+	assert.Equal(t, []drift.ResourceChange{{Address: "aws_instance.cache", Type: "aws_instance", Action: config.ActionDelete, Category: drift.CategoryPending}}, database.Changes)
+	assert.Equal(t, []drift.ResourceChange{{Address: "aws_instance.db", Type: "aws_instance", Action: config.ActionUpdate, Category: drift.CategoryPending}}, database.Ignored)
+	assert.NotEqual(t, drift.PlanHash("  # aws_instance.db will be updated in-place\n  # aws_instance.cache will be destroyed\nPlan: 0 to add, 1 to change, 1 to destroy."), database.PlanHash)
+
+	assert.Equal(t, drift.StatusDrifted, legacy.Status)
+	assert.Empty(t, legacy.Ignored)
+}
+
+func TestCheckIgnoreRulesUnnamedProjects(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"ProjectResults": [
+			{"PlanSuccess": {"TerraformOutput": "  # aws_instance.web will be updated in-place\nPlan: 0 to add, 1 to change, 0 to destroy."}, "RepoRelDir": "."},
+			{"PlanSuccess": {"TerraformOutput": "  # aws_instance.bastion will be updated in-place\nPlan: 0 to add, 1 to change, 0 to destroy."}, "RepoRelDir": "network"}
+		]}`))
+	}))
+	defer testServer.Close()
+
+	repo := config.Repo{
+		Name:   "test-repo",
+		Ref:    "test-ref",
+		Ignore: []config.IgnoreRule{{Project: "network", Type: "aws_instance"}},
+	}
+	driftCfg := config.DriftCfg{
+		AtlantisUrl:   testServer.URL,
+		AtlantisToken: secret.Literal("test-token"),
+	}
+	result, err := drift.Check(&MockClient{}, repo, driftCfg)
+	assert.NoError(t, err)
+
+	// The rule of the network directory leaves the repo root alone.
+	root, network := result.Projects[0], result.Projects[1]
+	assert.Equal(t, drift.StatusPending, root.Status)
+	assert.Empty(t, root.Ignored)
+	assert.Equal(t, drift.StatusClean, network.Status)
+	assert.Len(t, network.Ignored, 1)
+}
+
+func TestIgnoredPlanHashChangedValues(t *testing.T) {
+	var size, modified string
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		output := "  # aws_instance.db will be updated in-place\n  ~ resource \"aws_instance\" \"db\" {\n      ~ instance_type = \"t3.small\" -> \"" + size + "\"\n    }\n\n" +
+			"  # aws_lambda_function.sync will be updated in-place\n  ~ resource \"aws_lambda_function\" \"sync\" {\n      ~ last_modified = \"" + modified + "\"\n    }\n\n" +
+			"Plan: 0 to add, 2 to change, 0 to destroy."
+		b, _ := json.Marshal(map[string]interface{}{"ProjectResults": []interface{}{
+			map[string]interface{}{"ProjectName": "compute", "PlanSuccess": map[string]string{"TerraformOutput": output}},
+		}})
+		_, _ = w.Write(b)
+	}))
+	defer testServer.Close()
+
+	repo := config.Repo{Name: "test-repo", Ref: "test-ref", Ignore: []config.IgnoreRule{{Address: "aws_lambda_function.*"}}}
+	driftCfg := config.DriftCfg{AtlantisUrl: testServer.URL, AtlantisToken: secret.Literal("test-token")}
+	hash := func(s, m string) string {
+		size, modified = s, m
+		result, err := drift.Check(&MockClient{}, repo, driftCfg)
+		assert.NoError(t, err)
+		return result.Projects[0].PlanHash
+	}
+
+	// Only the ignored changes are left out of the comparison; a changed
+	// value of a kept change is a changed change set.
+	first := hash("t3.large", "monday")
+	assert.Equal(t, first, hash("t3.large", "tuesday"))
+	assert.NotEqual(t, first, hash("t3.xlarge", "tuesday"))
+}
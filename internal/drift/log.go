@@ -0,0 +1,13 @@
+package drift
+
+import (
+	"log/slog"
+
+	"github.com/jukie/atlantis-drift-detection/internal/config"
+	"github.com/jukie/atlantis-drift-detection/internal/logging"
+)
+
+// logger returns the logger of the run of driftCfg over the repo's ref.
+func logger(driftCfg config.DriftCfg, vcsType string, repo config.Repo) *slog.Logger {
+	return logging.Repo(driftCfg.RunID, vcsType, repo.Name, repo.Ref)
+}
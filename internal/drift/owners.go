@@ -0,0 +1,113 @@
+package drift
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jukie/atlantis-drift-detection/internal/audit"
+	"github.com/jukie/atlantis-drift-detection/internal/codeowners"
+	"github.com/jukie/atlantis-drift-detection/internal/config"
+	"github.com/jukie/atlantis-drift-detection/internal/logging"
+	"github.com/jukie/atlantis-drift-detection/internal/vcs"
+)
+
+// readCodeowners returns the CODEOWNERS file of the repo at its ref, or nil
+// when there is none.
+func readCodeowners(client vcs.Client, repo config.Repo) (*codeowners.File, error) {
+	locations := codeowners.Locations
+	if repo.Codeowners.Path != "" {
+		locations = []string{repo.Codeowners.Path}
+	}
+	for _, path := range locations {
+		exists, content, err := client.GetFileContent(repo.Name, path, repo.Ref)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			file, err := codeowners.Parse(content)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			return file, nil
+		}
+	}
+	return nil, nil
+}
+
+// assignOwners sets the owners of the drifted and pending projects from the
+// repo's CODEOWNERS file, when enabled. Projects are owned by the owners of
+// their directory.
+func assignOwners(client vcs.Client, repo config.Repo, driftCfg config.DriftCfg, result *Result) {
+	if !repo.Codeowners.Enabled {
+		return
+	}
+	file, err := readCodeowners(client, repo)
+	if err != nil {
+		logger(driftCfg, client.VcsType(), repo).Warn("Reading the CODEOWNERS file failed", logging.Err(err))
+		return
+	}
+	if file == nil {
+		logger(driftCfg, client.VcsType(), repo).Warn("No CODEOWNERS file found")
+		return
+	}
+	for i, p := range result.Projects {
+		if p.Status == StatusDrifted || p.Status == StatusPending {
+			result.Projects[i].Owners = file.Owners(p.Dir)
+		}
+	}
+}
+
+// projectOwners maps the projects of the drift PR to their owners, leaving
+// out those without any. Projects are keyed by projectName, like in
+// notifications. It returns nil when no project has owners.
+func projectOwners(result Result, h config.Handling) map[string][]string {
+	var owners map[string][]string
+	for _, p := range result.Projects {
+		if len(p.Owners) == 0 || !inPull(h, p) {
+			continue
+		}
+		if owners == nil {
+			owners = map[string][]string{}
+		}
+		owners[projectName(p)] = p.Owners
+	}
+	return owners
+}
+
+// reviewers returns the distinct owners of the projects of the drift PR,
+// sorted.
+func reviewers(result Result, h config.Handling) []string {
+	seen := map[string]bool{}
+	var all []string
+	for _, owners := range projectOwners(result, h) {
+		for _, o := range owners {
+			if !seen[o] {
+				seen[o] = true
+				all = append(all, o)
+			}
+		}
+	}
+	sort.Strings(all)
+	return all
+}
+
+// requestReviews asks the owners of the drifted projects to review the drift
+// PR. Failing to do so does not fail the run.
+func requestReviews(client vcs.Client, repo config.Repo, driftCfg config.DriftCfg, pull int, pullURL string, owners []string) {
+	if len(owners) == 0 {
+		return
+	}
+	requester, ok := client.(vcs.ReviewRequester)
+	if !ok {
+		logger(driftCfg, client.VcsType(), repo).Warn("The VCS does not support requesting reviews from code owners")
+		return
+	}
+	err := requester.RequestReviewers(repo.Name, pull, owners)
+	event := auditEvent(audit.ActionReviewRequest, client.VcsType(), repo)
+	event.PullURL, event.Detail = pullURL, strings.Join(owners, " ")
+	record(driftCfg, event, err)
+	if err != nil {
+		logger(driftCfg, client.VcsType(), repo).Error("Requesting reviews of the drift PR failed", "owners", owners, logging.Err(err))
+	}
+}
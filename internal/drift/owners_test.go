@@ -0,0 +1,102 @@
+package drift_test
+
+import (
+	"testing"
+
+	"github.com/jukie/atlantis-drift-detection/internal/atlantistest"
+	"github.com/jukie/atlantis-drift-detection/internal/config"
+	"github.com/jukie/atlantis-drift-detection/internal/drift"
+	"github.com/jukie/atlantis-drift-detection/internal/secret"
+	"github.com/stretchr/testify/assert"
+)
+
+// ownersClient serves a repo with a CODEOWNERS file and records the review
+// requests.
+type ownersClient struct {
+	MockClient
+	files     map[string]string
+	requested []string
+}
+
+func (c *ownersClient) GetFileContent(repo, path, ref string) (bool, []byte, error) {
+	content, ok := c.files[path]
+	return ok, []byte(content), nil
+}
+
+func (c *ownersClient) RequestReviewers(repo string, pull int, owners []string) error {
+	c.requested = append(c.requested, owners...)
+	return nil
+}
+
+func TestRunCodeowners(t *testing.T) {
+	atlantis := atlantistest.NewServer("test-token")
+	defer atlantis.Close()
+	atlantis.SetPlan("network", atlantistest.Drift("network", "  # aws_route.a will be updated in-place\nPlan: 0 to add, 1 to change, 0 to destroy."))
+	atlantis.SetPlan("envs/prod/db", atlantistest.Drift("db", "  # aws_db_instance.a will be updated in-place\nPlan: 0 to add, 1 to change, 0 to destroy."))
+	notifier := &recordingNotifier{}
+	driftCfg := config.DriftCfg{AtlantisUrl: atlantis.URL, AtlantisToken: secret.Literal("test-token"), Notifier: notifier}
+	repo := config.Repo{Name: "owner/repo", Ref: "main", Codeowners: config.CodeownersOptions{Enabled: true}}
+
+	client := &ownersClient{files: map[string]string{
+		"atlantis.yaml": "version: 3\nprojects:\n- name: network\n  dir: network\n- name: db\n  dir: envs/prod/db\n- name: compute\n  dir: compute\n",
+		"CODEOWNERS":    "* @org/platform\n/network/ @org/network @alice\n/envs/prod/ @org/sre\n",
+	}}
+	result, err := drift.Run(client, repo, driftCfg)
+	assert.NoError(t, err)
+	owners := map[string][]string{}
+	for _, p := range result.Projects {
+		owners[p.Dir] = p.Owners
+	}
+	// Clean projects are not routed anywhere.
+	assert.Equal(t, map[string][]string{"network": {"@org/network", "@alice"}, "envs/prod/db": {"@org/sre"}, "compute": nil}, owners)
+	assert.Equal(t, []string{"@alice", "@org/network", "@org/sre"}, client.requested)
+	assert.Equal(t, map[string][]string{"db": {"@org/sre"}, "network": {"@org/network", "@alice"}}, notifier.messages[0].Owners)
+
+	// Dry runs list the reviewers instead.
+	client.requested = nil
+	driftCfg.DryRun = true
+	result, err = drift.Run(client, repo, driftCfg)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"@alice", "@org/network", "@org/sre"}, result.PlannedPull.Reviewers)
+	assert.Empty(t, client.requested)
+
+	// Reviews can be left out, and a missing file routes nothing.
+	driftCfg.DryRun = false
+	no := false
+	repo.Codeowners.Reviewers = &no
+	_, err = drift.Run(client, repo, driftCfg)
+	assert.NoError(t, err)
+	assert.Empty(t, client.requested)
+	assert.NotEmpty(t, notifier.messages[1].Owners)
+
+	repo.Codeowners = config.CodeownersOptions{Enabled: true, Path: "OWNERS"}
+	result, err = drift.Run(client, repo, driftCfg)
+	assert.NoError(t, err)
+	for _, p := range result.Projects {
+		assert.Nil(t, p.Owners)
+	}
+	assert.Empty(t, client.requested)
+	assert.Nil(t, notifier.messages[2].Owners)
+}
+
+func TestRunCodeownersUnnamedProjects(t *testing.T) {
+	atlantis := atlantistest.NewServer("test-token")
+	defer atlantis.Close()
+	atlantis.SetPlan("network", atlantistest.Drift("", "  # aws_route.a will be updated in-place\nPlan: 0 to add, 1 to change, 0 to destroy."))
+	atlantis.SetPlan("envs/prod/db", atlantistest.Drift("", "  # aws_db_instance.a will be updated in-place\nPlan: 0 to add, 1 to change, 0 to destroy."))
+	notifier := &recordingNotifier{}
+	driftCfg := config.DriftCfg{AtlantisUrl: atlantis.URL, AtlantisToken: secret.Literal("test-token"), Notifier: notifier}
+	repo := config.Repo{Name: "owner/repo", Ref: "main", Codeowners: config.CodeownersOptions{Enabled: true}}
+
+	client := &ownersClient{files: map[string]string{
+		"atlantis.yaml": "version: 3\nprojects:\n- dir: network\n- dir: envs/prod/db\n- dir: compute\n",
+		"CODEOWNERS":    "/network/ @org/network\n/envs/prod/ @org/sre\n/compute/ @org/compute\n",
+	}}
+	_, err := drift.Run(client, repo, driftCfg)
+	assert.NoError(t, err)
+	// Unnamed projects are keyed by their directory, and the clean one is
+	// left out.
+	assert.Equal(t, []string{"@org/network", "@org/sre"}, client.requested)
+	assert.Equal(t, []string{"network", "envs/prod/db"}, notifier.messages[0].Projects)
+	assert.Equal(t, map[string][]string{"network": {"@org/network"}, "envs/prod/db": {"@org/sre"}}, notifier.messages[0].Owners)
+}
@@ -0,0 +1,158 @@
+package drift
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/jukie/atlantis-drift-detection/internal/config"
+)
+
+// Change categories.
+const (
+	// CategoryDrift is a change made outside of Terraform, or a planned change
+	// that undoes one.
+	CategoryDrift = "drift"
+	// CategoryPending is a configuration change that was never applied.
+	CategoryPending = "pending"
+)
+
+// ResourceChange is a single resource change found in a plan.
+type ResourceChange struct {
+	Address  string `json:"address"`
+	Type     string `json:"type"`
+	Action   string `json:"action"`
+	Category string `json:"category,omitempty"`
+	// Diff is the planned change of the resource's attributes, normalized so
+	// that only changed values make it differ between runs. It tells change
+	// sets on the same resources apart and is not part of the results.
+	Diff string `json:"-"`
+}
+
+var (
+	// resourceHeaderRe matches the "# <address> will be ..." line Terraform
+	// prints above every planned resource change.
+	resourceHeaderRe = regexp.MustCompile(`^\s*# (\S+)(?: \(deposed object \S+\))? (will be created|will be destroyed|will be updated in-place|must be replaced|will be replaced, as requested|will be read during apply)`)
+	headerActions    = map[string]string{
+		"will be created":                config.ActionCreate,
+		"will be destroyed":              config.ActionDelete,
+		"will be updated in-place":       config.ActionUpdate,
+		"must be replaced":               config.ActionReplace,
+		"will be replaced, as requested": config.ActionReplace,
+		"will be read during apply":      config.ActionRead,
+	}
+	// driftHeaderRe matches the resources listed under "Objects have changed
+	// outside of Terraform".
+	driftHeaderRe = regexp.MustCompile(`^\s*# (\S+) (has changed|has been deleted)`)
+	driftActions  = map[string]string{
+		"has changed":      config.ActionUpdate,
+		"has been deleted": config.ActionDelete,
+	}
+	indexRe = regexp.MustCompile(`\[[^\]]*\]`)
+)
+
+// ParseTextPlan extracts the resource changes from the human readable
+// Terraform plan output. Planned changes to resources that changed outside of
+// Terraform are drift, the other planned changes are pending.
+func ParseTextPlan(output string) []ResourceChange {
+	output = ansiRe.ReplaceAllString(output, "")
+	var planned, drifted []ResourceChange
+	// block is the change whose resource block is being read.
+	var block *ResourceChange
+	var body []string
+	endBlock := func() {
+		if block != nil {
+			block.Diff = strings.Join(body, "\n")
+		}
+		block, body = nil, nil
+	}
+	for _, line := range strings.Split(output, "\n") {
+		if m := driftHeaderRe.FindStringSubmatch(line); m != nil {
+			endBlock()
+			drifted = append(drifted, ResourceChange{
+				Address: m[1],
+				Type:    ResourceType(m[1]),
+				Action:  driftActions[m[2]],
+			})
+			block = &drifted[len(drifted)-1]
+		} else if m := resourceHeaderRe.FindStringSubmatch(line); m != nil {
+			endBlock()
+			planned = append(planned, ResourceChange{
+				Address: m[1],
+				Type:    ResourceType(m[1]),
+				Action:  headerActions[m[2]],
+			})
+			block = &planned[len(planned)-1]
+		} else if strings.TrimSpace(line) == "" || !strings.ContainsRune(" \t~+-<}", rune(line[0])) {
+			// Resource blocks are indented or start with a change symbol;
+			// anything else, such as the plan summary, ends them.
+			endBlock()
+		} else if block != nil {
+			body = append(body, strings.TrimSpace(line))
+		}
+	}
+	endBlock()
+	return categorize(planned, drifted)
+}
+
+// categorize marks the planned changes to drifted resources as drift and the
+// others as pending. Drifted resources without a planned change are appended,
+// since Terraform found them changed even though the plan accepts it.
+func categorize(planned, drifted []ResourceChange) []ResourceChange {
+	isDrifted := map[string]bool{}
+	for _, c := range drifted {
+		isDrifted[c.Address] = true
+	}
+	var changes []ResourceChange
+	isPlanned := map[string]bool{}
+	for _, c := range planned {
+		c.Category = CategoryPending
+		if isDrifted[c.Address] {
+			c.Category = CategoryDrift
+		}
+		changes = append(changes, c)
+		isPlanned[c.Address] = true
+	}
+	for _, c := range drifted {
+		if !isPlanned[c.Address] {
+			c.Category = CategoryDrift
+			changes = append(changes, c)
+		}
+	}
+	return changes
+}
+
+// changesStatus classifies a project with changes: drifted when any change is
+// drift, pending otherwise.
+func changesStatus(changes []ResourceChange) Status {
+	for _, c := range changes {
+		if c.Category == CategoryDrift {
+			return StatusDrifted
+		}
+	}
+	return StatusPending
+}
+
+// ResourceType returns the resource type of an address such as
+// module.app.aws_instance.web[0], i.e. aws_instance.
+func ResourceType(address string) string {
+	parts := strings.Split(indexRe.ReplaceAllString(address, ""), ".")
+	for len(parts) >= 2 && parts[0] == "module" {
+		parts = parts[2:]
+	}
+	if len(parts) > 0 && parts[0] == "data" {
+		parts = parts[1:]
+	}
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[0]
+}
+
+// Addresses returns the addresses of the changes.
+func Addresses(changes []ResourceChange) []string {
+	addresses := make([]string, 0, len(changes))
+	for _, c := range changes {
+		addresses = append(addresses, c.Address)
+	}
+	return addresses
+}
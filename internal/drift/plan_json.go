@@ -0,0 +1,105 @@
+package drift
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/jukie/atlantis-drift-detection/internal/config"
+)
+
+// jsonPlanMarker starts the output of `terraform show -json <planfile>`.
+const jsonPlanMarker = `{"format_version":`
+
+// jsonPlan is the subset of Terraform's JSON plan format used to classify
+// drift.
+type jsonPlan struct {
+	FormatVersion   string               `json:"format_version"`
+	ResourceChanges []jsonResourceChange `json:"resource_changes"`
+	ResourceDrift   []jsonResourceChange `json:"resource_drift"`
+}
+
+type jsonResourceChange struct {
+	Address string `json:"address"`
+	Type    string `json:"type"`
+	Change  struct {
+		Actions      []string    `json:"actions"`
+		Before       interface{} `json:"before"`
+		After        interface{} `json:"after"`
+		AfterUnknown interface{} `json:"after_unknown"`
+	} `json:"change"`
+}
+
+// diff renders the values of the change as compact JSON with sorted keys, or
+// "" when the plan has none.
+func (rc jsonResourceChange) diff() string {
+	c := rc.Change
+	if c.Before == nil && c.After == nil && c.AfterUnknown == nil {
+		return ""
+	}
+	b, err := json.Marshal(map[string]interface{}{"before": c.Before, "after": c.After, "after_unknown": c.AfterUnknown})
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// ParseJSONPlan extracts the resource changes, including the changes
+// Terraform detected outside of Terraform (resource_drift), from a plan in
+// `terraform show -json` format, categorized like ParseTextPlan does. No-op
+// changes are left out.
+func ParseJSONPlan(b []byte) ([]ResourceChange, error) {
+	var plan jsonPlan
+	if err := json.Unmarshal(b, &plan); err != nil {
+		return nil, fmt.Errorf("parsing JSON plan: %w", err)
+	}
+	if plan.FormatVersion == "" {
+		return nil, fmt.Errorf("parsing JSON plan: format_version is missing, expected the output of terraform show -json")
+	}
+
+	return categorize(jsonChanges(plan.ResourceChanges), jsonChanges(plan.ResourceDrift)), nil
+}
+
+func jsonChanges(resources []jsonResourceChange) []ResourceChange {
+	var changes []ResourceChange
+	for _, rc := range resources {
+		if action := jsonAction(rc.Change.Actions); action != "" {
+			changes = append(changes, ResourceChange{Address: rc.Address, Type: rc.Type, Action: action, Diff: rc.diff()})
+		}
+	}
+	return changes
+}
+
+// findJSONPlan looks for a JSON plan emitted into the Atlantis output, for
+// example by a custom workflow step running `terraform show -json`.
+func findJSONPlan(output string) ([]ResourceChange, bool) {
+	i := strings.Index(output, jsonPlanMarker)
+	if i < 0 {
+		return nil, false
+	}
+	var raw json.RawMessage
+	if err := json.NewDecoder(strings.NewReader(output[i:])).Decode(&raw); err != nil {
+		return nil, false
+	}
+	changes, err := ParseJSONPlan(raw)
+	if err != nil {
+		return nil, false
+	}
+	return changes, true
+}
+
+func jsonAction(actions []string) string {
+	switch strings.Join(actions, ",") {
+	case "create":
+		return config.ActionCreate
+	case "update":
+		return config.ActionUpdate
+	case "delete":
+		return config.ActionDelete
+	case "read":
+		return config.ActionRead
+	case "delete,create", "create,delete":
+		return config.ActionReplace
+	}
+	return ""
+}
@@ -0,0 +1,131 @@
+package drift_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jukie/atlantis-drift-detection/internal/config"
+	"github.com/jukie/atlantis-drift-detection/internal/drift"
+	"github.com/jukie/atlantis-drift-detection/internal/secret"
+	"github.com/stretchr/testify/assert"
+)
+
+const jsonPlan = `{"format_version":"1.2","terraform_version":"1.5.7","timestamp":"2023-08-01T10:00:00Z",` +
+	`"resource_drift":[` +
+	`{"address":"aws_instance.web","type":"aws_instance","change":{"actions":["update"]}},` +
+	`{"address":"aws_s3_bucket.old","type":"aws_s3_bucket","change":{"actions":["delete"]}}],` +
+	`"resource_changes":[` +
+	`{"address":"aws_instance.web","type":"aws_instance","change":{"actions":["update"]}},` +
+	`{"address":"aws_s3_bucket.old","type":"aws_s3_bucket","change":{"actions":["create"]}},` +
+	`{"address":"aws_security_group.this","type":"aws_security_group","change":{"actions":["create","delete"]}},` +
+	`{"address":"aws_iam_role.ci","type":"aws_iam_role","change":{"actions":["no-op"]}}]}`
+
+const cleanJSONPlan = `{"format_version":"1.2","timestamp":"2023-08-02T10:00:00Z","resource_changes":[` +
+	`{"address":"aws_iam_role.ci","type":"aws_iam_role","change":{"actions":["no-op"]}}]}`
+
+func TestParseJSONPlan(t *testing.T) {
+	changes, err := drift.ParseJSONPlan([]byte(jsonPlan))
+	assert.NoError(t, err)
+	assert.Equal(t, []drift.ResourceChange{
+		{Address: "aws_instance.web", Type: "aws_instance", Action: config.ActionUpdate, Category: drift.CategoryDrift},
+		{Address: "aws_s3_bucket.old", Type: "aws_s3_bucket", Action: config.ActionCreate, Category: drift.CategoryDrift},
+		{Address: "aws_security_group.this", Type: "aws_security_group", Action: config.ActionReplace, Category: drift.CategoryPending},
+	}, changes)
+
+	changes, err = drift.ParseJSONPlan([]byte(cleanJSONPlan))
+	assert.NoError(t, err)
+	assert.Empty(t, changes)
+
+	_, err = drift.ParseJSONPlan([]byte(`{"resource_changes":[]}`))
+	assert.ErrorContains(t, err, "format_version is missing")
+	_, err = drift.ParseJSONPlan([]byte("Plan: 1 to add"))
+	assert.ErrorContains(t, err, "parsing JSON plan")
+}
+
+func TestClassifyJSONOutput(t *testing.T) {
+	// A custom workflow step may print the JSON plan after the text plan.
+	var res drift.PlanApiResponse
+	assert.NoError(t, json.Unmarshal([]byte(`{"ProjectResults": [
+		{"PlanSuccess": {"TerraformOutput": "Plan: 1 to add, 1 to change, 1 to destroy.\n`+escape(jsonPlan)+`\n"}, "ProjectName": "json"},
+		{"PlanSuccess": {"TerraformOutput": "Terraform will perform the following actions:\n`+escape(cleanJSONPlan)+`"}, "ProjectName": "clean"},
+		{"PlanSuccess": {"TerraformOutput": "  # aws_instance.db will be destroyed\nPlan: 0 to add, 0 to change, 1 to destroy.\n{\"format_version\": broken"}, "ProjectName": "text"}
+	]}`), &res))
+
+	results := drift.Classify(res)
+	assert.Equal(t, drift.StatusDrifted, results[0].Status)
+	assert.Equal(t, drift.PlanFormatJSON, results[0].PlanFormat)
+	assert.Len(t, results[0].Changes, 3)
+
+	assert.Equal(t, drift.StatusClean, results[1].Status)
+	assert.Equal(t, drift.PlanFormatJSON, results[1].PlanFormat)
+
+	// Output that is not a valid JSON plan falls back to the text parser.
+	assert.Equal(t, drift.StatusPending, results[2].Status)
+	assert.Equal(t, drift.PlanFormatText, results[2].PlanFormat)
+	assert.Equal(t, []drift.ResourceChange{{Address: "aws_instance.db", Type: "aws_instance", Action: config.ActionDelete, Category: drift.CategoryPending}}, results[2].Changes)
+}
+
+func TestJSONPlanHashIgnoresTimestamp(t *testing.T) {
+	other := `{"format_version":"1.2","timestamp":"2023-09-01T10:00:00Z","resource_changes":[` +
+		`{"address":"aws_instance.web","type":"aws_instance","change":{"actions":["update"]}},` +
+		`{"address":"aws_s3_bucket.old","type":"aws_s3_bucket","change":{"actions":["create"]}},` +
+		`{"address":"aws_security_group.this","type":"aws_security_group","change":{"actions":["delete","create"]}}]}`
+	a, _ := drift.CheckPlans("Github", config.Repo{Name: "owner/repo"}, config.DriftCfg{}, map[string][]byte{"p": []byte(jsonPlan)})
+	b, _ := drift.CheckPlans("Github", config.Repo{Name: "owner/repo"}, config.DriftCfg{}, map[string][]byte{"p": []byte(other)})
+	assert.Equal(t, a.Projects[0].PlanHash, b.Projects[0].PlanHash)
+}
+
+func TestCheckPlans(t *testing.T) {
+	repo := config.Repo{
+		Name:   "owner/repo",
+		Ref:    "main",
+		Ignore: []config.IgnoreRule{{Type: "aws_security_group"}},
+	}
+	result, err := drift.CheckPlans("Github", repo, config.DriftCfg{}, map[string][]byte{
+		"network": []byte(jsonPlan),
+		"iam":     []byte(cleanJSONPlan),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "Github", result.Vcs)
+	assert.Equal(t, []string{"network"}, result.ProjectNames(drift.StatusDrifted))
+	assert.Equal(t, []string{"iam"}, result.ProjectNames(drift.StatusClean))
+	network := result.Projects[1]
+	assert.Len(t, network.Changes, 2)
+	assert.Len(t, network.Ignored, 1)
+
+	result, err = drift.CheckPlans("Github", repo, config.DriftCfg{}, map[string][]byte{"broken": []byte("not json")})
+	assert.ErrorContains(t, err, "plan execution failed for following projects: [broken]")
+	assert.Equal(t, drift.StatusFailed, result.Projects[0].Status)
+}
+
+func TestCheckJSONOutputFromAtlantis(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"ProjectResults": [{"PlanSuccess": {"TerraformOutput": "` + escape(jsonPlan) + `"}, "ProjectName": "network"}]}`))
+	}))
+	defer testServer.Close()
+
+	driftCfg := config.DriftCfg{AtlantisUrl: testServer.URL, AtlantisToken: secret.Literal("test-token")}
+	result, err := drift.Check(&MockClient{}, config.Repo{Name: "test-repo", Ref: "test-ref"}, driftCfg)
+	assert.NoError(t, err)
+	assert.Equal(t, drift.PlanFormatJSON, result.Projects[0].PlanFormat)
+	assert.Equal(t, drift.StatusDrifted, result.Projects[0].Status)
+}
+
+// escape quotes s for embedding in a JSON string literal.
+func escape(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b[1 : len(b)-1])
+}
+
+func TestJSONPlanHashChangedValues(t *testing.T) {
+	plan := func(after string) []byte {
+		return []byte(`{"format_version":"1.2","resource_changes":[` +
+			`{"address":"aws_instance.web","type":"aws_instance","change":{"actions":["update"],` +
+			`"before":{"instance_type":"t3.small"},"after":{"instance_type":"` + after + `"}}}]}`)
+	}
+	a, _ := drift.CheckPlans("Github", config.Repo{Name: "owner/repo"}, config.DriftCfg{}, map[string][]byte{"p": plan("t3.large")})
+	b, _ := drift.CheckPlans("Github", config.Repo{Name: "owner/repo"}, config.DriftCfg{}, map[string][]byte{"p": plan("t3.xlarge")})
+	assert.NotEqual(t, a.Projects[0].PlanHash, b.Projects[0].PlanHash)
+}
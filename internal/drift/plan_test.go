@@ -0,0 +1,140 @@
+package drift_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jukie/atlantis-drift-detection/internal/config"
+	"github.com/jukie/atlantis-drift-detection/internal/drift"
+	"github.com/jukie/atlantis-drift-detection/internal/secret"
+	"github.com/stretchr/testify/assert"
+)
+
+const textPlan = `aws_instance.web: Refreshing state... [id=i-123]
+
+Note: Objects have changed outside of Terraform
+
+Terraform detected the following changes made outside of Terraform since the
+last "terraform apply" which may have affected this plan:
+
+  # aws_instance.web has changed
+  ~ resource "aws_instance" "web" {
+      ~ tags_all = {
+          - "Team" = "platform" -> null
+        }
+    }
+
+  # aws_s3_bucket.gone has been deleted
+  - resource "aws_s3_bucket" "gone" {
+    }
+
+Unless you have made equivalent changes to your configuration, or ignored the
+relevant attributes using ignore_changes, the following plan may include
+actions to undo or respond to these changes.
+
+` + "────────" + `
+
+Terraform used the selected providers to generate the following execution
+plan. Resource actions are indicated with the following symbols:
+  + create
+  ~ update in-place
+  - destroy
+-/+ destroy and then create replacement
+
+Terraform will perform the following actions:
+
+  # aws_instance.web will be updated in-place
+  ~ resource "aws_instance" "web" {
+      ~ tags_all = {
+          + "Team" = "platform"
+        }
+    }
+
+  # module.app.aws_security_group.this[0] must be replaced
+-/+ resource "aws_security_group" "this" {
+    }
+
+  # aws_s3_bucket.logs["eu"] will be created
+  + resource "aws_s3_bucket" "logs" {
+    }
+
+  # data.aws_iam_policy_document.assume will be read during apply
+ <= data "aws_iam_policy_document" "assume" {
+    }
+
+  # aws_instance.old (deposed object 1a2b3c) will be destroyed
+  - resource "aws_instance" "old" {
+    }
+
+Plan: 2 to add, 1 to change, 2 to destroy.
+`
+
+func TestParseTextPlan(t *testing.T) {
+	changes := drift.ParseTextPlan(textPlan)
+	assert.Equal(t, []drift.ResourceChange{
+		{Address: "aws_instance.web", Type: "aws_instance", Action: config.ActionUpdate, Category: drift.CategoryDrift,
+			Diff: "~ resource \"aws_instance\" \"web\" {\n~ tags_all = {\n+ \"Team\" = \"platform\"\n}\n}"},
+		{Address: "module.app.aws_security_group.this[0]", Type: "aws_security_group", Action: config.ActionReplace, Category: drift.CategoryPending,
+			Diff: "-/+ resource \"aws_security_group\" \"this\" {\n}"},
+		{Address: `aws_s3_bucket.logs["eu"]`, Type: "aws_s3_bucket", Action: config.ActionCreate, Category: drift.CategoryPending,
+			Diff: "+ resource \"aws_s3_bucket\" \"logs\" {\n}"},
+		{Address: "data.aws_iam_policy_document.assume", Type: "aws_iam_policy_document", Action: config.ActionRead, Category: drift.CategoryPending,
+			Diff: "<= data \"aws_iam_policy_document\" \"assume\" {\n}"},
+		{Address: "aws_instance.old", Type: "aws_instance", Action: config.ActionDelete, Category: drift.CategoryPending,
+			Diff: "- resource \"aws_instance\" \"old\" {\n}"},
+		{Address: "aws_s3_bucket.gone", Type: "aws_s3_bucket", Action: config.ActionDelete, Category: drift.CategoryDrift,
+			Diff: "- resource \"aws_s3_bucket\" \"gone\" {\n}"},
+	}, changes)
+}
+
+func TestClassifyDriftAndPending(t *testing.T) {
+	var res drift.PlanApiResponse
+	assert.NoError(t, json.Unmarshal([]byte(`{"ProjectResults": [
+		{"PlanSuccess": {"TerraformOutput": "`+escape(textPlan)+`"}, "ProjectName": "drifted"},
+		{"PlanSuccess": {"TerraformOutput": "  # aws_instance.db will be created\nPlan: 1 to add, 0 to change, 0 to destroy."}, "ProjectName": "pending"},
+		{"PlanSuccess": {"TerraformOutput": "Plan: something unparseable"}, "ProjectName": "unknown"}
+	]}`), &res))
+
+	results := drift.Classify(res)
+	assert.Equal(t, drift.StatusDrifted, results[0].Status)
+	assert.Equal(t, drift.StatusPending, results[1].Status)
+	// Changes that cannot be categorized are treated as drift.
+	assert.Equal(t, drift.StatusDrifted, results[2].Status)
+}
+
+func TestResourceType(t *testing.T) {
+	assert.Equal(t, "aws_instance", drift.ResourceType("aws_instance.web"))
+	assert.Equal(t, "aws_instance", drift.ResourceType(`module.a["x"].module.b.aws_instance.web[0]`))
+	assert.Equal(t, "aws_ami", drift.ResourceType("data.aws_ami.ubuntu"))
+	assert.Equal(t, "", drift.ResourceType("garbage"))
+}
+
+func TestRunPendingHandling(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"ProjectResults": [
+			{"PlanSuccess": {"TerraformOutput": "` + escape(textPlan) + `"}, "ProjectName": "network"},
+			{"PlanSuccess": {"TerraformOutput": "  # aws_instance.db will be created\nPlan: 1 to add, 0 to change, 0 to destroy."}, "ProjectName": "database"}
+		]}`))
+	}))
+	defer testServer.Close()
+
+	driftCfg := config.DriftCfg{AtlantisUrl: testServer.URL, AtlantisToken: secret.Literal("test-token")}
+	repo := config.Repo{Name: "test-repo", Ref: "test-ref", Handling: config.Handling{Pending: config.HandlingReport}}
+	client := &MockClient{}
+	result, err := drift.Run(client, repo, driftCfg)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"network"}, result.Actionable(repo.Handling))
+	assert.Equal(t, []string{"database"}, result.ProjectNames(drift.StatusPending))
+	assert.NotEmpty(t, result.Projects[0].PullURL)
+	assert.Empty(t, result.Projects[1].PullURL)
+
+	// With only pending changes reported, no PR is opened.
+	repo.Handling.Drift = config.HandlingReport
+	client = &MockClient{}
+	result, err = drift.Run(client, repo, driftCfg)
+	assert.NoError(t, err)
+	assert.Empty(t, result.PullURL)
+	assert.Equal(t, 0, client.pulls)
+}
@@ -0,0 +1,180 @@
+package drift
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/jukie/atlantis-drift-detection/internal/ack"
+	"github.com/jukie/atlantis-drift-detection/internal/config"
+	"github.com/jukie/atlantis-drift-detection/internal/state"
+)
+
+type Status string
+
+// Plan formats a project result was classified from.
+const (
+	PlanFormatText = "text"
+	PlanFormatJSON = "json"
+)
+
+const (
+	StatusClean Status = "clean"
+	// StatusDrifted marks projects with changes made outside of Terraform.
+	StatusDrifted Status = "drifted"
+	// StatusPending marks projects whose only changes are configuration
+	// changes that were never applied.
+	StatusPending Status = "pending"
+	StatusFailed  Status = "failed"
+	// StatusSkipped marks projects excluded from planning by the repo filters.
+	StatusSkipped Status = "skipped"
+	// StatusAcknowledged marks drift covered by an active acknowledgement.
+	StatusAcknowledged Status = "acknowledged"
+)
+
+// ProjectResult is the outcome of planning a single Atlantis project.
+type ProjectResult struct {
+	Name      string `json:"name"`
+	Dir       string `json:"dir,omitempty"`
+	Workspace string `json:"workspace,omitempty"`
+	Status    Status `json:"status"`
+	Output    string `json:"output,omitempty"`
+	Error     string `json:"error,omitempty"`
+	// PlanFormat is the format the result was classified from.
+	PlanFormat string `json:"planFormat,omitempty"`
+	// PlanHash identifies the normalized change set of the plan.
+	PlanHash string `json:"planHash,omitempty"`
+	// Change, PreviousPlanHash and DriftingSince compare the project with the
+	// previous run and are only set when a state store is configured.
+	Change           state.Change `json:"change,omitempty"`
+	PreviousPlanHash string       `json:"previousPlanHash,omitempty"`
+	DriftingSince    *time.Time   `json:"driftingSince,omitempty"`
+	// Suppressed is set for ongoing drift identical to the previous run, which
+	// gets no new PR comment or notification.
+	Suppressed bool `json:"suppressed,omitempty"`
+	// PullURL is the drift PR that handles this project's drift.
+	PullURL string `json:"pullUrl,omitempty"`
+	// Owners are the CODEOWNERS owners of the project's directory.
+	Owners []string `json:"owners,omitempty"`
+	// Changes are the resource changes parsed from the plan, without the
+	// ignored ones.
+	Changes []ResourceChange `json:"changes,omitempty"`
+	// Ignored are the resource changes matched by the repo's ignore rules.
+	Ignored []ResourceChange `json:"ignored,omitempty"`
+	// Acknowledgement is the rule that acknowledged the drift.
+	Acknowledgement *ack.Rule `json:"acknowledgement,omitempty"`
+	// AutoApply is the outcome of applying the drift through Atlantis when
+	// the project matches an auto-apply rule of the repo.
+	AutoApply *ApplyOutcome `json:"autoApply,omitempty"`
+}
+
+// projectName names p in rules, PRs and reports: by its Atlantis project
+// name, or by its directory when it has none.
+func projectName(p ProjectResult) string {
+	if p.Name != "" {
+		return p.Name
+	}
+	return p.Dir
+}
+
+// Result is the outcome of checking one repo.
+type Result struct {
+	Vcs        string          `json:"vcs"`
+	Repo       string          `json:"repo"`
+	Ref        string          `json:"ref"`
+	StartedAt  time.Time       `json:"startedAt"`
+	FinishedAt time.Time       `json:"finishedAt"`
+	Projects   []ProjectResult `json:"projects"`
+	PullURL    string          `json:"pullUrl,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	// DryRun is set when no changes were made in the VCS; PlannedPull then
+	// describes the PR that would have been opened.
+	DryRun      bool      `json:"dryRun,omitempty"`
+	PlannedPull *PullPlan `json:"plannedPull,omitempty"`
+}
+
+// Summary is the outcome of checking every configured repo in one run.
+type Summary struct {
+	RunID      string    `json:"runId"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	Results    []Result  `json:"results"`
+}
+
+// ProjectNames returns the names of the projects with the given status.
+func (r Result) ProjectNames(status Status) []string {
+	names := []string{}
+	for _, p := range r.Projects {
+		if p.Status == status {
+			names = append(names, p.Name)
+		}
+	}
+	return names
+}
+
+// Actionable returns the names of the drifted and pending projects that the
+// handling sends to a PR and are neither suppressed nor applied
+// automatically, i.e. new changes, changed changes or any changes when there
+// is no history to compare with.
+func (r Result) Actionable(h config.Handling) []string {
+	names := []string{}
+	for _, p := range r.Projects {
+		if inPull(h, p) {
+			names = append(names, p.Name)
+		}
+	}
+	return names
+}
+
+// pullProjectNames returns the projects of Actionable by projectName, which
+// names unnamed projects by their directory.
+func (r Result) pullProjectNames(h config.Handling) []string {
+	var names []string
+	for _, p := range r.Projects {
+		if inPull(h, p) {
+			names = append(names, projectName(p))
+		}
+	}
+	return names
+}
+
+// inPull reports whether the project's drift goes into the drift PR.
+func inPull(h config.Handling, p ProjectResult) bool {
+	return handledByPR(h, p.Status) && !p.Suppressed && !p.AutoApply.resolved()
+}
+
+func handledByPR(h config.Handling, status Status) bool {
+	switch status {
+	case StatusDrifted:
+		return h.Drift != config.HandlingReport
+	case StatusPending:
+		return h.Pending != config.HandlingReport
+	}
+	return false
+}
+
+// Failed reports whether the repo could not be fully checked.
+func (r Result) Failed() bool {
+	return r.Error != "" || len(r.ProjectNames(StatusFailed)) > 0
+}
+
+// Failed reports whether any repo in the run could not be fully checked.
+func (s Summary) Failed() bool {
+	for _, r := range s.Results {
+		if r.Failed() {
+			return true
+		}
+	}
+	return false
+}
+
+// NewRunID returns a random identifier used to correlate everything done in
+// a single run.
+func NewRunID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
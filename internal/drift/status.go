@@ -0,0 +1,93 @@
+package drift
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jukie/atlantis-drift-detection/internal/audit"
+	"github.com/jukie/atlantis-drift-detection/internal/config"
+	"github.com/jukie/atlantis-drift-detection/internal/logging"
+	"github.com/jukie/atlantis-drift-detection/internal/vcs"
+)
+
+// reportStatus reports the result on the head commit of the repo's ref when
+// the repo enables commit statuses and the client supports them.
+func reportStatus(client vcs.Client, repo config.Repo, driftCfg config.DriftCfg, result Result) {
+	if !repo.CommitStatus.Enabled {
+		return
+	}
+	reporter, ok := client.(vcs.StatusReporter)
+	if !ok {
+		logger(driftCfg, client.VcsType(), repo).Warn("The VCS does not support commit statuses, not reporting drift")
+		return
+	}
+	status := CommitStatus(repo, result)
+	err := reporter.ReportStatus(repo.Name, repo.Ref, status)
+	event := auditEvent(audit.ActionStatus, client.VcsType(), repo)
+	event.PullURL, event.Detail = result.PullURL, fmt.Sprintf("%s %s: %s", status.Context, status.State, status.Title)
+	record(driftCfg, event, err)
+	if err != nil {
+		logger(driftCfg, client.VcsType(), repo).Error("Reporting the commit status failed", logging.Err(err))
+	}
+}
+
+// CommitStatus summarizes the result for the head commit of the repo's ref.
+// Drifted and pending projects count as drifted; skipped projects are left
+// out.
+func CommitStatus(repo config.Repo, result Result) vcs.CommitStatus {
+	status := vcs.CommitStatus{
+		Context: repo.CommitStatus.Context,
+		State:   vcs.StateClean,
+		URL:     result.PullURL,
+	}
+	if status.Context == "" {
+		status.Context = config.DefaultStatusContext
+	}
+	if status.URL == "" {
+		status.URL = repo.CommitStatus.URL
+	}
+
+	var summary strings.Builder
+	summary.WriteString("| Project | Dir | Workspace | Status |\n|---------|-----|-----------|--------|\n")
+	counts := map[string]int{}
+	total := 0
+	for _, p := range result.Projects {
+		if p.Status == StatusSkipped {
+			continue
+		}
+		total++
+		ps := vcs.ProjectStatus{Name: projectName(p), Dir: p.Dir, State: vcs.StateClean}
+		switch p.Status {
+		case StatusDrifted, StatusPending:
+			ps.State = vcs.StateDrifted
+			ps.Message = fmt.Sprintf("%s with %d resource change(s)", p.Status, len(p.Changes))
+			status.State = vcs.StateDrifted
+		case StatusFailed:
+			ps.State = vcs.StateFailed
+			ps.Message = "plan failed: " + p.Error
+			if status.State == vcs.StateClean {
+				status.State = vcs.StateFailed
+			}
+		}
+		counts[string(p.Status)]++
+		status.Projects = append(status.Projects, ps)
+		fmt.Fprintf(&summary, "| %s | %s | %s | %s |\n", ps.Name, p.Dir, p.Workspace, p.Status)
+	}
+
+	var parts []string
+	for _, s := range []Status{StatusDrifted, StatusPending, StatusFailed} {
+		if n := counts[string(s)]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, s))
+		}
+	}
+	if len(parts) == 0 {
+		status.Title = fmt.Sprintf("No drift in %d project(s)", total)
+	} else {
+		status.Title = fmt.Sprintf("%s of %d project(s)", strings.Join(parts, ", "), total)
+	}
+	if result.PullURL != "" {
+		fmt.Fprintf(&summary, "\nDrift PR: %s\n", result.PullURL)
+	}
+	status.Summary = summary.String()
+	return status
+}
@@ -0,0 +1,85 @@
+package drift_test
+
+import (
+	"testing"
+
+	"github.com/jukie/atlantis-drift-detection/internal/atlantistest"
+	"github.com/jukie/atlantis-drift-detection/internal/config"
+	"github.com/jukie/atlantis-drift-detection/internal/drift"
+	"github.com/jukie/atlantis-drift-detection/internal/secret"
+	"github.com/jukie/atlantis-drift-detection/internal/vcs"
+	"github.com/stretchr/testify/assert"
+)
+
+// statusClient records the statuses reported through vcs.StatusReporter.
+type statusClient struct {
+	atlantisCfgClient
+	statuses []vcs.CommitStatus
+}
+
+func (c *statusClient) ReportStatus(repo, ref string, status vcs.CommitStatus) error {
+	c.statuses = append(c.statuses, status)
+	return nil
+}
+
+func TestCommitStatus(t *testing.T) {
+	result := drift.Result{Projects: []drift.ProjectResult{
+		{Name: "network", Dir: "network", Workspace: "default", Status: drift.StatusClean},
+		{Name: "compute", Dir: "compute", Workspace: "prod", Status: drift.StatusDrifted, Changes: []drift.ResourceChange{{Address: "aws_instance.web"}}},
+		{Name: "database", Dir: "database", Status: drift.StatusFailed, Error: "exit status 1"},
+		{Name: "legacy", Dir: "legacy", Status: drift.StatusSkipped},
+	}}
+	repo := config.Repo{CommitStatus: config.CommitStatusOptions{Enabled: true, URL: "https://drift.example.com"}}
+
+	status := drift.CommitStatus(repo, result)
+	assert.Equal(t, "atlantis/drift", status.Context)
+	assert.Equal(t, vcs.StateDrifted, status.State)
+	assert.Equal(t, "1 drifted, 1 failed of 3 project(s)", status.Title)
+	assert.Equal(t, "https://drift.example.com", status.URL)
+	assert.Equal(t, []vcs.ProjectStatus{
+		{Name: "network", Dir: "network", State: vcs.StateClean},
+		{Name: "compute", Dir: "compute", State: vcs.StateDrifted, Message: "drifted with 1 resource change(s)"},
+		{Name: "database", Dir: "database", State: vcs.StateFailed, Message: "plan failed: exit status 1"},
+	}, status.Projects)
+	assert.Contains(t, status.Summary, "| compute | compute | prod | drifted |")
+
+	result.PullURL = "https://example.com/pull/1"
+	result.Projects = result.Projects[:1]
+	repo.CommitStatus.Context = "drift/prod"
+	status = drift.CommitStatus(repo, result)
+	assert.Equal(t, "drift/prod", status.Context)
+	assert.Equal(t, vcs.StateClean, status.State)
+	assert.Equal(t, "No drift in 1 project(s)", status.Title)
+	assert.Equal(t, "https://example.com/pull/1", status.URL)
+}
+
+func TestRunReportsStatus(t *testing.T) {
+	atlantis := atlantistest.NewServer("test-token")
+	defer atlantis.Close()
+	atlantis.SetPlan("network", atlantistest.Drift("network", "  # aws_route.a will be updated in-place\nPlan: 0 to add, 1 to change, 0 to destroy."))
+	driftCfg := config.DriftCfg{AtlantisUrl: atlantis.URL, AtlantisToken: secret.Literal("test-token")}
+	repo := config.Repo{Name: "owner/repo", Ref: "main", CommitStatus: config.CommitStatusOptions{Enabled: true}}
+	newClient := func() *statusClient {
+		return &statusClient{atlantisCfgClient: atlantisCfgClient{atlantisCfg: "version: 3\nprojects:\n- name: network\n  dir: network\n"}}
+	}
+
+	client := newClient()
+	_, err := drift.Run(client, repo, driftCfg)
+	assert.NoError(t, err)
+	assert.Len(t, client.statuses, 1)
+	assert.Equal(t, "1 pending of 1 project(s)", client.statuses[0].Title)
+	assert.Equal(t, "https://example.com/pull/1", client.statuses[0].URL)
+
+	client = newClient()
+	driftCfg.DryRun = true
+	_, err = drift.Run(client, repo, driftCfg)
+	assert.NoError(t, err)
+	assert.Empty(t, client.statuses)
+
+	client = newClient()
+	driftCfg.DryRun = false
+	repo.CommitStatus.Enabled = false
+	_, err = drift.Run(client, repo, driftCfg)
+	assert.NoError(t, err)
+	assert.Empty(t, client.statuses)
+}
@@ -0,0 +1,114 @@
+// Package glob matches slash-separated names such as project dirs and
+// resource addresses against shell-style patterns.
+//
+// "*" matches any run of characters except "/", "**" matches any run of
+// characters including "/", "?" matches a single character other than "/"
+// and "[...]" matches a character class as in path.Match.
+//
+// Terraform resource addresses use brackets for indexes, as in
+// aws_instance.web[0], so MatchAddress treats "[" and "]" as themselves.
+package glob
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+type cacheKey struct {
+	pattern string
+	address bool
+}
+
+var (
+	cacheMu sync.Mutex
+	cache   = map[cacheKey]*regexp.Regexp{}
+)
+
+// Match reports whether s matches pattern. Invalid patterns never match; use
+// Validate to report them.
+func Match(pattern, s string) bool {
+	re, err := compile(pattern, false)
+	if err != nil {
+		return false
+	}
+	return re.MatchString(s)
+}
+
+// Validate returns an error if pattern is malformed.
+func Validate(pattern string) error {
+	_, err := compile(pattern, false)
+	return err
+}
+
+// MatchAddress reports whether a resource address matches pattern. Unlike in
+// Match, brackets match themselves, so aws_instance.web[0] matches only that
+// instance and aws_instance.web[*] matches all of them.
+func MatchAddress(pattern, address string) bool {
+	re, err := compile(pattern, true)
+	if err != nil {
+		return false
+	}
+	return re.MatchString(address)
+}
+
+// ValidateAddress returns an error if the resource address pattern is
+// malformed.
+func ValidateAddress(pattern string) error {
+	_, err := compile(pattern, true)
+	return err
+}
+
+func compile(pattern string, address bool) (*regexp.Regexp, error) {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	key := cacheKey{pattern, address}
+	if re, ok := cache[key]; ok {
+		return re, nil
+	}
+
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				b.WriteString(".*")
+				i++
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[' && !address:
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid pattern %q: unterminated character class", pattern)
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		case c == '\\':
+			if i+1 < len(pattern) {
+				i++
+				c = pattern[i]
+			}
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+	}
+	cache[key] = re
+	return re, nil
+}
@@ -0,0 +1,57 @@
+package glob_test
+
+import (
+	"testing"
+
+	"github.com/jukie/atlantis-drift-detection/internal/glob"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		pattern string
+		s       string
+		want    bool
+	}{
+		{"network", "network", true},
+		{"network", "network/vpc", false},
+		{"envs/*", "envs/prod", true},
+		{"envs/*", "envs/prod/eu", false},
+		{"envs/**", "envs/prod/eu", true},
+		{"**/legacy", "a/b/legacy", true},
+		{"aws_autoscaling_group.*", "aws_autoscaling_group.web", true},
+		{"module.*.aws_instance.app[?]", "module.app.aws_instance.app[0]", false},
+		{`module.*.aws_instance.app\[?\]`, "module.app.aws_instance.app[0]", true},
+		{"prod-[ab]", "prod-a", true},
+		{"prod-[!ab]", "prod-a", false},
+		{"prod-[!ab]", "prod-c", true},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, glob.Match(c.pattern, c.s), "%s ~ %s", c.pattern, c.s)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, glob.Validate("envs/**"))
+	assert.Error(t, glob.Validate("envs/[prod"))
+	assert.False(t, glob.Match("envs/[prod", "envs/[prod"))
+}
+
+func TestMatchAddress(t *testing.T) {
+	cases := []struct {
+		pattern string
+		address string
+		want    bool
+	}{
+		{"aws_instance.web[0]", "aws_instance.web[0]", true},
+		{"aws_instance.web[0]", "aws_instance.web0", false},
+		{"aws_instance.web[*]", "aws_instance.web[1]", true},
+		{"module.*.aws_instance.app[?]", "module.app.aws_instance.app[0]", true},
+		{`aws_s3_bucket.this["logs"]`, `aws_s3_bucket.this["logs"]`, true},
+		{`aws_s3_bucket.\*`, "aws_s3_bucket.logs", false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, glob.MatchAddress(c.pattern, c.address), "%s ~ %s", c.pattern, c.address)
+	}
+	assert.NoError(t, glob.ValidateAddress("aws_instance.web["))
+}
@@ -0,0 +1,97 @@
+// Package logging configures the structured log/slog logger every package
+// writes to, and names the fields shared by their log lines.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Fields identifying what a log line is about.
+const (
+	KeyRunID   = "run_id"
+	KeyVcs     = "vcs"
+	KeyRepo    = "repo"
+	KeyRef     = "ref"
+	KeyProject = "project"
+	KeyError   = "error"
+)
+
+// Log formats.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+var levelNames = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+var level = new(slog.LevelVar)
+
+func init() {
+	slog.SetDefault(slog.New(newHandler(os.Stderr, FormatText)))
+}
+
+// ParseLevel converts a level name such as "info" into a slog.Level.
+func ParseLevel(name string) (slog.Level, error) {
+	l, ok := levelNames[strings.ToLower(name)]
+	if !ok {
+		return slog.LevelInfo, fmt.Errorf("unknown log level %q, expected one of: debug, info, warn, error", name)
+	}
+	return l, nil
+}
+
+// ValidateFormat checks that format is a known log format.
+func ValidateFormat(format string) error {
+	if format != FormatText && format != FormatJSON {
+		return fmt.Errorf("unknown log format %q, expected one of: %s, %s", format, FormatText, FormatJSON)
+	}
+	return nil
+}
+
+// SetLevel sets the minimum level that is written.
+func SetLevel(l slog.Level) {
+	level.Set(l)
+}
+
+// SetOutput makes the default logger write lines in format to w. Secrets are
+// always masked, before the lines are encoded.
+func SetOutput(w io.Writer, format string) error {
+	if err := ValidateFormat(format); err != nil {
+		return err
+	}
+	slog.SetDefault(slog.New(newHandler(w, format)))
+	return nil
+}
+
+func newHandler(w io.Writer, format string) slog.Handler {
+	opts := &slog.HandlerOptions{Level: level}
+	if format == FormatJSON {
+		return maskingHandler{h: slog.NewJSONHandler(w, opts)}
+	}
+	return maskingHandler{h: slog.NewTextHandler(w, opts)}
+}
+
+// Repo returns the default logger with the fields of a run over a repo ref.
+// Empty fields are left out.
+func Repo(runID, vcs, repo, ref string) *slog.Logger {
+	var args []any
+	for _, f := range [][2]string{{KeyRunID, runID}, {KeyVcs, vcs}, {KeyRepo, repo}, {KeyRef, ref}} {
+		if f[1] != "" {
+			args = append(args, f[0], f[1])
+		}
+	}
+	return slog.Default().With(args...)
+}
+
+// Err is the field of an error.
+func Err(err error) slog.Attr {
+	return slog.Any(KeyError, err)
+}
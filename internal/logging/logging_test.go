@@ -0,0 +1,68 @@
+package logging_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/jukie/atlantis-drift-detection/internal/logging"
+	"github.com/jukie/atlantis-drift-detection/internal/secret"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLevel(t *testing.T) {
+	l, err := logging.ParseLevel("WARN")
+	assert.NoError(t, err)
+	assert.Equal(t, slog.LevelWarn, l)
+
+	_, err = logging.ParseLevel("loud")
+	assert.ErrorContains(t, err, `unknown log level "loud"`)
+}
+
+func TestJSONOutput(t *testing.T) {
+	defer logging.SetOutput(os.Stderr, logging.FormatText)
+	defer logging.SetLevel(slog.LevelInfo)
+	secret.Register("hunter2")
+
+	var buf bytes.Buffer
+	assert.NoError(t, logging.SetOutput(&buf, logging.FormatJSON))
+	logging.SetLevel(slog.LevelWarn)
+	log := logging.Repo("abc123", "github", "owner/repo", "")
+	log.Info("Hidden below the level")
+	log.Warn("Planning failed", logging.KeyProject, "network", logging.Err(errors.New("token hunter2 rejected")))
+
+	var line map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &line))
+	assert.Equal(t, "WARN", line["level"])
+	assert.Equal(t, "Planning failed", line["msg"])
+	assert.Equal(t, "abc123", line["run_id"])
+	assert.Equal(t, "github", line["vcs"])
+	assert.Equal(t, "owner/repo", line["repo"])
+	assert.NotContains(t, line, "ref")
+	assert.Equal(t, "network", line["project"])
+	assert.Equal(t, "token **** rejected", line["error"])
+
+	assert.ErrorContains(t, logging.SetOutput(&buf, "xml"), `unknown log format "xml"`)
+}
+
+func TestMaskingBeforeEncoding(t *testing.T) {
+	defer logging.SetOutput(os.Stderr, logging.FormatText)
+	// Both encoders escape quotes and backslashes.
+	secret.Register(`pa"ss\word`)
+
+	for _, format := range []string{logging.FormatText, logging.FormatJSON} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			assert.NoError(t, logging.SetOutput(&buf, format))
+			log := logging.Repo("abc123", "", "", "").With("token", `pa"ss\word`)
+			log.Error(`Login with pa"ss\word failed`, logging.Err(errors.New(`rejected pa"ss\word`)),
+				slog.Group("request", "body", `secret=pa"ss\word`, "auth", struct{ Secret string }{`pa"ss\word`}))
+
+			assert.NotContains(t, buf.String(), "pa")
+			assert.Contains(t, buf.String(), "****")
+		})
+	}
+}
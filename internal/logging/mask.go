@@ -0,0 +1,66 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/jukie/atlantis-drift-detection/internal/secret"
+)
+
+// maskingHandler masks registered secrets in the message and attribute
+// values of a record before h encodes it, so that escaping by the encoder
+// cannot hide a secret from masking.
+type maskingHandler struct {
+	h slog.Handler
+}
+
+func (m maskingHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	return m.h.Enabled(ctx, l)
+}
+
+func (m maskingHandler) Handle(ctx context.Context, r slog.Record) error {
+	masked := slog.NewRecord(r.Time, r.Level, secret.Mask(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		masked.AddAttrs(maskAttr(a))
+		return true
+	})
+	return m.h.Handle(ctx, masked)
+}
+
+func (m maskingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return maskingHandler{h: m.h.WithAttrs(maskAttrs(attrs))}
+}
+
+func (m maskingHandler) WithGroup(name string) slog.Handler {
+	return maskingHandler{h: m.h.WithGroup(name)}
+}
+
+func maskAttrs(attrs []slog.Attr) []slog.Attr {
+	masked := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		masked[i] = maskAttr(a)
+	}
+	return masked
+}
+
+// maskAttr masks the value of a. Values other than strings and errors are
+// only replaced by their masked text when it contains a secret.
+func maskAttr(a slog.Attr) slog.Attr {
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, secret.Mask(v.String()))
+	case slog.KindGroup:
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(maskAttrs(v.Group())...)}
+	case slog.KindAny:
+		if err, ok := v.Any().(error); ok {
+			return slog.String(a.Key, secret.Mask(err.Error()))
+		}
+		s := fmt.Sprintf("%+v", v.Any())
+		if masked := secret.Mask(s); masked != s {
+			return slog.String(a.Key, masked)
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
@@ -0,0 +1,142 @@
+// Package notify sends drift alerts to webhooks such as Slack or Microsoft
+// Teams incoming webhooks.
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jukie/atlantis-drift-detection/internal/secret"
+)
+
+// Config lists the webhooks alerts are sent to.
+type Config struct {
+	Webhooks []WebhookCfg `yaml:"webhooks"`
+}
+
+type WebhookCfg struct {
+	Name string         `yaml:"name"`
+	URL  *secret.Source `yaml:"url"`
+	// Owners make the webhook a team channel: it only gets alerts about the
+	// projects owned by one of them, as written in CODEOWNERS. Webhooks
+	// without owners get every alert.
+	Owners []string `yaml:"owners"`
+}
+
+// Message is a drift alert for one repo.
+type Message struct {
+	RunID    string   `json:"runId"`
+	Vcs      string   `json:"vcs"`
+	Repo     string   `json:"repo"`
+	Ref      string   `json:"ref"`
+	Projects []string `json:"projects"`
+	PullURL  string   `json:"pullUrl,omitempty"`
+	// Owners maps projects to their CODEOWNERS owners, when known.
+	Owners map[string][]string `json:"owners,omitempty"`
+}
+
+// forOwners returns the part of the message about the projects owned by one
+// of owners, and false when there is none. Without owners it returns m.
+func (m Message) forOwners(owners []string) (Message, bool) {
+	if len(owners) == 0 {
+		return m, true
+	}
+	routed := m
+	routed.Projects, routed.Owners = nil, map[string][]string{}
+	for _, p := range m.Projects {
+		for _, o := range m.Owners[p] {
+			if containsFold(owners, o) {
+				routed.Projects = append(routed.Projects, p)
+				routed.Owners[p] = m.Owners[p]
+				break
+			}
+		}
+	}
+	return routed, len(routed.Projects) > 0
+}
+
+// containsFold reports whether list holds s, ignoring case like GitHub and
+// GitLab do for usernames and teams.
+func containsFold(list []string, s string) bool {
+	for _, l := range list {
+		if strings.EqualFold(l, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Text is a human readable summary of the message.
+func (m Message) Text() string {
+	text := fmt.Sprintf("Drift detected in %s@%s for: %s", m.Repo, m.Ref, strings.Join(m.Projects, ", "))
+	if m.PullURL != "" {
+		text += "\n" + m.PullURL
+	}
+	return text
+}
+
+type Notifier interface {
+	Notify(m Message) error
+}
+
+// Webhooks posts each message to every configured webhook.
+type Webhooks struct {
+	hooks  []WebhookCfg
+	client *http.Client
+}
+
+// New returns a notifier for the configured webhooks.
+func New(cfg Config) *Webhooks {
+	return &Webhooks{hooks: cfg.Webhooks, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// Notify sends m to every webhook, or the part of it about their projects to
+// team channels. Failing webhooks do not stop the others; their errors are
+// returned together.
+func (w *Webhooks) Notify(m Message) error {
+	var errs []string
+	for _, hook := range w.hooks {
+		routed, ok := m.forOwners(hook.Owners)
+		if !ok {
+			continue
+		}
+		// "text" is what Slack and Teams display; the other fields are for
+		// generic receivers.
+		payload, err := json.Marshal(struct {
+			Text string `json:"text"`
+			Message
+		}{routed.Text(), routed})
+		if err != nil {
+			return err
+		}
+		if err := w.post(hook, payload); err != nil {
+			errs = append(errs, fmt.Sprintf("webhook %s: %s", hook.Name, secret.Mask(err.Error())))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("sending notifications: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (w *Webhooks) post(hook WebhookCfg, payload []byte) error {
+	url, err := hook.URL.Get()
+	if err != nil {
+		return err
+	}
+	resp, err := w.client.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package notify_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jukie/atlantis-drift-detection/internal/notify"
+	"github.com/jukie/atlantis-drift-detection/internal/secret"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhooks(t *testing.T) {
+	var payload map[string]interface{}
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+	}))
+	defer ok.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no such channel", http.StatusNotFound)
+	}))
+	defer failing.Close()
+
+	n := notify.New(notify.Config{Webhooks: []notify.WebhookCfg{
+		{Name: "failing", URL: secret.Literal(failing.URL + "/hook-secret")},
+		{Name: "slack", URL: secret.Literal(ok.URL)},
+	}})
+	err := n.Notify(notify.Message{
+		RunID:    "abc123",
+		Vcs:      "GitHub",
+		Repo:     "owner/repo",
+		Ref:      "main",
+		Projects: []string{"network", "compute"},
+		PullURL:  "https://github.com/owner/repo/pull/1",
+	})
+
+	// The failing webhook does not stop the working one, and its secret URL is
+	// masked in the error.
+	assert.ErrorContains(t, err, "webhook failing: unexpected status 404 Not Found: no such channel")
+	assert.NotContains(t, err.Error(), "hook-secret")
+	assert.Equal(t, "Drift detected in owner/repo@main for: network, compute\nhttps://github.com/owner/repo/pull/1", payload["text"])
+	assert.Equal(t, "owner/repo", payload["repo"])
+	assert.Equal(t, []interface{}{"network", "compute"}, payload["projects"])
+}
+
+func TestWebhooksRouteByOwners(t *testing.T) {
+	received := map[string][]map[string]interface{}{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		received[r.URL.Path] = append(received[r.URL.Path], payload)
+	}))
+	defer server.Close()
+
+	n := notify.New(notify.Config{Webhooks: []notify.WebhookCfg{
+		{Name: "all", URL: secret.Literal(server.URL + "/all")},
+		{Name: "network", URL: secret.Literal(server.URL + "/network"), Owners: []string{"@org/network"}},
+		{Name: "dba", URL: secret.Literal(server.URL + "/dba"), Owners: []string{"@org/dba"}},
+	}})
+	err := n.Notify(notify.Message{
+		Repo:     "owner/repo",
+		Ref:      "main",
+		Projects: []string{"vpc", "dns", "compute"},
+		Owners: map[string][]string{
+			"vpc":     {"@org/Network"},
+			"dns":     {"@alice", "@org/network"},
+			"compute": {"@org/platform"},
+		},
+	})
+	assert.NoError(t, err)
+
+	assert.Len(t, received["/all"], 1)
+	assert.Equal(t, []interface{}{"vpc", "dns", "compute"}, received["/all"][0]["projects"])
+	// Team channels only hear about their projects, and not at all when they
+	// own none.
+	assert.Len(t, received["/network"], 1)
+	assert.Equal(t, "Drift detected in owner/repo@main for: vpc, dns", received["/network"][0]["text"])
+	assert.Equal(t, map[string]interface{}{"vpc": []interface{}{"@org/Network"}, "dns": []interface{}{"@alice", "@org/network"}}, received["/network"][0]["owners"])
+	assert.Empty(t, received["/dba"])
+}
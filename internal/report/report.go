@@ -0,0 +1,184 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jukie/atlantis-drift-detection/internal/drift"
+)
+
+const (
+	FormatText     = "text"
+	FormatJSON     = "json"
+	FormatMarkdown = "markdown"
+)
+
+// Formats lists the output formats accepted by Render.
+var Formats = []string{FormatText, FormatJSON, FormatMarkdown}
+
+// ValidateFormat returns an error for an unknown output format.
+func ValidateFormat(format string) error {
+	for _, f := range Formats {
+		if f == format {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown output format %q, expected one of: %s", format, strings.Join(Formats, ", "))
+}
+
+// Render writes the summary to w in the given format.
+func Render(w io.Writer, format string, s drift.Summary) error {
+	switch format {
+	case FormatText:
+		return renderText(w, s)
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+	case FormatMarkdown:
+		return renderMarkdown(w, s)
+	}
+	return ValidateFormat(format)
+}
+
+// Save stores the summary as JSON so that it can be rendered later.
+func Save(path string, s drift.Summary) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := Render(f, FormatJSON, s); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Load reads a summary stored by Save.
+func Load(path string) (drift.Summary, error) {
+	var s drift.Summary
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(b, &s); err != nil {
+		return s, fmt.Errorf("parsing stored result %s: %w", path, err)
+	}
+	return s, nil
+}
+
+func renderText(w io.Writer, s drift.Summary) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Run %s (%s)\n\n", s.RunID, s.StartedAt.Format("2006-01-02 15:04:05 MST"))
+	fmt.Fprintln(tw, "VCS\tREPO\tREF\tPROJECT\tSTATUS")
+	for _, r := range s.Results {
+		if r.Error != "" && len(r.Projects) == 0 {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t-\t%s\n", r.Vcs, r.Repo, r.Ref, drift.StatusFailed)
+		}
+		for _, p := range r.Projects {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.Vcs, r.Repo, r.Ref, projectName(p), status(p))
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, r := range s.Results {
+		if r.PullURL != "" {
+			fmt.Fprintf(w, "\n%s: drift PR %s\n", r.Repo, r.PullURL)
+		}
+		if p := r.PlannedPull; p != nil {
+			fmt.Fprintf(w, "\n%s: dry run, would create branch %s from %s, open PR %q and comment %q\n", r.Repo, p.Branch, p.Base, p.Title, p.Comment)
+		}
+		if r.Error != "" {
+			fmt.Fprintf(w, "\n%s: error: %s\n", r.Repo, r.Error)
+		}
+		for _, p := range r.Projects {
+			if a := p.Acknowledgement; a != nil {
+				fmt.Fprintf(w, "\n%s: %s acknowledged until %s: %s\n", r.Repo, projectName(p), a.Expires.Format("2006-01-02"), a.Reason)
+			}
+			if len(p.Ignored) > 0 {
+				fmt.Fprintf(w, "\n%s: %s ignored changes:\n", r.Repo, projectName(p))
+				for _, c := range p.Ignored {
+					fmt.Fprintf(w, "  %s %s\n", c.Action, c.Address)
+				}
+			}
+			if a := p.AutoApply; a != nil {
+				fmt.Fprintf(w, "\n%s: %s auto-apply %s\n", r.Repo, projectName(p), applyOutcome(*a))
+			}
+		}
+	}
+	return nil
+}
+
+func renderMarkdown(w io.Writer, s drift.Summary) error {
+	fmt.Fprintf(w, "# Drift report\n\nRun `%s` started %s.\n", s.RunID, s.StartedAt.Format("2006-01-02 15:04:05 MST"))
+	for _, r := range s.Results {
+		fmt.Fprintf(w, "\n## %s@%s (%s)\n\n", r.Repo, r.Ref, r.Vcs)
+		if r.Error != "" {
+			fmt.Fprintf(w, "**Error:** %s\n\n", r.Error)
+		}
+		if r.PullURL != "" {
+			fmt.Fprintf(w, "Drift PR: %s\n\n", r.PullURL)
+		}
+		if p := r.PlannedPull; p != nil {
+			fmt.Fprintf(w, "Dry run, no changes were made. Would have created branch `%s` from `%s`, opened PR **%s** and commented `%s`.\n\n", p.Branch, p.Base, p.Title, p.Comment)
+		}
+		if len(r.Projects) == 0 {
+			continue
+		}
+		fmt.Fprintln(w, "| Project | Dir | Status |")
+		fmt.Fprintln(w, "|---------|-----|--------|")
+		for _, p := range r.Projects {
+			fmt.Fprintf(w, "| %s | %s | %s |\n", projectName(p), p.Dir, status(p))
+		}
+		for _, p := range r.Projects {
+			if a := p.Acknowledgement; a != nil {
+				fmt.Fprintf(w, "\n- **%s** acknowledged until %s: %s\n", projectName(p), a.Expires.Format("2006-01-02"), a.Reason)
+			}
+			if len(p.Ignored) > 0 {
+				fmt.Fprintf(w, "\n- **%s** ignored changes:\n", projectName(p))
+				for _, c := range p.Ignored {
+					fmt.Fprintf(w, "  - %s `%s`\n", c.Action, c.Address)
+				}
+			}
+			if a := p.AutoApply; a != nil {
+				fmt.Fprintf(w, "\n- **%s** auto-apply %s\n", projectName(p), applyOutcome(*a))
+			}
+		}
+	}
+	return nil
+}
+
+// status describes the project status together with how it changed since the
+// previous run, when that is known.
+func status(p drift.ProjectResult) string {
+	switch {
+	case p.Suppressed && p.DriftingSince != nil:
+		return fmt.Sprintf("%s (%s since %s, suppressed)", p.Status, p.Change, p.DriftingSince.Format("2006-01-02"))
+	case p.Suppressed:
+		return fmt.Sprintf("%s (%s, suppressed)", p.Status, p.Change)
+	case p.Change == "":
+		return string(p.Status)
+	case p.DriftingSince != nil:
+		return fmt.Sprintf("%s (%s since %s)", p.Status, p.Change, p.DriftingSince.Format("2006-01-02"))
+	}
+	return fmt.Sprintf("%s (%s)", p.Status, p.Change)
+}
+
+func applyOutcome(a drift.ApplyOutcome) string {
+	if a.Reason != "" {
+		return fmt.Sprintf("%s: %s", a.Status, a.Reason)
+	}
+	return string(a.Status)
+}
+
+func projectName(p drift.ProjectResult) string {
+	if p.Name != "" {
+		return p.Name
+	}
+	return p.Dir
+}
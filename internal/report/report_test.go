@@ -0,0 +1,93 @@
+package report_test
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jukie/atlantis-drift-detection/internal/drift"
+	"github.com/jukie/atlantis-drift-detection/internal/report"
+	"github.com/stretchr/testify/assert"
+)
+
+func testSummary() drift.Summary {
+	started := time.Date(2023, 5, 1, 2, 0, 0, 0, time.UTC)
+	return drift.Summary{
+		RunID:      "abc123",
+		StartedAt:  started,
+		FinishedAt: started.Add(time.Minute),
+		Results: []drift.Result{{
+			Vcs:  "GitHub",
+			Repo: "owner/repo",
+			Ref:  "main",
+			Projects: []drift.ProjectResult{
+				{Name: "network", Dir: "network", Status: drift.StatusClean},
+				{Name: "compute", Dir: "compute", Status: drift.StatusDrifted},
+			},
+			PullURL: "https://github.com/owner/repo/pull/1",
+		}},
+	}
+}
+
+func TestRenderText(t *testing.T) {
+	var buf bytes.Buffer
+	err := report.Render(&buf, report.FormatText, testSummary())
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), "GitHub  owner/repo  main  compute  drifted")
+	assert.Contains(t, buf.String(), "owner/repo: drift PR https://github.com/owner/repo/pull/1")
+}
+
+func TestRenderMarkdown(t *testing.T) {
+	var buf bytes.Buffer
+	err := report.Render(&buf, report.FormatMarkdown, testSummary())
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), "## owner/repo@main (GitHub)")
+	assert.Contains(t, buf.String(), "| compute | compute | drifted |")
+}
+
+func TestRenderUnknownFormat(t *testing.T) {
+	var buf bytes.Buffer
+	err := report.Render(&buf, "yaml", testSummary())
+	assert.Error(t, err)
+}
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "result.json")
+	s := testSummary()
+
+	assert.NoError(t, report.Save(path, s))
+	loaded, err := report.Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, s, loaded)
+}
+
+func TestRenderDryRun(t *testing.T) {
+	s := testSummary()
+	s.Results[0].PullURL = ""
+	s.Results[0].DryRun = true
+	s.Results[0].PlannedPull = &drift.PullPlan{
+		Branch:  "atlantis-drift-abc123",
+		Base:    "main",
+		Title:   "Atlantis drift detector",
+		Comment: "atlantis plan -p compute",
+	}
+
+	var buf bytes.Buffer
+	err := report.Render(&buf, report.FormatText, s)
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), `owner/repo: dry run, would create branch atlantis-drift-abc123 from main, open PR "Atlantis drift detector" and comment "atlantis plan -p compute"`)
+}
+
+func TestRenderAutoApply(t *testing.T) {
+	s := testSummary()
+	s.Results[0].Projects[1].AutoApply = &drift.ApplyOutcome{Status: drift.ApplySkipped, Reason: "the plan destroys 1 resource(s)"}
+
+	var buf bytes.Buffer
+	assert.NoError(t, report.Render(&buf, report.FormatText, s))
+	assert.Contains(t, buf.String(), "owner/repo: compute auto-apply skipped: the plan destroys 1 resource(s)")
+
+	buf.Reset()
+	assert.NoError(t, report.Render(&buf, report.FormatMarkdown, s))
+	assert.Contains(t, buf.String(), "- **compute** auto-apply skipped: the plan destroys 1 resource(s)")
+}
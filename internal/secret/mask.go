@@ -0,0 +1,41 @@
+package secret
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const maskedValue = "****"
+
+var (
+	registryMu sync.RWMutex
+	// registry holds the secret values longest first, so that a secret
+	// containing another one is masked whole.
+	registry []string
+)
+
+// Register marks value as sensitive so that Mask hides it.
+func Register(value string) {
+	if value == "" {
+		return
+	}
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if slices.Contains(registry, value) {
+		return
+	}
+	i := sort.Search(len(registry), func(i int) bool { return len(registry[i]) < len(value) })
+	registry = slices.Insert(registry, i, value)
+}
+
+// Mask replaces every registered secret value in s.
+func Mask(s string) string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	for _, value := range registry {
+		s = strings.ReplaceAll(s, value, maskedValue)
+	}
+	return s
+}
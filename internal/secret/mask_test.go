@@ -0,0 +1,23 @@
+package secret_test
+
+import (
+	"testing"
+
+	"github.com/jukie/atlantis-drift-detection/internal/secret"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMask(t *testing.T) {
+	_, err := secret.Literal("s3cr3t-value").Get()
+	assert.NoError(t, err)
+
+	assert.Equal(t, "token=****", secret.Mask("token=s3cr3t-value"))
+	assert.Equal(t, "nothing to hide", secret.Mask("nothing to hide"))
+
+	// A secret containing another one is masked whole, whatever the order
+	// they were registered in.
+	secret.Register("inner")
+	secret.Register("outer-inner-outer")
+	secret.Register("inner-outer")
+	assert.Equal(t, "a ****, b ****, c ****", secret.Mask("a outer-inner-outer, b inner-outer, c inner"))
+}
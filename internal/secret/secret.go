@@ -0,0 +1,217 @@
+package secret
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// commandTTL is how long the output of a credential helper is reused before
+// the command is executed again.
+const commandTTL = 5 * time.Minute
+
+// commandTimeout bounds how long a credential helper may run.
+const commandTimeout = 30 * time.Second
+
+// Source is a credential that is resolved from a literal value, a file or the
+// stdout of an external command. Files are re-read whenever they change on
+// disk so that rotated secret mounts are picked up without a restart.
+type Source struct {
+	Value   string   `yaml:"value"`
+	File    string   `yaml:"file"`
+	Command []string `yaml:"command"`
+
+	// commandErr is why a command line given to FromCommand could not be
+	// split into arguments.
+	commandErr error
+
+	mu      sync.Mutex
+	cached  string
+	modTime time.Time
+	size    int64
+	fetched time.Time
+}
+
+// Literal returns a Source for a fixed value.
+func Literal(value string) *Source {
+	return &Source{Value: value}
+}
+
+// FromFile returns a Source backed by the file at path.
+func FromFile(path string) *Source {
+	return &Source{File: path}
+}
+
+// FromCommand returns a Source backed by the stdout of a command line. The
+// command is split into arguments like a shell does, honouring single and
+// double quotes and backslash escapes, and executed directly, without a shell.
+func FromCommand(command string) *Source {
+	args, err := splitCommand(command)
+	return &Source{Command: args, commandErr: err}
+}
+
+// splitCommand splits a command line into arguments with the quoting rules of
+// a POSIX shell. Variables and other expansions are not supported.
+func splitCommand(line string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, c := range line {
+		switch {
+		case escaped:
+			// Inside double quotes a backslash only escapes what the shell
+			// would otherwise interpret.
+			if quote == '"' && !strings.ContainsRune(`"\$`+"`", c) {
+				arg.WriteRune('\\')
+			}
+			arg.WriteRune(c)
+			escaped = false
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				arg.WriteRune(c)
+			}
+		case c == '\\':
+			escaped, inArg = true, true
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			} else {
+				arg.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote, inArg = c, true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(c)
+			inArg = true
+		}
+	}
+	if escaped || quote != 0 {
+		return nil, fmt.Errorf("unterminated quote or escape in command %q", line)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// UnmarshalYAML allows a Source to be written as a plain string, which is
+// treated as a literal value, or as a mapping with value, file or command.
+func (s *Source) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&s.Value)
+	}
+	var raw struct {
+		Value   string   `yaml:"value"`
+		File    string   `yaml:"file"`
+		Command []string `yaml:"command"`
+	}
+	if err := node.Decode(&raw); err != nil {
+		return err
+	}
+	s.Value, s.File, s.Command = raw.Value, raw.File, raw.Command
+	return nil
+}
+
+// IsSet reports whether the Source has anything to resolve.
+func (s *Source) IsSet() bool {
+	return s != nil && (s.Value != "" || s.File != "" || len(s.Command) > 0 || s.commandErr != nil)
+}
+
+// Validate checks that exactly one of value, file or command is set.
+func (s *Source) Validate() error {
+	n := 0
+	if s.commandErr != nil {
+		return s.commandErr
+	}
+	for _, set := range []bool{s.Value != "", s.File != "", len(s.Command) > 0} {
+		if set {
+			n++
+		}
+	}
+	if n != 1 {
+		return fmt.Errorf("exactly one of value, file or command must be set")
+	}
+	return nil
+}
+
+// Get resolves the secret. The result is registered for masking in logs.
+func (s *Source) Get() (string, error) {
+	if s == nil {
+		return "", nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var value string
+	var err error
+	switch {
+	case s.File != "":
+		value, err = s.readFile()
+	case s.commandErr != nil:
+		err = s.commandErr
+	case len(s.Command) > 0:
+		value, err = s.runCommand()
+	default:
+		value = s.Value
+	}
+	if err != nil {
+		return "", err
+	}
+	Register(value)
+	return value, nil
+}
+
+func (s *Source) readFile() (string, error) {
+	info, err := os.Stat(s.File)
+	if err != nil {
+		return "", fmt.Errorf("reading secret file: %w", err)
+	}
+	if !s.fetched.IsZero() && info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return s.cached, nil
+	}
+	b, err := os.ReadFile(s.File)
+	if err != nil {
+		return "", fmt.Errorf("reading secret file: %w", err)
+	}
+	s.cached = strings.TrimSpace(string(b))
+	s.modTime = info.ModTime()
+	s.size = info.Size()
+	s.fetched = time.Now()
+	return s.cached, nil
+}
+
+func (s *Source) runCommand() (string, error) {
+	if !s.fetched.IsZero() && time.Since(s.fetched) < commandTTL {
+		return s.cached, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.Command[0], s.Command[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("running credential helper %q: %v: %s", s.Command[0], err, strings.TrimSpace(stderr.String()))
+	}
+	s.cached = strings.TrimSpace(stdout.String())
+	s.fetched = time.Now()
+	return s.cached, nil
+}
@@ -0,0 +1,102 @@
+package secret_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jukie/atlantis-drift-detection/internal/secret"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+)
+
+func TestLiteral(t *testing.T) {
+	value, err := secret.Literal("abc").Get()
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", value)
+}
+
+func TestNilSource(t *testing.T) {
+	var s *secret.Source
+	value, err := s.Get()
+	assert.NoError(t, err)
+	assert.Empty(t, value)
+	assert.False(t, s.IsSet())
+}
+
+func TestFromFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	assert.NoError(t, os.WriteFile(path, []byte("first\n"), 0600))
+
+	s := secret.FromFile(path)
+	value, err := s.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, "first", value)
+
+	assert.NoError(t, os.WriteFile(path, []byte("second-token\n"), 0600))
+	future := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(path, future, future))
+
+	value, err = s.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, "second-token", value)
+}
+
+func TestFromFileMissing(t *testing.T) {
+	_, err := secret.FromFile("/path/to/nonexistent").Get()
+	assert.Error(t, err)
+}
+
+func TestFromCommand(t *testing.T) {
+	value, err := secret.FromCommand("echo from-helper").Get()
+	assert.NoError(t, err)
+	assert.Equal(t, "from-helper", value)
+
+	_, err = secret.FromCommand("false").Get()
+	assert.Error(t, err)
+}
+
+func TestFromCommandQuoting(t *testing.T) {
+	for line, want := range map[string][]string{
+		`vault kv get -field=token "secret/my path"`: {"vault", "kv", "get", "-field=token", "secret/my path"},
+		`printf '%s' 'it''s' ""`:                     {"printf", "%s", "its", ""},
+		`echo a\ b "c \"d\" \e" 'e\f'`:               {"echo", "a b", `c "d" \e`, `e\f`},
+	} {
+		assert.Equal(t, want, secret.FromCommand(line).Command, line)
+	}
+
+	value, err := secret.FromCommand(`printf '%s' "two words"`).Get()
+	assert.NoError(t, err)
+	assert.Equal(t, "two words", value)
+
+	s := secret.FromCommand(`vault kv get "secret/unterminated`)
+	assert.True(t, s.IsSet())
+	assert.ErrorContains(t, s.Validate(), "unterminated quote")
+	_, err = s.Get()
+	assert.ErrorContains(t, err, "unterminated quote")
+}
+
+func TestUnmarshalYAML(t *testing.T) {
+	var cfg struct {
+		Plain   *secret.Source `yaml:"plain"`
+		File    *secret.Source `yaml:"file"`
+		Command *secret.Source `yaml:"command"`
+	}
+	err := yaml.Unmarshal([]byte(`plain: abc
+file:
+  file: /run/secrets/token
+command:
+  command: ["vault", "read", "-field=token", "secret/atlantis"]
+`), &cfg)
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", cfg.Plain.Value)
+	assert.Equal(t, "/run/secrets/token", cfg.File.File)
+	assert.Equal(t, []string{"vault", "read", "-field=token", "secret/atlantis"}, cfg.Command.Command)
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, secret.Literal("abc").Validate())
+	assert.Error(t, (&secret.Source{}).Validate())
+	assert.Error(t, (&secret.Source{Value: "abc", File: "/tmp/token"}).Validate())
+}
@@ -0,0 +1,106 @@
+package state
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// FileStore keeps records as JSON lines in an append-only file and serves
+// queries from memory.
+type FileStore struct {
+	mu      sync.Mutex
+	f       *os.File
+	records []Record
+}
+
+// OpenFile opens or creates the state file at path.
+func OpenFile(path string) (*FileStore, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("opening state file: %w", err)
+	}
+	s := &FileStore{f: f}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var r Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("reading state file %s line %d: %w", path, line, err)
+		}
+		s.records = append(s.records, r)
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("reading state file %s: %w", path, err)
+	}
+	return s, nil
+}
+
+func (s *FileStore) Save(records []Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var buf []byte
+	for _, r := range records {
+		b, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, b...), '\n')
+	}
+	if _, err := s.f.Write(buf); err != nil {
+		return fmt.Errorf("writing state file: %w", err)
+	}
+	if err := s.f.Sync(); err != nil {
+		return fmt.Errorf("writing state file: %w", err)
+	}
+	s.records = append(s.records, records...)
+	return nil
+}
+
+func (s *FileStore) Latest(vcs, repo, ref string) ([]Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index := map[string]int{}
+	var latest []Record
+	for _, r := range s.records {
+		if r.Vcs != vcs || r.Repo != repo || r.Ref != ref {
+			continue
+		}
+		if i, ok := index[r.Project]; ok {
+			latest[i] = r
+			continue
+		}
+		index[r.Project] = len(latest)
+		latest = append(latest, r)
+	}
+	return latest, nil
+}
+
+func (s *FileStore) History(vcs, repo, ref, project string) ([]Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var history []Record
+	for _, r := range s.records {
+		if r.Vcs == vcs && r.Repo == repo && r.Ref == ref && r.Project == project {
+			history = append(history, r)
+		}
+	}
+	return history, nil
+}
+
+func (s *FileStore) Close() error {
+	return s.f.Close()
+}
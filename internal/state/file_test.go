@@ -0,0 +1,54 @@
+package state_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jukie/atlantis-drift-detection/internal/state"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.jsonl")
+	now := time.Date(2023, 5, 1, 2, 0, 0, 0, time.UTC)
+
+	store, err := state.OpenFile(path)
+	assert.NoError(t, err)
+	assert.NoError(t, store.Save([]state.Record{
+		{RunID: "run1", Time: now, Vcs: "GitHub", Repo: "owner/repo", Ref: "main", Project: "network", Status: state.StatusClean},
+		{RunID: "run1", Time: now, Vcs: "GitHub", Repo: "owner/repo", Ref: "main", Project: "compute", Status: state.StatusDrifted, PlanHash: "abc"},
+		{RunID: "run1", Time: now, Vcs: "GitHub", Repo: "owner/other", Ref: "main", Project: "network", Status: state.StatusDrifted},
+	}))
+	assert.NoError(t, store.Close())
+
+	// Reopening loads the records written before.
+	reopened, err := state.Open(state.Config{Backend: state.BackendFile, Path: path})
+	assert.NoError(t, err)
+	defer reopened.Close()
+	assert.NoError(t, reopened.Save([]state.Record{
+		{RunID: "run2", Time: now.Add(24 * time.Hour), Vcs: "GitHub", Repo: "owner/repo", Ref: "main", Project: "network", Status: state.StatusDrifted, PlanHash: "def"},
+	}))
+
+	latest, err := reopened.Latest("GitHub", "owner/repo", "main")
+	assert.NoError(t, err)
+	assert.Len(t, latest, 2)
+	assert.Equal(t, "run2", latest[0].RunID)
+	assert.Equal(t, "network", latest[0].Project)
+	assert.Equal(t, "compute", latest[1].Project)
+
+	history, err := reopened.History("GitHub", "owner/repo", "main", "network")
+	assert.NoError(t, err)
+	assert.Len(t, history, 2)
+	assert.Equal(t, state.StatusClean, history[0].Status)
+	assert.Equal(t, state.StatusDrifted, history[1].Status)
+}
+
+func TestFileStoreCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.jsonl")
+	assert.NoError(t, os.WriteFile(path, []byte("{\"runId\": \"run1\"}\nnot json\n"), 0644))
+
+	_, err := state.OpenFile(path)
+	assert.ErrorContains(t, err, "line 2")
+}
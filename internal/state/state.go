@@ -0,0 +1,127 @@
+// Package state stores the per-project results of every run so that later
+// runs can tell new drift from ongoing and resolved drift.
+package state
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	BackendFile = "file"
+
+	defaultPath = "drift-state.jsonl"
+)
+
+// Status values mirror the project statuses reported by the drift package.
+const (
+	StatusClean   = "clean"
+	StatusDrifted = "drifted"
+	StatusPending = "pending"
+)
+
+// Change describes how a project's drift compares to the previous run.
+type Change string
+
+const (
+	ChangeNew     Change = "new"
+	ChangeOngoing Change = "ongoing"
+	// ChangeChanged is ongoing drift whose change set differs from the
+	// previous run.
+	ChangeChanged  Change = "changed"
+	ChangeResolved Change = "resolved"
+)
+
+// Config selects and configures the state backend.
+type Config struct {
+	Backend string `yaml:"backend"`
+	Path    string `yaml:"path"`
+}
+
+// Record is the outcome of one project in one run.
+type Record struct {
+	RunID     string    `json:"runId"`
+	Time      time.Time `json:"time"`
+	Vcs       string    `json:"vcs"`
+	Repo      string    `json:"repo"`
+	Ref       string    `json:"ref"`
+	Project   string    `json:"project"`
+	Dir       string    `json:"dir,omitempty"`
+	Workspace string    `json:"workspace,omitempty"`
+	Status    string    `json:"status"`
+	PlanHash  string    `json:"planHash,omitempty"`
+	PullURL   string    `json:"pullUrl,omitempty"`
+}
+
+// Store persists records. Implementations must be safe for concurrent use.
+type Store interface {
+	// Save appends the records of a run.
+	Save(records []Record) error
+	// Latest returns the most recent record of every project of repo@ref.
+	Latest(vcs, repo, ref string) ([]Record, error)
+	// History returns every record of a project, oldest first.
+	History(vcs, repo, ref, project string) ([]Record, error)
+	Close() error
+}
+
+// Open returns the store selected by cfg.
+func Open(cfg Config) (Store, error) {
+	switch cfg.Backend {
+	case BackendFile, "":
+		path := cfg.Path
+		if path == "" {
+			path = defaultPath
+		}
+		return OpenFile(path)
+	}
+	return nil, fmt.Errorf("unknown state backend %q, expected %q", cfg.Backend, BackendFile)
+}
+
+// Validate checks the backend settings without opening the store.
+func (c Config) Validate() error {
+	if c.Backend != "" && c.Backend != BackendFile {
+		return fmt.Errorf("unknown state backend %q, expected %q", c.Backend, BackendFile)
+	}
+	return nil
+}
+
+// Compare classifies a project's current status and plan hash against its
+// previous record. Drifted and pending projects both have changes; moving
+// between the two counts as changed. It returns an empty Change when the
+// project was and is clean.
+func Compare(prev *Record, status, planHash string) Change {
+	hadChanges := prev != nil && hasChanges(prev.Status)
+	switch {
+	case hasChanges(status) && hadChanges && (prev.PlanHash != planHash || prev.Status != status):
+		return ChangeChanged
+	case hasChanges(status) && hadChanges:
+		return ChangeOngoing
+	case hasChanges(status):
+		return ChangeNew
+	case status == StatusClean && hadChanges:
+		return ChangeResolved
+	}
+	return ""
+}
+
+func hasChanges(status string) bool {
+	return status == StatusDrifted || status == StatusPending
+}
+
+// DriftingSince returns when the current streak of drifted or pending records
+// began, or the zero time when the latest record has no changes. history must
+// be ordered oldest first.
+func DriftingSince(history []Record) time.Time {
+	var since time.Time
+	for _, r := range history {
+		switch r.Status {
+		case StatusDrifted, StatusPending:
+			if since.IsZero() {
+				since = r.Time
+			}
+		case StatusClean:
+			since = time.Time{}
+		}
+	}
+	return since
+}
@@ -0,0 +1,49 @@
+package state_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jukie/atlantis-drift-detection/internal/state"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompare(t *testing.T) {
+	drifted := &state.Record{Status: state.StatusDrifted, PlanHash: "abc"}
+	clean := &state.Record{Status: state.StatusClean}
+
+	assert.Equal(t, state.ChangeNew, state.Compare(nil, state.StatusDrifted, "abc"))
+	assert.Equal(t, state.ChangeNew, state.Compare(clean, state.StatusDrifted, "abc"))
+	assert.Equal(t, state.ChangeOngoing, state.Compare(drifted, state.StatusDrifted, "abc"))
+	assert.Equal(t, state.ChangeChanged, state.Compare(drifted, state.StatusDrifted, "def"))
+	assert.Equal(t, state.ChangeResolved, state.Compare(drifted, state.StatusClean, "123"))
+	assert.Equal(t, state.Change(""), state.Compare(clean, state.StatusClean, "123"))
+	assert.Equal(t, state.Change(""), state.Compare(nil, state.StatusClean, "123"))
+
+	pending := &state.Record{Status: state.StatusPending, PlanHash: "abc"}
+	assert.Equal(t, state.ChangeNew, state.Compare(nil, state.StatusPending, "abc"))
+	assert.Equal(t, state.ChangeOngoing, state.Compare(pending, state.StatusPending, "abc"))
+	assert.Equal(t, state.ChangeChanged, state.Compare(pending, state.StatusDrifted, "abc"))
+	assert.Equal(t, state.ChangeResolved, state.Compare(pending, state.StatusClean, ""))
+}
+
+func TestDriftingSince(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2023, 5, d, 2, 0, 0, 0, time.UTC) }
+	history := []state.Record{
+		{Time: day(1), Status: state.StatusDrifted},
+		{Time: day(2), Status: state.StatusClean},
+		{Time: day(3), Status: state.StatusDrifted},
+		{Time: day(4), Status: state.StatusDrifted},
+	}
+	assert.Equal(t, day(3), state.DriftingSince(history))
+
+	history = append(history, state.Record{Time: day(5), Status: state.StatusClean})
+	assert.True(t, state.DriftingSince(history).IsZero())
+}
+
+func TestOpen(t *testing.T) {
+	_, err := state.Open(state.Config{Backend: "etcd"})
+	assert.Error(t, err)
+	assert.Error(t, state.Config{Backend: "etcd"}.Validate())
+	assert.NoError(t, state.Config{Backend: state.BackendFile}.Validate())
+}
@@ -0,0 +1,42 @@
+package vcs
+
+import (
+	"net/http"
+
+	"golang.org/x/oauth2"
+)
+
+// TokenSource supplies the API token for every request so that rotated
+// credentials are used without recreating the client.
+type TokenSource interface {
+	Get() (string, error)
+}
+
+type oauth2TokenSource struct {
+	src TokenSource
+}
+
+func (o oauth2TokenSource) Token() (*oauth2.Token, error) {
+	token, err := o.src.Get()
+	if err != nil {
+		return nil, err
+	}
+	return &oauth2.Token{AccessToken: token}, nil
+}
+
+// headerTransport sets a header to the current token on each request.
+type headerTransport struct {
+	header string
+	src    TokenSource
+	base   http.RoundTripper
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.src.Get()
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set(t.header, token)
+	return t.base.RoundTrip(req)
+}
@@ -1,20 +1,160 @@
 package vcs
 
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PullTitle is the title of the PRs opened for drifted projects.
+const PullTitle = "Atlantis drift detector"
+
+// Longest PR or MR descriptions accepted by GitHub and GitLab.
+const (
+	GithubMaxBody = 65536
+	GitlabMaxBody = 1000000
+)
+
+// driftBranchPrefix is prepended to the head commit of the tracked ref to name
+// the branch of a drift PR.
+const driftBranchPrefix = "atlantis-drift-"
+
 type Client interface {
 	GetFileContent(repo, path, ref string) (bool, []byte, error)
-	CreatePull(repo, sourceBranch string) (int, string, error)
+	// DriftBranch returns the name of the branch CreatePull would use for ref.
+	DriftBranch(repo, ref string) (string, error)
+	// CreatePull commits the marker to the drift branch of ref, which is
+	// created or reset from ref, and returns the open PR from it into ref with
+	// the body of pull cut to MaxBodyLength, opening one when there is none.
+	// The changes made before an error are reported along with it.
+	CreatePull(repo, ref string, pull PullRequest) (PullChanges, error)
 	CommentOnPull(repo string, pull int, driftedProjects []string) error
 	VcsType() string
+	// MaxBodyLength is the longest PR body the VCS accepts.
+	MaxBodyLength() int
+	// DriftBranches lists the branches named like DriftBranch names them.
+	DriftBranches(repo string) ([]Branch, error)
+	DeleteBranch(repo, branch string) error
+}
+
+// PR states listed in Branch.Pulls and returned by PullStater.
+const (
+	PullOpen   = "open"
+	PullClosed = "closed"
+	PullMerged = "merged"
+)
+
+// Branch is a drift branch together with the PRs opened from it.
+type Branch struct {
+	Name string
+	// Updated is the date of the head commit of the branch.
+	Updated time.Time
+	// Pulls holds the state of every PR opened from the branch.
+	Pulls []string
+}
+
+// PullRequest is the drift PR to open.
+type PullRequest struct {
+	Body string
+	// Marker is committed to the drift branch. Its zero value is the default
+	// marker.
+	Marker Marker
+	// MergeRequest holds the options of GitLab MRs. GitHub ignores them.
+	MergeRequest MergeRequestOptions
+}
+
+// PullChanges reports what CreatePull and ReusePull changed in the VCS.
+type PullChanges struct {
+	// Branch is the branch the PR is opened from.
+	Branch string
+	// BranchCreated is set when the branch did not exist before.
+	BranchCreated bool
+	// Commit is the SHA of the drift marker commit the branch points at.
+	Commit string
+	// Committed is set when Commit was created by the call rather than
+	// already on the branch.
+	Committed bool
+	// Number and URL identify the PR.
+	Number int
+	URL    string
+	// PullCreated is set when the PR was opened rather than reused.
+	PullCreated bool
+}
+
+// MergeRequestOptions configure the drift MRs opened on GitLab. They are set
+// when the MR is opened and left alone when it is reused.
+type MergeRequestOptions struct {
+	// Reviewers and Assignees are usernames.
+	Reviewers []string
+	Assignees []string
+	Labels    []string
+	// Milestone is the title of a project or group milestone.
+	Milestone string
+	// Draft marks the MR as a draft.
+	Draft bool
+	// RemoveSourceBranch and Squash default to true when nil.
+	RemoveSourceBranch *bool
+	Squash             *bool
+}
+
+// Commenter is implemented by clients that can post free-form comments on a
+// PR, such as the results of applying drift automatically.
+type Commenter interface {
+	Comment(repo string, pull int, body string) error
+}
+
+// ReviewRequester is implemented by clients that can request reviews of a PR
+// from code owners.
+type ReviewRequester interface {
+	// RequestReviewers adds owners, written like in a CODEOWNERS file, to the
+	// reviewers of the PR. Owners the VCS cannot request a review from, such
+	// as email addresses, are skipped.
+	RequestReviewers(repo string, pull int, owners []string) error
+}
+
+// PullReuser is implemented by clients that can keep a single drift PR open
+// across runs instead of opening one per drifted commit.
+type PullReuser interface {
+	// ReusePull resets branch to ref with the drift marker committed on top
+	// and returns the open PR from branch into ref with the body of pull,
+	// opening one when there is none. The changes made before an error are
+	// reported along with it.
+	ReusePull(repo, ref, branch string, pull PullRequest) (PullChanges, error)
+}
+
+// PullStater is implemented by clients that can look up whether a PR is
+// still open.
+type PullStater interface {
+	// PullState returns the state of the PR at url: PullOpen, PullClosed or
+	// PullMerged.
+	PullState(repo, url string) (string, error)
+}
+
+// pullNumber returns the number a PR or MR URL ends with.
+func pullNumber(url string) (int, error) {
+	n, err := strconv.Atoi(path.Base(url))
+	if err != nil {
+		return 0, fmt.Errorf("no PR number in URL %s", url)
+	}
+	return n, nil
 }
 
 func GetFileContent(client Client, repo, path, ref string) (bool, []byte, error) {
 	return client.GetFileContent(repo, path, ref)
 }
 
-func CreatePull(client Client, repo, sourceBranch, targetBranch string) (int, string, error) {
-	return client.CreatePull(repo, sourceBranch)
+func CreatePull(client Client, repo, sourceBranch, targetBranch, body string) (int, string, error) {
+	changes, err := client.CreatePull(repo, sourceBranch, PullRequest{Body: body})
+	return changes.Number, changes.URL, err
 }
 
 func CommentOnPull(client Client, repo string, pull int, driftedProjects []string) error {
 	return client.CommentOnPull(repo, pull, driftedProjects)
 }
+
+// PlanComment is the comment that makes Atlantis plan the drifted projects.
+func PlanComment(driftedProjects []string) string {
+	return fmt.Sprintf("atlantis plan -p %s", strings.Join(driftedProjects, "|"))
+}
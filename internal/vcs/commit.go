@@ -0,0 +1,51 @@
+package vcs
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultCommitMessage is the message of drift marker commits unless
+// configured otherwise.
+const DefaultCommitMessage = "Update date.txt"
+
+// CommitOptions configure the drift marker commits. Without an author the VCS
+// attributes commits to the owner of the token.
+type CommitOptions struct {
+	Message     string
+	AuthorName  string
+	AuthorEmail string
+	// Signer signs the commits. It requires AuthorName and AuthorEmail.
+	Signer Signer
+	// WebSigned requires GitLab to have signed the commits itself, with web
+	// commit signing.
+	WebSigned bool
+}
+
+func (o CommitOptions) message() string {
+	if o.Message == "" {
+		return DefaultCommitMessage
+	}
+	return o.Message
+}
+
+// Signer signs the raw git object of a commit.
+type Signer interface {
+	// Sign returns the armored signature of payload.
+	Sign(payload []byte) (string, error)
+}
+
+// commitPayload is the git object of a commit without its signature, which is
+// what git and the VCS verify the signature against. The committer is the
+// author.
+func commitPayload(tree string, parents []string, name, email string, date time.Time, message string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "tree %s\n", tree)
+	for _, p := range parents {
+		fmt.Fprintf(&b, "parent %s\n", p)
+	}
+	ident := fmt.Sprintf("%s <%s> %d %s", name, email, date.Unix(), date.Format("-0700"))
+	fmt.Fprintf(&b, "author %s\ncommitter %s\n\n%s", ident, ident, message)
+	return []byte(b.String())
+}
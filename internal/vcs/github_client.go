@@ -2,6 +2,7 @@ package vcs
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -15,14 +16,15 @@ import (
 type GithubClient struct {
 	Client *github.Client
 	Ctx    context.Context
+	// Commit configures the drift marker commits.
+	Commit CommitOptions
 }
 
-func NewGithubClient(hostname, token string) (*GithubClient, error) {
+func NewGithubClient(hostname string, token TokenSource) (*GithubClient, error) {
 	ctx := context.Background()
-	ts := oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: token},
-	)
-	tc := oauth2.NewClient(ctx, ts)
+	// oauth2.NewClient would cache the token forever, so the transport is built
+	// directly to fetch it from the source on every request.
+	tc := &http.Client{Transport: &oauth2.Transport{Source: oauth2TokenSource{token}}}
 
 	client := github.NewClient(tc)
 	if hostname != "" && hostname != "https://api.github.com/" {
@@ -45,7 +47,7 @@ func (g *GithubClient) GetFileContent(repoPath, path, ref string) (bool, []byte,
 		Ref: ref,
 	})
 	if err != nil {
-		if _, ok := err.(*github.ErrorResponse); ok && err.(*github.ErrorResponse).Response.StatusCode == http.StatusNotFound {
+		if isNotFound(err) {
 			return false, nil, nil
 		}
 		return false, nil, err
@@ -57,27 +59,34 @@ func (g *GithubClient) GetFileContent(repoPath, path, ref string) (bool, []byte,
 	return true, []byte(content), nil
 }
 
-func (g *GithubClient) CreatePull(repoPath, sourceBranch string) (int, string, error) {
+// CreatePull commits the drift marker to the drift branch of ref and returns
+// the open PR from it into ref with its body replaced, opening one when there
+// is none.
+func (g *GithubClient) CreatePull(repoPath, ref string, pull PullRequest) (PullChanges, error) {
 	owner, repo, err := splitRepoPath(repoPath)
 	if err != nil {
-		return 0, "", err
+		return PullChanges{}, err
 	}
 
-	head, _, err := g.Client.Repositories.GetCommit(g.Ctx, owner, repo, sourceBranch, nil)
+	driftBranch, err := g.DriftBranch(repoPath, ref)
 	if err != nil {
-		return 0, "", err
+		return PullChanges{}, err
 	}
-	targetBranch := "atlantis-drift-" + *head.SHA
 
-	err = g.CommitFileChange(repoPath, sourceBranch, targetBranch)
+	changes, err := g.CommitFileChange(repoPath, ref, driftBranch, pull.Marker)
 	if err != nil {
-		return 0, "", err
+		return changes, err
 	}
+	err = g.upsertPull(owner, repo, ref, pull.Body, &changes)
+	return changes, err
+}
+
+func (g *GithubClient) openPull(owner, repo, head, base, body string) (int, string, error) {
 	pr, _, err := g.Client.PullRequests.Create(g.Ctx, owner, repo, &github.NewPullRequest{
-		Title:               github.String("Atlantis drift detector"),
-		Head:                github.String(sourceBranch),
-		Base:                github.String(targetBranch),
-		Body:                github.String(""),
+		Title:               github.String(PullTitle),
+		Head:                github.String(head),
+		Base:                github.String(base),
+		Body:                github.String(truncate(body, GithubMaxBody)),
 		MaintainerCanModify: github.Bool(true),
 	})
 
@@ -85,34 +94,263 @@ func (g *GithubClient) CreatePull(repoPath, sourceBranch string) (int, string, e
 		return 0, "", err
 	}
 
-	return *pr.Number, *pr.HTMLURL, err
+	return pr.GetNumber(), pr.GetHTMLURL(), nil
 }
 
-func (g *GithubClient) CommitFileChange(repoPath, sourceBranch, targetBranch string) error {
+// ReusePull rebases branch onto ref and returns the open PR from branch into
+// ref with its body replaced, opening one when there is none.
+func (g *GithubClient) ReusePull(repoPath, ref, branch string, pull PullRequest) (PullChanges, error) {
 	owner, repo, err := splitRepoPath(repoPath)
 	if err != nil {
-		return err
+		return PullChanges{}, err
+	}
+	changes, err := g.rebaseBranch(owner, repo, ref, branch, pull.Marker)
+	if err != nil {
+		return changes, err
 	}
-	filePath := "drift-date.txt"
-	fileExists, _, err := g.GetFileContent(repoPath, filePath, sourceBranch)
+	err = g.upsertPull(owner, repo, ref, pull.Body, &changes)
+	return changes, err
+}
 
+// upsertPull replaces the body of the open PR from the branch of changes into
+// base, opening one when there is none, and records the PR in changes.
+func (g *GithubClient) upsertPull(owner, repo, base, body string, changes *PullChanges) error {
+	pulls, _, err := g.Client.PullRequests.List(g.Ctx, owner, repo, &github.PullRequestListOptions{
+		State: "open",
+		Head:  owner + ":" + changes.Branch,
+		Base:  base,
+	})
 	if err != nil {
 		return err
 	}
+	if len(pulls) == 0 {
+		changes.Number, changes.URL, err = g.openPull(owner, repo, changes.Branch, base, body)
+		changes.PullCreated = err == nil
+		return err
+	}
+	pr, _, err := g.Client.PullRequests.Edit(g.Ctx, owner, repo, pulls[0].GetNumber(), &github.PullRequest{
+		Body: github.String(truncate(body, GithubMaxBody)),
+	})
+	if err != nil {
+		return err
+	}
+	changes.Number, changes.URL = pr.GetNumber(), pr.GetHTMLURL()
+	return nil
+}
+
+// rebaseBranch points branch at a single drift marker commit on top of the
+// head commit of ref. A branch that already is such a commit is left alone,
+// so runs without new commits on ref add no commits. The branch never points
+// at ref itself, which GitHub would take as the PR being merged.
+func (g *GithubClient) rebaseBranch(owner, repo, ref, branch string, marker Marker) (PullChanges, error) {
+	changes := PullChanges{Branch: branch}
+	head, _, err := g.Client.Repositories.GetCommit(g.Ctx, owner, repo, ref, nil)
+	if err != nil {
+		return changes, err
+	}
+	current, _, err := g.Client.Git.GetRef(g.Ctx, owner, repo, "heads/"+branch)
+	exists := err == nil
+	if err != nil && !isNotFound(err) {
+		return changes, err
+	}
+	if exists {
+		tip, _, err := g.Client.Git.GetCommit(g.Ctx, owner, repo, current.GetObject().GetSHA())
+		if err != nil {
+			return changes, err
+		}
+		if len(tip.Parents) == 1 && tip.Parents[0].GetSHA() == head.GetSHA() {
+			changes.Commit = tip.GetSHA()
+			return changes, nil
+		}
+	}
 
-	content := []byte(time.Now().String())
-	opts := &github.RepositoryContentFileOptions{
-		Message: github.String("Update date.txt"),
-		Content: content,
-		Branch:  github.String(targetBranch),
+	sha, err := g.commitMarker(owner, repo, branch, head.GetSHA(), head.GetCommit().GetTree().GetSHA(), marker, exists)
+	if err != nil {
+		return changes, err
 	}
+	changes.Commit, changes.Committed, changes.BranchCreated = sha, true, !exists
+	return changes, nil
+}
 
-	if fileExists {
-		_, _, err = g.Client.Repositories.UpdateFile(g.Ctx, owner, repo, filePath, opts)
+// commitMarker commits marker on top of the commit parent with the tree
+// baseTree, and points branch at it, creating the branch unless it exists. It
+// returns the SHA of the commit.
+func (g *GithubClient) commitMarker(owner, repo, branch, parent, baseTree string, marker Marker, exists bool) (string, error) {
+	if g.Commit.WebSigned {
+		return "", errors.New("web signing of drift commits is only supported on GitLab")
+	}
+	marker = marker.withDefaults()
+	tree, _, err := g.Client.Git.CreateTree(g.Ctx, owner, repo, baseTree, []*github.TreeEntry{{
+		Path:    github.String(marker.Path),
+		Mode:    github.String("100644"),
+		Type:    github.String("blob"),
+		Content: github.String(marker.Content),
+	}})
+	if err != nil {
+		return "", err
+	}
+	commit := &github.Commit{
+		Message: github.String(g.Commit.message()),
+		Tree:    tree,
+		Parents: []*github.Commit{{SHA: github.String(parent)}},
+	}
+	if g.Commit.AuthorName != "" {
+		date := time.Now().UTC().Truncate(time.Second)
+		commit.Author = &github.CommitAuthor{
+			Name:  github.String(g.Commit.AuthorName),
+			Email: github.String(g.Commit.AuthorEmail),
+			Date:  &github.Timestamp{Time: date},
+		}
+		commit.Committer = commit.Author
+		if g.Commit.Signer != nil {
+			payload := commitPayload(tree.GetSHA(), []string{parent}, g.Commit.AuthorName, g.Commit.AuthorEmail, date, g.Commit.message())
+			signature, err := g.Commit.Signer.Sign(payload)
+			if err != nil {
+				return "", fmt.Errorf("signing the drift commit: %w", err)
+			}
+			commit.Verification = &github.SignatureVerification{Signature: github.String(signature)}
+		}
+	} else if g.Commit.Signer != nil {
+		return "", errors.New("signing drift commits requires an author name and email")
+	}
+	created, _, err := g.Client.Git.CreateCommit(g.Ctx, owner, repo, commit)
+	if err != nil {
+		return "", err
+	}
+	reference := &github.Reference{
+		Ref:    github.String("refs/heads/" + branch),
+		Object: &github.GitObject{SHA: created.SHA},
+	}
+	if exists {
+		_, _, err = g.Client.Git.UpdateRef(g.Ctx, owner, repo, reference, true)
 	} else {
-		_, _, err = g.Client.Repositories.CreateFile(g.Ctx, owner, repo, filePath, opts)
+		_, _, err = g.Client.Git.CreateRef(g.Ctx, owner, repo, reference)
+	}
+	if err != nil {
+		return "", err
+	}
+	return created.GetSHA(), nil
+}
+
+func (g *GithubClient) DriftBranch(repoPath, ref string) (string, error) {
+	owner, repo, err := splitRepoPath(repoPath)
+	if err != nil {
+		return "", err
+	}
+	head, _, err := g.Client.Repositories.GetCommit(g.Ctx, owner, repo, ref, nil)
+	if err != nil {
+		return "", err
+	}
+	return driftBranchPrefix + head.GetSHA(), nil
+}
+
+// CommitFileChange commits marker to driftBranch, which is created or reset
+// from ref.
+func (g *GithubClient) CommitFileChange(repoPath, ref, driftBranch string, marker Marker) (PullChanges, error) {
+	changes := PullChanges{Branch: driftBranch}
+	owner, repo, err := splitRepoPath(repoPath)
+	if err != nil {
+		return changes, err
+	}
+	head, _, err := g.Client.Repositories.GetCommit(g.Ctx, owner, repo, ref, nil)
+	if err != nil {
+		return changes, err
+	}
+	_, _, err = g.Client.Git.GetRef(g.Ctx, owner, repo, "heads/"+driftBranch)
+	exists := err == nil
+	if err != nil && !isNotFound(err) {
+		return changes, err
+	}
+	sha, err := g.commitMarker(owner, repo, driftBranch, head.GetSHA(), head.GetCommit().GetTree().GetSHA(), marker, exists)
+	if err != nil {
+		return changes, err
+	}
+	changes.Commit, changes.Committed, changes.BranchCreated = sha, true, !exists
+	return changes, nil
+}
+
+// DriftBranches lists the drift branches of the repo with the date of their
+// head commit and the state of their PRs.
+func (g *GithubClient) DriftBranches(repoPath string) ([]Branch, error) {
+	owner, repo, err := splitRepoPath(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	var refs []*github.Reference
+	refOpts := &github.ReferenceListOptions{
+		Ref:         "heads/" + driftBranchPrefix,
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		page, resp, err := g.Client.Git.ListMatchingRefs(g.Ctx, owner, repo, refOpts)
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, page...)
+		if resp.NextPage == 0 {
+			break
+		}
+		refOpts.Page = resp.NextPage
+	}
+	var branches []Branch
+	for _, ref := range refs {
+		b := Branch{Name: strings.TrimPrefix(ref.GetRef(), "refs/heads/")}
+		head, _, err := g.Client.Git.GetCommit(g.Ctx, owner, repo, ref.GetObject().GetSHA())
+		if err != nil {
+			return nil, err
+		}
+		b.Updated = head.GetCommitter().GetDate().Time
+		opts := &github.PullRequestListOptions{
+			State:       "all",
+			Head:        owner + ":" + b.Name,
+			ListOptions: github.ListOptions{PerPage: 100},
+		}
+		pulls, _, err := g.Client.PullRequests.List(g.Ctx, owner, repo, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range pulls {
+			b.Pulls = append(b.Pulls, githubPullState(p))
+		}
+		branches = append(branches, b)
 	}
+	return branches, nil
+}
 
+// PullState returns the state of the PR at url.
+func (g *GithubClient) PullState(repoPath, url string) (string, error) {
+	owner, repo, err := splitRepoPath(repoPath)
+	if err != nil {
+		return "", err
+	}
+	number, err := pullNumber(url)
+	if err != nil {
+		return "", err
+	}
+	p, _, err := g.Client.PullRequests.Get(g.Ctx, owner, repo, number)
+	if err != nil {
+		return "", err
+	}
+	return githubPullState(p), nil
+}
+
+func githubPullState(p *github.PullRequest) string {
+	switch {
+	case p.MergedAt != nil:
+		return PullMerged
+	case p.GetState() == "open":
+		return PullOpen
+	default:
+		return PullClosed
+	}
+}
+
+func (g *GithubClient) DeleteBranch(repoPath, branch string) error {
+	owner, repo, err := splitRepoPath(repoPath)
+	if err != nil {
+		return err
+	}
+	_, err = g.Client.Git.DeleteRef(g.Ctx, owner, repo, "heads/"+branch)
 	return err
 }
 
@@ -120,19 +358,176 @@ func (g *GithubClient) VcsType() string {
 	return "GitHub"
 }
 
+func (g *GithubClient) MaxBodyLength() int {
+	return GithubMaxBody
+}
+
 func (g *GithubClient) CommentOnPull(repoPath string, pull int, driftedProjects []string) error {
+	return g.Comment(repoPath, pull, PlanComment(driftedProjects))
+}
+
+func (g *GithubClient) Comment(repoPath string, pull int, body string) error {
 	owner, repo, err := splitRepoPath(repoPath)
 	if err != nil {
 		return err
 	}
-	projectRegexp := strings.Join(driftedProjects, "|")
-	commentBody := fmt.Sprintf("atlantis plan -p %s", projectRegexp)
 	_, _, err = g.Client.Issues.CreateComment(g.Ctx, owner, repo, pull, &github.IssueComment{
-		Body: github.String(commentBody),
+		Body: github.String(body),
 	})
 	return err
 }
 
+// RequestReviewers requests reviews from the @user and @org/team owners.
+// Teams are requested by their slug, whatever their organization.
+func (g *GithubClient) RequestReviewers(repoPath string, pull int, owners []string) error {
+	owner, repo, err := splitRepoPath(repoPath)
+	if err != nil {
+		return err
+	}
+	var req github.ReviewersRequest
+	for _, o := range owners {
+		name, ok := strings.CutPrefix(o, "@")
+		if !ok {
+			continue
+		}
+		if _, team, ok := strings.Cut(name, "/"); ok {
+			req.TeamReviewers = append(req.TeamReviewers, team)
+		} else {
+			req.Reviewers = append(req.Reviewers, name)
+		}
+	}
+	if len(req.Reviewers) == 0 && len(req.TeamReviewers) == 0 {
+		return nil
+	}
+	_, _, err = g.Client.PullRequests.RequestReviewers(g.Ctx, owner, repo, pull, req)
+	return err
+}
+
+// Limits of the GitHub API on check run summaries and commit status
+// descriptions.
+const (
+	maxCheckSummary       = 65535
+	maxGithubStatusLength = 140
+)
+
+// ReportStatus creates a completed check run on the head commit of ref, with
+// an annotation on a Terraform file in the directory of every project that is
+// not clean. Check runs can only be created by GitHub Apps, so other tokens
+// fall back to a commit status.
+func (g *GithubClient) ReportStatus(repoPath, ref string, status CommitStatus) error {
+	owner, repo, err := splitRepoPath(repoPath)
+	if err != nil {
+		return err
+	}
+	head, _, err := g.Client.Repositories.GetCommit(g.Ctx, owner, repo, ref, nil)
+	if err != nil {
+		return err
+	}
+
+	conclusion := "success"
+	if status.State != StateClean {
+		conclusion = "failure"
+	}
+	var annotations []*github.CheckRunAnnotation
+	for _, p := range status.Projects {
+		if p.State == StateClean {
+			continue
+		}
+		if len(annotations) == maxAnnotations {
+			break
+		}
+		path, err := g.annotationPath(owner, repo, head.GetSHA(), p.Dir)
+		if err != nil {
+			return err
+		}
+		if path == "" {
+			continue
+		}
+		level := "warning"
+		if p.State == StateFailed {
+			level = "failure"
+		}
+		annotations = append(annotations, &github.CheckRunAnnotation{
+			Path:            github.String(path),
+			StartLine:       github.Int(1),
+			EndLine:         github.Int(1),
+			AnnotationLevel: github.String(level),
+			Title:           github.String(p.Name),
+			Message:         github.String(p.Message),
+		})
+	}
+	opts := github.CreateCheckRunOptions{
+		Name:        status.Context,
+		HeadSHA:     head.GetSHA(),
+		Status:      github.String("completed"),
+		Conclusion:  github.String(conclusion),
+		CompletedAt: &github.Timestamp{Time: time.Now()},
+		Output: &github.CheckRunOutput{
+			Title:       github.String(status.Title),
+			Summary:     github.String(truncate(status.Summary, maxCheckSummary)),
+			Annotations: annotations,
+		},
+	}
+	if status.URL != "" {
+		opts.DetailsURL = github.String(status.URL)
+	}
+	_, _, err = g.Client.Checks.CreateCheckRun(g.Ctx, owner, repo, opts)
+	if !hasStatus(err, http.StatusForbidden) {
+		return err
+	}
+
+	state := "success"
+	switch status.State {
+	case StateDrifted:
+		state = "failure"
+	case StateFailed:
+		state = "error"
+	}
+	repoStatus := &github.RepoStatus{
+		State:       github.String(state),
+		Description: github.String(truncate(status.Title, maxGithubStatusLength)),
+		Context:     github.String(status.Context),
+	}
+	if status.URL != "" {
+		repoStatus.TargetURL = github.String(status.URL)
+	}
+	_, _, err = g.Client.Repositories.CreateStatus(g.Ctx, owner, repo, head.GetSHA(), repoStatus)
+	return err
+}
+
+// annotationPath returns the first Terraform file in dir at sha, since GitHub
+// only annotates files. It is empty when dir holds none.
+func (g *GithubClient) annotationPath(owner, repo, sha, dir string) (string, error) {
+	if dir == "" {
+		return "", nil
+	}
+	if dir == "." {
+		dir = ""
+	}
+	_, entries, _, err := g.Client.Repositories.GetContents(g.Ctx, owner, repo, dir, &github.RepositoryContentGetOptions{Ref: sha})
+	if isNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	for _, e := range entries {
+		if e.GetType() == "file" && strings.HasSuffix(e.GetName(), ".tf") {
+			return e.GetPath(), nil
+		}
+	}
+	return "", nil
+}
+
+func isNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+func hasStatus(err error, code int) bool {
+	var errResp *github.ErrorResponse
+	return errors.As(err, &errResp) && errResp.Response != nil && errResp.Response.StatusCode == code
+}
+
 func splitRepoPath(input string) (string, string, error) {
 	parts := strings.SplitN(input, "/", 2)
 	if len(parts) < 2 {
@@ -1,3 +1,377 @@
 package vcs_test
 
-// TODO
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jukie/atlantis-drift-detection/internal/secret"
+	"github.com/jukie/atlantis-drift-detection/internal/vcs"
+	"github.com/jukie/atlantis-drift-detection/internal/vcstest"
+	"github.com/stretchr/testify/assert"
+)
+
+func newGithub(t *testing.T) (*vcstest.GithubServer, *vcs.GithubClient) {
+	server := vcstest.NewGithubServer()
+	t.Cleanup(server.Close)
+	server.Token = "test-token"
+	server.AddRepo("owner/repo", "main", map[string]string{"atlantis.yaml": "version: 3\n"})
+	client, err := vcs.NewGithubClient(server.URL, secret.Literal("test-token"))
+	assert.NoError(t, err)
+	return server, client
+}
+
+func TestGithubGetFileContent(t *testing.T) {
+	server, client := newGithub(t)
+
+	exists, content, err := client.GetFileContent("owner/repo", "atlantis.yaml", "main")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, "version: 3\n", string(content))
+
+	// A missing file, ref or repo is reported as not existing.
+	for _, args := range [][]string{{"owner/repo", "missing.yaml", "main"}, {"owner/repo", "atlantis.yaml", "missing"}, {"owner/missing", "atlantis.yaml", "main"}} {
+		exists, _, err = client.GetFileContent(args[0], args[1], args[2])
+		assert.NoError(t, err)
+		assert.False(t, exists)
+	}
+
+	server.Token = "other-token"
+	_, _, err = client.GetFileContent("owner/repo", "atlantis.yaml", "main")
+	assert.ErrorContains(t, err, "401 Bad credentials")
+
+	_, _, err = client.GetFileContent("no-owner", "atlantis.yaml", "main")
+	assert.ErrorContains(t, err, "couldn't split owner and repo")
+}
+
+func TestGithubCreatePull(t *testing.T) {
+	server, client := newGithub(t)
+	repo := server.Repo("owner/repo")
+	base := repo.Head("main")
+
+	branch, err := client.DriftBranch("owner/repo", "main")
+	assert.NoError(t, err)
+	assert.Equal(t, "atlantis-drift-"+base.SHA, branch)
+
+	changes, err := client.CreatePull("owner/repo", "main", vcs.PullRequest{Body: "Drift body"})
+	assert.NoError(t, err)
+	url := server.URL + "owner/repo/pull/1"
+	head := repo.Head(branch)
+	assert.Equal(t, vcs.PullChanges{Branch: branch, BranchCreated: true, Commit: head.SHA, Committed: true, Number: 1, URL: url, PullCreated: true}, changes)
+
+	// The marker is committed to the drift branch only, on top of main.
+	assert.Equal(t, base, repo.Head("main"))
+	assert.Equal(t, base.SHA, head.Parent)
+	assert.Equal(t, "Update date.txt", head.Message)
+	_, ok := head.Files["drift-date.txt"]
+	assert.True(t, ok)
+
+	pull := repo.Pulls[0]
+	assert.Equal(t, vcs.PullTitle, pull.Title)
+	assert.Equal(t, "Drift body", pull.Body)
+	assert.Equal(t, branch, pull.Head)
+	assert.Equal(t, "main", pull.Base)
+	assert.Equal(t, true, pull.Options["maintainer_can_modify"])
+
+	// A second run on the same commit resets the drift branch to main and
+	// reuses the PR.
+	changes, err = client.CreatePull("owner/repo", "main", vcs.PullRequest{Body: "New drift body"})
+	assert.NoError(t, err)
+	assert.Equal(t, vcs.PullChanges{Branch: branch, Commit: repo.Head(branch).SHA, Committed: true, Number: 1, URL: url}, changes)
+	assert.Equal(t, base.SHA, repo.Head(branch).Parent)
+	assert.Len(t, repo.Pulls, 1)
+	assert.Equal(t, "New drift body", repo.Pulls[0].Body)
+
+	_, err = client.CreatePull("owner/repo", "missing", vcs.PullRequest{Body: "Drift body"})
+	assert.ErrorContains(t, err, "No commit found for SHA: missing")
+}
+
+func TestGithubCommentOnPull(t *testing.T) {
+	server, client := newGithub(t)
+	changes, err := client.CreatePull("owner/repo", "main", vcs.PullRequest{Body: "Drift body"})
+	assert.NoError(t, err)
+
+	assert.NoError(t, client.CommentOnPull("owner/repo", changes.Number, []string{"network", "compute"}))
+	assert.NoError(t, client.Comment("owner/repo", changes.Number, "Drift auto-apply results"))
+	assert.Equal(t, []string{"atlantis plan -p network|compute", "Drift auto-apply results"}, server.Repo("owner/repo").Pulls[0].Comments)
+
+	err = client.CommentOnPull("owner/repo", 42, []string{"network"})
+	assert.ErrorContains(t, err, "404")
+}
+
+func TestGithubPullState(t *testing.T) {
+	server, client := newGithub(t)
+	changes, err := client.CreatePull("owner/repo", "main", vcs.PullRequest{Body: "Drift body"})
+	assert.NoError(t, err)
+
+	pull := server.Repo("owner/repo").Pulls[0]
+	for _, state := range []string{vcs.PullOpen, vcs.PullClosed, vcs.PullMerged} {
+		pull.State = state
+		got, err := client.PullState("owner/repo", changes.URL)
+		assert.NoError(t, err)
+		assert.Equal(t, state, got)
+	}
+
+	_, err = client.PullState("owner/repo", server.URL+"owner/repo/pull/42")
+	assert.ErrorContains(t, err, "404")
+	_, err = client.PullState("owner/repo", server.URL+"owner/repo/pulls")
+	assert.ErrorContains(t, err, "no PR number in URL")
+}
+
+func testCommitStatus() vcs.CommitStatus {
+	return vcs.CommitStatus{
+		Context: "atlantis/drift",
+		State:   vcs.StateDrifted,
+		Title:   "1 of 2 projects drifted",
+		Summary: "| Project | Status |",
+		URL:     "https://example.com/pull/1",
+		Projects: []vcs.ProjectStatus{
+			{Name: "network", Dir: "network", State: vcs.StateClean},
+			{Name: "compute", Dir: "envs/prod", State: vcs.StateDrifted, Message: "1 resource change"},
+		},
+	}
+}
+
+func TestGithubRequestReviewers(t *testing.T) {
+	server, client := newGithub(t)
+	changes, err := client.CreatePull("owner/repo", "main", vcs.PullRequest{Body: "Drift body"})
+	assert.NoError(t, err)
+
+	var _ vcs.ReviewRequester = client
+	err = client.RequestReviewers("owner/repo", changes.Number, []string{"@alice", "@owner/platform", "ops@example.com"})
+	assert.NoError(t, err)
+	pull := server.Repo("owner/repo").Pulls[0]
+	assert.Equal(t, []string{"alice"}, pull.Reviewers)
+	assert.Equal(t, []string{"platform"}, pull.TeamReviewers)
+
+	// Owners that cannot be requested make no request at all.
+	assert.NoError(t, client.RequestReviewers("owner/repo", 99, []string{"ops@example.com"}))
+	assert.Error(t, client.RequestReviewers("owner/repo", 99, []string{"@alice"}))
+}
+
+func TestGithubReportStatus(t *testing.T) {
+	server, client := newGithub(t)
+	head := server.Push("owner/repo", "main", "Add projects", map[string]string{
+		"backend.tf":             "terraform {}\n",
+		"envs/prod/README.md":    "# Production\n",
+		"envs/prod/main.tf":      "terraform {}\n",
+		"envs/prod/variables.tf": "variable \"region\" {}\n",
+		"envs/prod/modules/a.tf": "terraform {}\n",
+		"docs/README.md":         "# Docs\n",
+	}).SHA
+
+	assert.NoError(t, client.ReportStatus("owner/repo", "main", testCommitStatus()))
+	statuses := server.Repo("owner/repo").Statuses
+	assert.Len(t, statuses, 1)
+	check := statuses[0]
+	assert.True(t, check.CheckRun)
+	assert.Equal(t, head, check.SHA)
+	assert.Equal(t, "atlantis/drift", check.Context)
+	assert.Equal(t, "failure", check.State)
+	assert.Equal(t, "https://example.com/pull/1", check.TargetURL)
+	output := check.Options["output"].(map[string]interface{})
+	assert.Equal(t, "1 of 2 projects drifted", output["title"])
+	assert.Equal(t, []interface{}{map[string]interface{}{
+		"path":             "envs/prod/main.tf",
+		"start_line":       float64(1),
+		"end_line":         float64(1),
+		"annotation_level": "warning",
+		"title":            "compute",
+		"message":          "1 resource change",
+	}}, output["annotations"])
+
+	// Annotations point at a Terraform file of the project's directory, and
+	// projects without one are only listed in the summary.
+	status := testCommitStatus()
+	status.Projects = []vcs.ProjectStatus{
+		{Name: "root", Dir: ".", State: vcs.StateDrifted},
+		{Name: "docs", Dir: "docs", State: vcs.StateFailed},
+		{Name: "missing", Dir: "missing", State: vcs.StateFailed},
+		{Name: "plans", State: vcs.StateDrifted},
+	}
+	assert.NoError(t, client.ReportStatus("owner/repo", "main", status))
+	output = server.Repo("owner/repo").Statuses[1].Options["output"].(map[string]interface{})
+	annotations := output["annotations"].([]interface{})
+	assert.Len(t, annotations, 1)
+	assert.Equal(t, "backend.tf", annotations[0].(map[string]interface{})["path"])
+}
+
+func TestGithubReportStatusWithoutChecks(t *testing.T) {
+	server, client := newGithub(t)
+	server.DisableChecks = true
+
+	status := testCommitStatus()
+	status.Title = strings.Repeat("x", 200)
+	assert.NoError(t, client.ReportStatus("owner/repo", "main", status))
+	statuses := server.Repo("owner/repo").Statuses
+	assert.Len(t, statuses, 1)
+	assert.False(t, statuses[0].CheckRun)
+	assert.Equal(t, "failure", statuses[0].State)
+	assert.Equal(t, "atlantis/drift", statuses[0].Context)
+	assert.Len(t, statuses[0].Description, 140)
+	assert.True(t, strings.HasSuffix(statuses[0].Description, "..."))
+
+	err := client.ReportStatus("owner/repo", "missing", status)
+	assert.ErrorContains(t, err, "No commit found for SHA: missing")
+}
+
+func TestGithubReusePull(t *testing.T) {
+	server, client := newGithub(t)
+	repo := server.Repo("owner/repo")
+
+	changes, err := client.ReusePull("owner/repo", "main", "atlantis-drift", vcs.PullRequest{Body: "first"})
+	assert.NoError(t, err)
+	url := server.URL + "owner/repo/pull/1"
+	tip := repo.Head("atlantis-drift")
+	assert.Equal(t, vcs.PullChanges{Branch: "atlantis-drift", BranchCreated: true, Commit: tip.SHA, Committed: true, Number: 1, URL: url, PullCreated: true}, changes)
+	assert.Equal(t, repo.Head("main").SHA, tip.Parent)
+	assert.Equal(t, "version: 3\n", tip.Files["atlantis.yaml"])
+	_, ok := tip.Files["drift-date.txt"]
+	assert.True(t, ok)
+
+	// Without new commits on main the branch and PR are reused as they are.
+	changes, err = client.ReusePull("owner/repo", "main", "atlantis-drift", vcs.PullRequest{Body: "second"})
+	assert.NoError(t, err)
+	assert.Equal(t, vcs.PullChanges{Branch: "atlantis-drift", Commit: tip.SHA, Number: 1, URL: url}, changes)
+	assert.Equal(t, tip, repo.Head("atlantis-drift"))
+	assert.Equal(t, "second", repo.Pulls[0].Body)
+
+	// New commits on main rebase the branch onto them.
+	pushed := server.Push("owner/repo", "main", "Add network", map[string]string{"network/main.tf": "terraform {}\n"})
+	changes, err = client.ReusePull("owner/repo", "main", "atlantis-drift", vcs.PullRequest{Body: "third"})
+	assert.NoError(t, err)
+	tip = repo.Head("atlantis-drift")
+	assert.Equal(t, vcs.PullChanges{Branch: "atlantis-drift", Commit: tip.SHA, Committed: true, Number: 1, URL: url}, changes)
+	assert.Equal(t, pushed.SHA, tip.Parent)
+	assert.Equal(t, "terraform {}\n", tip.Files["network/main.tf"])
+	assert.Len(t, repo.Pulls, 1)
+
+	// A closed PR is replaced by a new one.
+	repo.Pulls[0].State = vcstest.PullClosed
+	changes, err = client.ReusePull("owner/repo", "main", "atlantis-drift", vcs.PullRequest{Body: "fourth"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, changes.Number)
+	assert.True(t, changes.PullCreated)
+	assert.Equal(t, "fourth", repo.Pulls[1].Body)
+}
+
+func TestGithubDriftBranches(t *testing.T) {
+	server, client := newGithub(t)
+	repo := server.Repo("owner/repo")
+
+	_, err := client.CreatePull("owner/repo", "main", vcs.PullRequest{Body: "Drift body"})
+	assert.NoError(t, err)
+	first, err := client.DriftBranch("owner/repo", "main")
+	assert.NoError(t, err)
+	repo.Pulls[0].State = vcstest.PullMerged
+	server.Push("owner/repo", "main", "Add network", map[string]string{"network/main.tf": "terraform {}\n"})
+	_, err = client.CreatePull("owner/repo", "main", vcs.PullRequest{Body: "Drift body"})
+	assert.NoError(t, err)
+	second, err := client.DriftBranch("owner/repo", "main")
+	assert.NoError(t, err)
+	// The long-lived branch is not one of them.
+	_, err = client.ReusePull("owner/repo", "main", "atlantis-drift", vcs.PullRequest{Body: "Drift body"})
+	assert.NoError(t, err)
+
+	updated := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+	repo.Head(first).Date = updated
+	branches, err := client.DriftBranches("owner/repo")
+	assert.NoError(t, err)
+	assert.Len(t, branches, 2)
+	for _, b := range branches {
+		switch b.Name {
+		case first:
+			assert.Equal(t, updated, b.Updated.UTC())
+			assert.Equal(t, []string{vcs.PullMerged}, b.Pulls)
+		case second:
+			assert.WithinDuration(t, time.Now(), b.Updated, time.Minute)
+			assert.Equal(t, []string{vcs.PullOpen}, b.Pulls)
+		default:
+			t.Errorf("unexpected branch %s", b.Name)
+		}
+	}
+
+	assert.NoError(t, client.DeleteBranch("owner/repo", first))
+	assert.Nil(t, repo.Head(first))
+	assert.NotNil(t, repo.Head(second))
+	assert.Error(t, client.DeleteBranch("owner/repo", first))
+
+	// Branches are listed across pages.
+	for i := 0; i < 150; i++ {
+		repo.Branches[fmt.Sprintf("atlantis-drift-%03d", i)] = repo.Branches["main"]
+	}
+	branches, err = client.DriftBranches("owner/repo")
+	assert.NoError(t, err)
+	assert.Len(t, branches, 151)
+}
+
+func TestGithubCommitOptions(t *testing.T) {
+	server, client := newGithub(t)
+	repo := server.Repo("owner/repo")
+	client.Commit = vcs.CommitOptions{Message: "chore: drift marker", AuthorName: "Drift Bot", AuthorEmail: "drift@example.com"}
+
+	_, err := client.CreatePull("owner/repo", "main", vcs.PullRequest{Body: "Drift body"})
+	assert.NoError(t, err)
+	branch, err := client.DriftBranch("owner/repo", "main")
+	assert.NoError(t, err)
+	head := repo.Head(branch)
+	assert.Equal(t, "chore: drift marker", head.Message)
+	assert.Equal(t, "Drift Bot", head.AuthorName)
+	assert.Equal(t, "drift@example.com", head.AuthorEmail)
+	assert.Empty(t, head.Signature)
+
+	// Signing requires an author.
+	_, key := gpgKey(t, "")
+	client.Commit.Signer, err = vcs.NewSigner(vcs.SignGPG, key, "")
+	assert.NoError(t, err)
+	client.Commit.AuthorName = ""
+	_, err = client.ReusePull("owner/repo", "main", "atlantis-drift", vcs.PullRequest{Body: "Drift body"})
+	assert.EqualError(t, err, "signing drift commits requires an author name and email")
+}
+
+func TestGithubSignedCommits(t *testing.T) {
+	server, client := newGithub(t)
+	repo := server.Repo("owner/repo")
+	entity, key := gpgKey(t, "")
+	signer, err := vcs.NewSigner(vcs.SignGPG, key, "")
+	assert.NoError(t, err)
+	client.Commit = vcs.CommitOptions{AuthorName: "Drift Bot", AuthorEmail: "drift@example.com", Signer: signer}
+
+	_, err = client.CreatePull("owner/repo", "main", vcs.PullRequest{Body: "Drift body"})
+	assert.NoError(t, err)
+	branch, err := client.DriftBranch("owner/repo", "main")
+	assert.NoError(t, err)
+	head := repo.Head(branch)
+	assert.Equal(t, vcs.DefaultCommitMessage, head.Message)
+	assert.Contains(t, head.Payload, "\nauthor Drift Bot <drift@example.com> ")
+	verifyGPG(t, entity, head.Payload, head.Signature)
+
+	client.Commit.Signer, err = vcs.NewSigner(vcs.SignSSH, sshKey(t, false), "")
+	assert.NoError(t, err)
+	_, err = client.ReusePull("owner/repo", "main", "atlantis-drift", vcs.PullRequest{Body: "Drift body"})
+	assert.NoError(t, err)
+	head = repo.Head("atlantis-drift")
+	assert.Equal(t, repo.Head("main").SHA, head.Parent)
+	verifySSH(t, head.Payload, head.Signature)
+
+	client.Commit = vcs.CommitOptions{WebSigned: true}
+	_, err = client.CreatePull("owner/repo", "main", vcs.PullRequest{Body: "Drift body"})
+	assert.EqualError(t, err, "web signing of drift commits is only supported on GitLab")
+}
+
+func TestGithubMarker(t *testing.T) {
+	server, client := newGithub(t)
+	repo := server.Repo("owner/repo")
+	marker := vcs.Marker{Path: ".drift/marker.txt", Content: "run abc123"}
+
+	_, err := client.CreatePull("owner/repo", "main", vcs.PullRequest{Body: "Drift body", Marker: marker})
+	assert.NoError(t, err)
+	branch, err := client.DriftBranch("owner/repo", "main")
+	assert.NoError(t, err)
+	content, ok := repo.File(branch, ".drift/marker.txt")
+	assert.True(t, ok)
+	assert.Equal(t, "run abc123", content)
+	_, ok = repo.File(branch, vcs.DefaultMarkerPath)
+	assert.False(t, ok)
+}
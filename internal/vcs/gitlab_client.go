@@ -1,31 +1,40 @@
 package vcs
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
-	"time"
 
 	"github.com/xanzy/go-gitlab"
 )
 
 type GitlabClient struct {
 	Client *gitlab.Client
+	// Commit configures the drift marker commits. GitLab cannot create commits
+	// with a given signature, so the Signer must not be set; WebSigned checks
+	// that GitLab signed them instead.
+	Commit CommitOptions
 }
 
-func NewGitlabClient(hostname, token string) (*GitlabClient, error) {
-	glClient, err := gitlab.NewClient(token, gitlab.WithBaseURL(hostname))
+func NewGitlabClient(hostname string, token TokenSource) (*GitlabClient, error) {
+	httpClient := &http.Client{Transport: &headerTransport{
+		header: "PRIVATE-TOKEN",
+		src:    token,
+		base:   http.DefaultTransport,
+	}}
+	glClient, err := gitlab.NewClient("", gitlab.WithBaseURL(hostname), gitlab.WithHTTPClient(httpClient))
 	if err != nil {
 		return nil, err
 	}
-	return &GitlabClient{glClient}, err
+	return &GitlabClient{Client: glClient}, err
 }
 
 func (g *GitlabClient) GetFileContent(repo, path, ref string) (bool, []byte, error) {
 	opt := gitlab.GetRawFileOptions{Ref: gitlab.String(ref)}
 
 	bytes, resp, err := g.Client.RepositoryFiles.GetRawFile(repo, path, &opt)
-	if resp.StatusCode == http.StatusNotFound {
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
 		return false, []byte{}, nil
 	}
 
@@ -36,34 +45,225 @@ func (g *GitlabClient) GetFileContent(repo, path, ref string) (bool, []byte, err
 	return true, bytes, nil
 }
 
-func (c *GitlabClient) CreatePull(repo, sourceBranch string) (int, string, error) {
-	// TODO
-	// mrReviewers := c.reviewerIDs()
+// CreatePull commits the drift marker to the drift branch of ref and returns
+// the open MR from it into ref with its description replaced, opening one when
+// there is none.
+func (c *GitlabClient) CreatePull(repo, ref string, pull PullRequest) (PullChanges, error) {
+	driftBranch, err := c.DriftBranch(repo, ref)
+	if err != nil {
+		return PullChanges{}, err
+	}
 
-	head, _, err := c.Client.Commits.GetCommit(repo, sourceBranch)
+	changes, err := c.CommitFileChange(repo, ref, driftBranch, pull.Marker)
 	if err != nil {
-		return 0, "", err
+		return changes, err
 	}
-	targetBranch := "atlantis-drift-" + head.ShortID
+	err = c.upsertMergeRequest(repo, ref, pull, &changes)
+	return changes, err
+}
 
-	err = c.CommitFileChange(repo, sourceBranch, targetBranch)
+func (c *GitlabClient) openMergeRequest(repo, source, target string, pull PullRequest) (int, string, error) {
+	o := pull.MergeRequest
+	opt := &gitlab.CreateMergeRequestOptions{
+		Title:              gitlab.String(PullTitle),
+		Description:        gitlab.String(truncate(pull.Body, GitlabMaxBody)),
+		SourceBranch:       gitlab.String(source),
+		TargetBranch:       gitlab.String(target),
+		RemoveSourceBranch: gitlab.Bool(o.RemoveSourceBranch == nil || *o.RemoveSourceBranch),
+		Squash:             gitlab.Bool(o.Squash == nil || *o.Squash),
+	}
+	if o.Draft {
+		opt.Title = gitlab.String("Draft: " + PullTitle)
+	}
+	if len(o.Labels) > 0 {
+		labels := gitlab.Labels(o.Labels)
+		opt.Labels = &labels
+	}
+	if len(o.Reviewers) > 0 {
+		ids, err := c.userIDs(o.Reviewers)
+		if err != nil {
+			return 0, "", fmt.Errorf("resolving MR reviewers: %w", err)
+		}
+		opt.ReviewerIDs = &ids
+	}
+	if len(o.Assignees) > 0 {
+		ids, err := c.userIDs(o.Assignees)
+		if err != nil {
+			return 0, "", fmt.Errorf("resolving MR assignees: %w", err)
+		}
+		opt.AssigneeIDs = &ids
+	}
+	if o.Milestone != "" {
+		id, err := c.milestoneID(repo, o.Milestone)
+		if err != nil {
+			return 0, "", err
+		}
+		opt.MilestoneID = gitlab.Int(id)
+	}
+
+	mr, _, err := c.Client.MergeRequests.CreateMergeRequest(repo, opt)
 	if err != nil {
 		return 0, "", err
 	}
-	mr, _, err := c.Client.MergeRequests.CreateMergeRequest(repo, &gitlab.CreateMergeRequestOptions{
-		Title:        gitlab.String("Atlantis drift detector"),
-		SourceBranch: gitlab.String(sourceBranch),
-		TargetBranch: gitlab.String(targetBranch),
-		//ReviewerIDs:        mrReviewers,
-		RemoveSourceBranch: gitlab.Bool(true),
-		Squash:             gitlab.Bool(true),
+
+	return mr.IID, mr.WebURL, nil
+}
+
+// RequestReviewers adds the @user owners to the reviewers of the MR. GitLab
+// only accepts users as reviewers, so groups and unknown usernames are
+// skipped.
+func (c *GitlabClient) RequestReviewers(repo string, pull int, owners []string) error {
+	mr, _, err := c.Client.MergeRequests.GetMergeRequest(repo, pull, nil)
+	if err != nil {
+		return err
+	}
+	ids := []int{}
+	seen := map[int]bool{}
+	for _, r := range mr.Reviewers {
+		ids = append(ids, r.ID)
+		seen[r.ID] = true
+	}
+	added := false
+	for _, o := range owners {
+		name, ok := strings.CutPrefix(o, "@")
+		if !ok || strings.Contains(name, "/") {
+			continue
+		}
+		users, _, err := c.Client.Users.ListUsers(&gitlab.ListUsersOptions{Username: gitlab.String(name)})
+		if err != nil {
+			return err
+		}
+		if len(users) == 0 || seen[users[0].ID] {
+			continue
+		}
+		ids = append(ids, users[0].ID)
+		seen[users[0].ID] = true
+		added = true
+	}
+	if !added {
+		return nil
+	}
+	_, _, err = c.Client.MergeRequests.UpdateMergeRequest(repo, pull, &gitlab.UpdateMergeRequestOptions{ReviewerIDs: &ids})
+	return err
+}
+
+// userIDs resolves usernames to the IDs the MR API expects.
+func (c *GitlabClient) userIDs(usernames []string) ([]int, error) {
+	ids := make([]int, 0, len(usernames))
+	for _, name := range usernames {
+		users, _, err := c.Client.Users.ListUsers(&gitlab.ListUsersOptions{Username: gitlab.String(name)})
+		if err != nil {
+			return nil, err
+		}
+		if len(users) == 0 {
+			return nil, fmt.Errorf("no GitLab user named %q", name)
+		}
+		ids = append(ids, users[0].ID)
+	}
+	return ids, nil
+}
+
+// milestoneID resolves the title of a milestone of the project or one of its
+// groups.
+func (c *GitlabClient) milestoneID(repo, title string) (int, error) {
+	milestones, _, err := c.Client.Milestones.ListMilestones(repo, &gitlab.ListMilestonesOptions{
+		Title:                   gitlab.String(title),
+		IncludeParentMilestones: gitlab.Bool(true),
 	})
+	if err != nil {
+		return 0, fmt.Errorf("resolving the MR milestone: %w", err)
+	}
+	if len(milestones) == 0 {
+		return 0, fmt.Errorf("no milestone titled %q in %s", title, repo)
+	}
+	return milestones[0].ID, nil
+}
 
-	return mr.IID, mr.WebURL, err
+// ReusePull rebases branch onto ref and returns the open MR from branch into
+// ref with its description replaced, opening one when there is none.
+func (c *GitlabClient) ReusePull(repo, ref, branch string, pull PullRequest) (PullChanges, error) {
+	changes, err := c.rebaseBranch(repo, ref, branch, pull.Marker)
+	if err != nil {
+		return changes, err
+	}
+	err = c.upsertMergeRequest(repo, ref, pull, &changes)
+	return changes, err
 }
 
-func (g *GitlabClient) driftCommitFileAction(repo, branch string) (gitlab.FileActionValue, error) {
-	driftFileExists, _, err := g.GetFileContent(repo, "drift/date.txt", branch)
+// upsertMergeRequest replaces the description of the open MR from the branch
+// of changes into target, opening one when there is none, and records the MR
+// in changes.
+func (c *GitlabClient) upsertMergeRequest(repo, target string, pull PullRequest, changes *PullChanges) error {
+	mrs, _, err := c.Client.MergeRequests.ListProjectMergeRequests(repo, &gitlab.ListProjectMergeRequestsOptions{
+		State:        gitlab.String("opened"),
+		SourceBranch: gitlab.String(changes.Branch),
+		TargetBranch: gitlab.String(target),
+	})
+	if err != nil {
+		return err
+	}
+	if len(mrs) == 0 {
+		changes.Number, changes.URL, err = c.openMergeRequest(repo, changes.Branch, target, pull)
+		changes.PullCreated = err == nil
+		return err
+	}
+	mr, _, err := c.Client.MergeRequests.UpdateMergeRequest(repo, mrs[0].IID, &gitlab.UpdateMergeRequestOptions{
+		Description: gitlab.String(truncate(pull.Body, GitlabMaxBody)),
+	})
+	if err != nil {
+		return err
+	}
+	changes.Number, changes.URL = mr.IID, mr.WebURL
+	return nil
+}
+
+// rebaseBranch resets branch to a single drift marker commit on top of the
+// head commit of ref, unless it already is one.
+func (c *GitlabClient) rebaseBranch(repo, ref, branch string, marker Marker) (PullChanges, error) {
+	changes := PullChanges{Branch: branch}
+	head, _, err := c.Client.Commits.GetCommit(repo, ref)
+	if err != nil {
+		return changes, err
+	}
+	tip, exists, err := c.branchTip(repo, branch)
+	if err != nil {
+		return changes, err
+	}
+	if exists && len(tip.ParentIDs) == 1 && tip.ParentIDs[0] == head.ID {
+		changes.Commit = tip.ID
+		return changes, nil
+	}
+	sha, err := c.commitMarker(repo, ref, branch, marker)
+	if err != nil {
+		return changes, err
+	}
+	changes.Commit, changes.Committed, changes.BranchCreated = sha, true, !exists
+	return changes, nil
+}
+
+// branchTip returns the head commit of branch and whether the branch exists.
+func (c *GitlabClient) branchTip(repo, branch string) (*gitlab.Commit, bool, error) {
+	tip, resp, err := c.Client.Commits.GetCommit(repo, branch)
+	switch {
+	case err == nil:
+		return tip, true, nil
+	case resp != nil && resp.StatusCode == http.StatusNotFound:
+		return nil, false, nil
+	}
+	return nil, false, err
+}
+
+func (c *GitlabClient) DriftBranch(repo, ref string) (string, error) {
+	head, _, err := c.Client.Commits.GetCommit(repo, ref)
+	if err != nil {
+		return "", err
+	}
+	return driftBranchPrefix + head.ShortID, nil
+}
+
+// driftCommitFileAction creates the marker at path unless it exists at ref.
+func (g *GitlabClient) driftCommitFileAction(repo, path, ref string) (gitlab.FileActionValue, error) {
+	driftFileExists, _, err := g.GetFileContent(repo, path, ref)
 	if err != nil {
 		return "", err
 	}
@@ -74,24 +274,175 @@ func (g *GitlabClient) driftCommitFileAction(repo, branch string) (gitlab.FileAc
 	return action, nil
 }
 
-func (g *GitlabClient) CommitFileChange(repo, sourceBranch, targetBranch string) error {
-	action, err := g.driftCommitFileAction(repo, sourceBranch)
+// CommitFileChange commits marker to driftBranch, which is created or reset
+// from ref.
+func (g *GitlabClient) CommitFileChange(repo, ref, driftBranch string, marker Marker) (PullChanges, error) {
+	changes := PullChanges{Branch: driftBranch}
+	_, exists, err := g.branchTip(repo, driftBranch)
 	if err != nil {
-		return err
+		return changes, err
+	}
+	sha, err := g.commitMarker(repo, ref, driftBranch, marker)
+	if err != nil {
+		return changes, err
 	}
+	changes.Commit, changes.Committed, changes.BranchCreated = sha, true, !exists
+	return changes, nil
+}
 
-	_, _, err = g.Client.Commits.CreateCommit(repo, &gitlab.CreateCommitOptions{
-		Branch:        gitlab.String(sourceBranch),
-		CommitMessage: gitlab.String("Update date.txt"),
-		StartBranch:   gitlab.String(targetBranch),
+// commitMarker commits marker to branch, which is created or reset from ref,
+// and returns the SHA of the commit. The commits API does not accept
+// signatures, so signed commits must be signed by GitLab itself.
+func (g *GitlabClient) commitMarker(repo, ref, branch string, marker Marker) (string, error) {
+	if g.Commit.Signer != nil {
+		return "", errors.New("Gitlab does not accept signatures of drift commits, they can only be web signed")
+	}
+	marker = marker.withDefaults()
+	// The branch is reset to ref, so whether the marker exists is decided by
+	// ref.
+	action, err := g.driftCommitFileAction(repo, marker.Path, ref)
+	if err != nil {
+		return "", err
+	}
+
+	opts := &gitlab.CreateCommitOptions{
+		Branch:        gitlab.String(branch),
+		CommitMessage: gitlab.String(g.Commit.message()),
+		StartBranch:   gitlab.String(ref),
 		Actions: []*gitlab.CommitActionOptions{{
 			Action:   gitlab.FileAction(action),
-			FilePath: gitlab.String("drift-date.txt"),
-			Content:  gitlab.String(time.Now().String()),
+			FilePath: gitlab.String(marker.Path),
+			Content:  gitlab.String(marker.Content),
 		}},
-		AuthorName: gitlab.String("group_1301_bot2"),
-		Force:      gitlab.Bool(true),
-	})
+		Force: gitlab.Bool(true),
+	}
+	if g.Commit.AuthorName != "" {
+		opts.AuthorName = gitlab.String(g.Commit.AuthorName)
+	}
+	if g.Commit.AuthorEmail != "" {
+		opts.AuthorEmail = gitlab.String(g.Commit.AuthorEmail)
+	}
+	commit, _, err := g.Client.Commits.CreateCommit(repo, opts)
+	if err != nil {
+		return "", err
+	}
+	if g.Commit.WebSigned {
+		if err := g.verifyWebSigned(repo, commit.ID); err != nil {
+			return "", err
+		}
+	}
+	return commit.ID, nil
+}
+
+// verifyWebSigned checks that GitLab signed the commit sha with a signature it
+// verifies, which it only does when web commit signing is enabled.
+func (g *GitlabClient) verifyWebSigned(repo, sha string) error {
+	sig, resp, err := g.Client.Commits.GetGPGSiganature(repo, sha)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("drift commit %s is not signed, web commit signing must be enabled on the GitLab instance", sha)
+	}
+	if err != nil {
+		return err
+	}
+	if sig.VerificationStatus != "verified" {
+		return fmt.Errorf("the signature of drift commit %s is %s", sha, sig.VerificationStatus)
+	}
+	return nil
+}
+
+// maxGitlabStatusLength is the longest commit status description GitLab
+// accepts.
+const maxGitlabStatusLength = 255
+
+// ReportStatus sets a commit status on the head commit of ref. GitLab statuses
+// have no room for a per-project summary, so only the title is reported.
+func (c *GitlabClient) ReportStatus(repo, ref string, status CommitStatus) error {
+	head, _, err := c.Client.Commits.GetCommit(repo, ref)
+	if err != nil {
+		return err
+	}
+	state := gitlab.Success
+	if status.State != StateClean {
+		state = gitlab.Failed
+	}
+	opts := &gitlab.SetCommitStatusOptions{
+		State:       state,
+		Ref:         gitlab.String(ref),
+		Name:        gitlab.String(status.Context),
+		Description: gitlab.String(truncate(status.Title, maxGitlabStatusLength)),
+	}
+	if status.URL != "" {
+		opts.TargetURL = gitlab.String(status.URL)
+	}
+	_, _, err = c.Client.Commits.SetCommitStatus(repo, head.ID, opts)
+	return err
+}
+
+// gitlabPullStates maps merge request states to the states in Branch.Pulls.
+var gitlabPullStates = map[string]string{
+	"opened": PullOpen,
+	"closed": PullClosed,
+	"locked": PullClosed,
+	"merged": PullMerged,
+}
+
+// DriftBranches lists the drift branches of the project with the date of
+// their head commit and the state of their MRs.
+func (c *GitlabClient) DriftBranches(repo string) ([]Branch, error) {
+	var found []*gitlab.Branch
+	opts := &gitlab.ListBranchesOptions{
+		ListOptions: gitlab.ListOptions{PerPage: 100},
+		Search:      gitlab.String("^" + driftBranchPrefix),
+	}
+	for {
+		page, resp, err := c.Client.Branches.ListBranches(repo, opts)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, page...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	var branches []Branch
+	for _, gb := range found {
+		b := Branch{Name: gb.Name}
+		if gb.Commit != nil && gb.Commit.CommittedDate != nil {
+			b.Updated = *gb.Commit.CommittedDate
+		}
+		mrs, _, err := c.Client.MergeRequests.ListProjectMergeRequests(repo, &gitlab.ListProjectMergeRequestsOptions{
+			ListOptions:  gitlab.ListOptions{PerPage: 100},
+			State:        gitlab.String("all"),
+			SourceBranch: gitlab.String(gb.Name),
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, mr := range mrs {
+			b.Pulls = append(b.Pulls, gitlabPullStates[mr.State])
+		}
+		branches = append(branches, b)
+	}
+	return branches, nil
+}
+
+// PullState returns the state of the MR at url.
+func (c *GitlabClient) PullState(repo, url string) (string, error) {
+	iid, err := pullNumber(url)
+	if err != nil {
+		return "", err
+	}
+	mr, _, err := c.Client.MergeRequests.GetMergeRequest(repo, iid, nil)
+	if err != nil {
+		return "", err
+	}
+	return gitlabPullStates[mr.State], nil
+}
+
+func (c *GitlabClient) DeleteBranch(repo, branch string) error {
+	_, err := c.Client.Branches.DeleteBranch(repo, branch)
 	return err
 }
 
@@ -99,11 +450,17 @@ func (c *GitlabClient) VcsType() string {
 	return "Gitlab"
 }
 
+func (c *GitlabClient) MaxBodyLength() int {
+	return GitlabMaxBody
+}
+
 func (c *GitlabClient) CommentOnPull(repo string, pull int, driftedProjects []string) error {
-	projectRegexp := strings.Join(driftedProjects, "|")
-	commentBody := fmt.Sprintf("atlantis plan -p %s", projectRegexp)
+	return c.Comment(repo, pull, PlanComment(driftedProjects))
+}
+
+func (c *GitlabClient) Comment(repo string, pull int, body string) error {
 	_, _, err := c.Client.Notes.CreateMergeRequestNote(repo, pull, &gitlab.CreateMergeRequestNoteOptions{
-		Body: gitlab.String(commentBody),
+		Body: gitlab.String(body),
 	})
 	return err
 }
@@ -1,3 +1,327 @@
 package vcs_test
 
-//TODO
+import (
+	"testing"
+	"time"
+
+	"github.com/jukie/atlantis-drift-detection/internal/secret"
+	"github.com/jukie/atlantis-drift-detection/internal/vcs"
+	"github.com/jukie/atlantis-drift-detection/internal/vcstest"
+	"github.com/stretchr/testify/assert"
+)
+
+func newGitlab(t *testing.T) (*vcstest.GitlabServer, *vcs.GitlabClient) {
+	server := vcstest.NewGitlabServer()
+	t.Cleanup(server.Close)
+	server.Token = "test-token"
+	server.AddRepo("group/project", "main", map[string]string{"atlantis.yaml": "version: 3\n", "envs/prod/main.tf": "terraform {}\n"})
+	client, err := vcs.NewGitlabClient(server.URL, secret.Literal("test-token"))
+	assert.NoError(t, err)
+	return server, client
+}
+
+func TestGitlabGetFileContent(t *testing.T) {
+	server, client := newGitlab(t)
+
+	exists, content, err := client.GetFileContent("group/project", "envs/prod/main.tf", "main")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, "terraform {}\n", string(content))
+
+	// Projects can also be referenced by their numeric ID.
+	exists, _, err = client.GetFileContent("1", "atlantis.yaml", "main")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	// A missing file, ref or project is reported as not existing.
+	for _, args := range [][]string{{"group/project", "missing.yaml", "main"}, {"group/project", "atlantis.yaml", "missing"}, {"group/missing", "atlantis.yaml", "main"}} {
+		exists, _, err = client.GetFileContent(args[0], args[1], args[2])
+		assert.NoError(t, err)
+		assert.False(t, exists)
+	}
+
+	server.Token = "other-token"
+	_, _, err = client.GetFileContent("group/project", "atlantis.yaml", "main")
+	assert.ErrorContains(t, err, "401 Unauthorized")
+
+	server.Close()
+	_, _, err = client.GetFileContent("group/project", "atlantis.yaml", "main")
+	assert.Error(t, err)
+}
+
+func TestGitlabCreatePull(t *testing.T) {
+	server, client := newGitlab(t)
+	repo := server.Repo("group/project")
+	base := repo.Head("main")
+
+	branch, err := client.DriftBranch("group/project", "main")
+	assert.NoError(t, err)
+	assert.Equal(t, "atlantis-drift-"+base.SHA[:8], branch)
+
+	changes, err := client.CreatePull("group/project", "main", vcs.PullRequest{Body: "Drift body"})
+	assert.NoError(t, err)
+	url := server.URL + "/group/project/-/merge_requests/1"
+	head := repo.Head(branch)
+	assert.Equal(t, vcs.PullChanges{Branch: branch, BranchCreated: true, Commit: head.SHA, Committed: true, Number: 1, URL: url, PullCreated: true}, changes)
+
+	// The marker is committed to the drift branch only, on top of main.
+	assert.Equal(t, base, repo.Head("main"))
+	assert.Equal(t, base.SHA, head.Parent)
+	assert.Equal(t, vcs.DefaultCommitMessage, head.Message)
+	assert.Empty(t, head.AuthorName)
+	_, ok := head.Files["drift-date.txt"]
+	assert.True(t, ok)
+
+	mr := repo.Pulls[0]
+	assert.Equal(t, vcs.PullTitle, mr.Title)
+	assert.Equal(t, "Drift body", mr.Body)
+	assert.Equal(t, branch, mr.Head)
+	assert.Equal(t, "main", mr.Base)
+	assert.Equal(t, true, mr.Options["squash"])
+	assert.Equal(t, true, mr.Options["remove_source_branch"])
+
+	// A second run on the same commit resets the drift branch to main and
+	// reuses the MR.
+	changes, err = client.CreatePull("group/project", "main", vcs.PullRequest{Body: "New drift body"})
+	assert.NoError(t, err)
+	assert.Equal(t, vcs.PullChanges{Branch: branch, Commit: repo.Head(branch).SHA, Committed: true, Number: 1, URL: url}, changes)
+	assert.Equal(t, base.SHA, repo.Head(branch).Parent)
+	assert.Len(t, repo.Pulls, 1)
+	assert.Equal(t, "New drift body", repo.Pulls[0].Body)
+
+	_, err = client.CreatePull("group/project", "missing", vcs.PullRequest{Body: "Drift body"})
+	assert.ErrorContains(t, err, "404 Commit Not Found")
+}
+
+func TestGitlabMergeRequestOptions(t *testing.T) {
+	server, client := newGitlab(t)
+	server.Users = map[string]int{"alice": 7, "bob": 8}
+	server.Milestones = map[string]int{"Q3": 42}
+	repo := server.Repo("group/project")
+	no := false
+
+	_, err := client.CreatePull("group/project", "main", vcs.PullRequest{MergeRequest: vcs.MergeRequestOptions{Reviewers: []string{"carol"}}})
+	assert.EqualError(t, err, `resolving MR reviewers: no GitLab user named "carol"`)
+	_, err = client.CreatePull("group/project", "main", vcs.PullRequest{MergeRequest: vcs.MergeRequestOptions{Milestone: "Q4"}})
+	assert.EqualError(t, err, `no milestone titled "Q4" in group/project`)
+	assert.Empty(t, repo.Pulls)
+
+	_, err = client.CreatePull("group/project", "main", vcs.PullRequest{Body: "Drift body", MergeRequest: vcs.MergeRequestOptions{
+		Reviewers:          []string{"alice", "bob"},
+		Assignees:          []string{"bob"},
+		Labels:             []string{"drift", "infra"},
+		Milestone:          "Q3",
+		Draft:              true,
+		RemoveSourceBranch: &no,
+		Squash:             &no,
+	}})
+	assert.NoError(t, err)
+	mr := repo.Pulls[0]
+	assert.Equal(t, "Draft: "+vcs.PullTitle, mr.Title)
+	assert.Equal(t, []interface{}{7.0, 8.0}, mr.Options["reviewer_ids"])
+	assert.Equal(t, []interface{}{8.0}, mr.Options["assignee_ids"])
+	assert.Equal(t, "drift,infra", mr.Options["labels"])
+	assert.Equal(t, 42.0, mr.Options["milestone_id"])
+	assert.Equal(t, false, mr.Options["squash"])
+	assert.Equal(t, false, mr.Options["remove_source_branch"])
+
+	// Options are only resolved for new MRs, so an open one is reused as is.
+	_, err = client.CreatePull("group/project", "main", vcs.PullRequest{MergeRequest: vcs.MergeRequestOptions{Reviewers: []string{"carol"}}})
+	assert.NoError(t, err)
+	assert.Len(t, repo.Pulls, 1)
+}
+
+func TestGitlabRequestReviewers(t *testing.T) {
+	server, client := newGitlab(t)
+	server.Users = map[string]int{"alice": 7, "bob": 8, "carol": 9}
+	changes, err := client.CreatePull("group/project", "main", vcs.PullRequest{Body: "Drift body", MergeRequest: vcs.MergeRequestOptions{Reviewers: []string{"alice"}}})
+	assert.NoError(t, err)
+
+	var _ vcs.ReviewRequester = client
+	// Groups, emails and unknown users are skipped, and configured reviewers
+	// are kept.
+	err = client.RequestReviewers("group/project", changes.Number, []string{"@bob", "@group/sre", "@nobody", "ops@example.com", "@alice", "@carol"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"alice", "bob", "carol"}, server.Repo("group/project").Pulls[0].Reviewers)
+
+	assert.Error(t, client.RequestReviewers("group/project", 99, []string{"@bob"}))
+}
+
+func TestGitlabCommentOnPull(t *testing.T) {
+	server, client := newGitlab(t)
+	changes, err := client.CreatePull("group/project", "main", vcs.PullRequest{Body: "Drift body"})
+	assert.NoError(t, err)
+
+	assert.NoError(t, client.CommentOnPull("group/project", changes.Number, []string{"network", "compute"}))
+	assert.NoError(t, client.Comment("group/project", changes.Number, "Drift auto-apply results"))
+	assert.Equal(t, []string{"atlantis plan -p network|compute", "Drift auto-apply results"}, server.Repo("group/project").Pulls[0].Comments)
+
+	err = client.CommentOnPull("group/project", 42, []string{"network"})
+	assert.ErrorContains(t, err, "404")
+}
+
+func TestGitlabPullState(t *testing.T) {
+	server, client := newGitlab(t)
+	changes, err := client.CreatePull("group/project", "main", vcs.PullRequest{Body: "Drift body"})
+	assert.NoError(t, err)
+
+	mr := server.Repo("group/project").Pulls[0]
+	for _, state := range []string{vcs.PullOpen, vcs.PullClosed, vcs.PullMerged} {
+		mr.State = state
+		got, err := client.PullState("group/project", changes.URL)
+		assert.NoError(t, err)
+		assert.Equal(t, state, got)
+	}
+
+	_, err = client.PullState("group/project", server.URL+"/group/project/-/merge_requests/42")
+	assert.ErrorContains(t, err, "404")
+}
+
+func TestGitlabReportStatus(t *testing.T) {
+	server, client := newGitlab(t)
+	head := server.Repo("group/project").Head("main").SHA
+
+	assert.NoError(t, client.ReportStatus("group/project", "main", vcs.CommitStatus{
+		Context: "atlantis/drift",
+		State:   vcs.StateClean,
+		Title:   "No drift in 2 projects",
+	}))
+	assert.Equal(t, []*vcstest.Status{{
+		SHA:         head,
+		Context:     "atlantis/drift",
+		State:       "success",
+		Description: "No drift in 2 projects",
+		Options:     map[string]interface{}{"ref": "main"},
+	}}, server.Repo("group/project").Statuses)
+}
+
+func TestGitlabReusePull(t *testing.T) {
+	server, client := newGitlab(t)
+	repo := server.Repo("group/project")
+
+	changes, err := client.ReusePull("group/project", "main", "atlantis-drift", vcs.PullRequest{Body: "first"})
+	assert.NoError(t, err)
+	url := server.URL + "/group/project/-/merge_requests/1"
+	tip := repo.Head("atlantis-drift")
+	assert.Equal(t, vcs.PullChanges{Branch: "atlantis-drift", BranchCreated: true, Commit: tip.SHA, Committed: true, Number: 1, URL: url, PullCreated: true}, changes)
+	assert.Equal(t, repo.Head("main").SHA, tip.Parent)
+
+	// Without new commits on main the branch and MR are reused as they are.
+	changes, err = client.ReusePull("group/project", "main", "atlantis-drift", vcs.PullRequest{Body: "second"})
+	assert.NoError(t, err)
+	assert.Equal(t, vcs.PullChanges{Branch: "atlantis-drift", Commit: tip.SHA, Number: 1, URL: url}, changes)
+	assert.Equal(t, tip, repo.Head("atlantis-drift"))
+	assert.Equal(t, "second", repo.Pulls[0].Body)
+
+	// New commits on main reset the branch onto them.
+	pushed := server.Push("group/project", "main", "Add network", map[string]string{"network/main.tf": "terraform {}\n"})
+	changes, err = client.ReusePull("group/project", "main", "atlantis-drift", vcs.PullRequest{Body: "third"})
+	assert.NoError(t, err)
+	assert.Equal(t, vcs.PullChanges{Branch: "atlantis-drift", Commit: repo.Head("atlantis-drift").SHA, Committed: true, Number: 1, URL: url}, changes)
+	assert.Equal(t, pushed.SHA, repo.Head("atlantis-drift").Parent)
+
+	// A merged MR is replaced by a new one.
+	repo.Pulls[0].State = vcstest.PullMerged
+	changes, err = client.ReusePull("group/project", "main", "atlantis-drift", vcs.PullRequest{Body: "fourth"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, changes.Number)
+	assert.True(t, changes.PullCreated)
+}
+
+func TestGitlabDriftBranches(t *testing.T) {
+	server, client := newGitlab(t)
+	repo := server.Repo("group/project")
+
+	_, err := client.CreatePull("group/project", "main", vcs.PullRequest{Body: "Drift body"})
+	assert.NoError(t, err)
+	first, err := client.DriftBranch("group/project", "main")
+	assert.NoError(t, err)
+	repo.Pulls[0].State = vcstest.PullClosed
+	server.Push("group/project", "main", "Add network", map[string]string{"network/main.tf": "terraform {}\n"})
+	_, err = client.CreatePull("group/project", "main", vcs.PullRequest{Body: "Drift body"})
+	assert.NoError(t, err)
+	second, err := client.DriftBranch("group/project", "main")
+	assert.NoError(t, err)
+
+	updated := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+	repo.Head(first).Date = updated
+	branches, err := client.DriftBranches("group/project")
+	assert.NoError(t, err)
+	assert.Len(t, branches, 2)
+	for _, b := range branches {
+		switch b.Name {
+		case first:
+			assert.Equal(t, updated, b.Updated.UTC())
+			assert.Equal(t, []string{vcs.PullClosed}, b.Pulls)
+		case second:
+			assert.WithinDuration(t, time.Now(), b.Updated, time.Minute)
+			assert.Equal(t, []string{vcs.PullOpen}, b.Pulls)
+		default:
+			t.Errorf("unexpected branch %s", b.Name)
+		}
+	}
+
+	assert.NoError(t, client.DeleteBranch("group/project", first))
+	assert.Nil(t, repo.Head(first))
+	assert.NotNil(t, repo.Head(second))
+	assert.Error(t, client.DeleteBranch("group/project", "main"))
+}
+
+func TestGitlabCommitOptions(t *testing.T) {
+	server, client := newGitlab(t)
+	repo := server.Repo("group/project")
+	client.Commit = vcs.CommitOptions{Message: "chore: drift marker", AuthorName: "Drift Bot", AuthorEmail: "drift@example.com"}
+
+	_, err := client.CreatePull("group/project", "main", vcs.PullRequest{Body: "Drift body"})
+	assert.NoError(t, err)
+	branch, err := client.DriftBranch("group/project", "main")
+	assert.NoError(t, err)
+	head := repo.Head(branch)
+	assert.Equal(t, "chore: drift marker", head.Message)
+	assert.Equal(t, "Drift Bot", head.AuthorName)
+	assert.Equal(t, "drift@example.com", head.AuthorEmail)
+
+	client.Commit.Signer, err = vcs.NewSigner(vcs.SignSSH, sshKey(t, false), "")
+	assert.NoError(t, err)
+	_, err = client.ReusePull("group/project", "main", "atlantis-drift", vcs.PullRequest{Body: "Drift body"})
+	assert.EqualError(t, err, "Gitlab does not accept signatures of drift commits, they can only be web signed")
+}
+
+func TestGitlabWebSignedCommits(t *testing.T) {
+	server, client := newGitlab(t)
+	repo := server.Repo("group/project")
+	client.Commit = vcs.CommitOptions{WebSigned: true}
+
+	// Commits the instance does not sign are rejected.
+	changes, err := client.CreatePull("group/project", "main", vcs.PullRequest{Body: "Drift body"})
+	assert.ErrorContains(t, err, "is not signed, web commit signing must be enabled on the GitLab instance")
+	assert.Empty(t, changes.Commit)
+	assert.Empty(t, repo.Pulls)
+
+	server.WebCommitSigning = true
+	changes, err = client.CreatePull("group/project", "main", vcs.PullRequest{Body: "Drift body"})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, repo.Head(changes.Branch).Signature)
+	assert.Len(t, repo.Pulls, 1)
+}
+
+func TestGitlabMarker(t *testing.T) {
+	server, client := newGitlab(t)
+	repo := server.Repo("group/project")
+	// A marker merged with an earlier drift MR is updated, not created.
+	server.Push("group/project", "main", "Merge drift", map[string]string{vcs.DefaultMarkerPath: "old"})
+
+	_, err := client.CreatePull("group/project", "main", vcs.PullRequest{Body: "Drift body"})
+	assert.NoError(t, err)
+	branch, err := client.DriftBranch("group/project", "main")
+	assert.NoError(t, err)
+	content, _ := repo.File(branch, vcs.DefaultMarkerPath)
+	assert.NotEqual(t, "old", content)
+
+	marker := vcs.Marker{Path: ".drift/marker.txt", Content: "run abc123"}
+	_, err = client.ReusePull("group/project", "main", "atlantis-drift", vcs.PullRequest{Body: "Drift body", Marker: marker})
+	assert.NoError(t, err)
+	content, ok := repo.File("atlantis-drift", ".drift/marker.txt")
+	assert.True(t, ok)
+	assert.Equal(t, "run abc123", content)
+}
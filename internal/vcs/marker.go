@@ -0,0 +1,67 @@
+package vcs
+
+import (
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DefaultMarkerPath is the file committed to drift branches unless
+// configured otherwise.
+const DefaultMarkerPath = "drift-date.txt"
+
+// DefaultMarkerTemplate renders the content of the drift marker unless
+// configured otherwise.
+const DefaultMarkerTemplate = "{{.Timestamp}}"
+
+// Marker is the file committed to a drift branch so that it differs from the
+// ref it was created from.
+type Marker struct {
+	Path    string
+	Content string
+}
+
+// withDefaults fills in DefaultMarkerPath and the current time for a marker
+// that does not set them.
+func (m Marker) withDefaults() Marker {
+	if m.Path == "" {
+		m.Path = DefaultMarkerPath
+	}
+	if m.Content == "" {
+		m.Content = time.Now().String()
+	}
+	return m
+}
+
+// MarkerData is what marker templates are rendered with.
+type MarkerData struct {
+	RunID string
+	Repo  string
+	Ref   string
+	// Projects are the drifted projects the PR is opened for.
+	Projects  []string
+	Timestamp time.Time
+}
+
+// ParseMarkerTemplate parses a marker content template. Unknown fields of
+// MarkerData are reported when the template is rendered.
+func ParseMarkerTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		text = DefaultMarkerTemplate
+	}
+	return template.New("marker").Option("missingkey=error").Parse(text)
+}
+
+// RenderMarker renders the marker at path with the content template text,
+// both of which may be empty for their defaults.
+func RenderMarker(path, text string, data MarkerData) (Marker, error) {
+	tmpl, err := ParseMarkerTemplate(text)
+	if err != nil {
+		return Marker{}, err
+	}
+	var content strings.Builder
+	if err := tmpl.Execute(&content, data); err != nil {
+		return Marker{}, err
+	}
+	return Marker{Path: path, Content: content.String()}.withDefaults(), nil
+}
@@ -0,0 +1,37 @@
+package vcs_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jukie/atlantis-drift-detection/internal/vcs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderMarker(t *testing.T) {
+	data := vcs.MarkerData{
+		RunID:     "abc123",
+		Repo:      "owner/repo",
+		Ref:       "main",
+		Projects:  []string{"network", "compute"},
+		Timestamp: time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC),
+	}
+	m, err := vcs.RenderMarker(".drift/marker.md", "Run {{.RunID}} at {{.Timestamp.Format \"2006-01-02\"}}\n{{range .Projects}}- {{.}}\n{{end}}", data)
+	assert.NoError(t, err)
+	assert.Equal(t, vcs.Marker{Path: ".drift/marker.md", Content: "Run abc123 at 2023-04-01\n- network\n- compute\n"}, m)
+
+	m, err = vcs.RenderMarker("", "", data)
+	assert.NoError(t, err)
+	assert.Equal(t, vcs.Marker{Path: vcs.DefaultMarkerPath, Content: "2023-04-01 12:00:00 +0000 UTC"}, m)
+
+	// An empty rendering falls back to the current time so that the marker
+	// still changes the branch.
+	m, err = vcs.RenderMarker("", "{{if false}}x{{end}}", data)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, m.Content)
+
+	_, err = vcs.RenderMarker("", "{{.Commit}}", data)
+	assert.ErrorContains(t, err, "can't evaluate field Commit")
+	_, err = vcs.RenderMarker("", "{{.RunID", data)
+	assert.ErrorContains(t, err, "unclosed action")
+}
@@ -0,0 +1,149 @@
+package vcs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"golang.org/x/crypto/ssh"
+)
+
+// Signing formats. NewSigner accepts those signing with a key.
+const (
+	SignGPG = "gpg"
+	SignSSH = "ssh"
+	// SignWeb leaves signing to GitLab, which signs the commits created
+	// through its API when web commit signing is enabled.
+	SignWeb = "web"
+)
+
+// SignFormats lists the signing formats.
+var SignFormats = []string{SignGPG, SignSSH, SignWeb}
+
+// NewSigner returns a signer for an armored OpenPGP private key or an OpenSSH
+// private key. The passphrase may be empty for unencrypted keys.
+func NewSigner(format, key, passphrase string) (Signer, error) {
+	switch format {
+	case SignGPG:
+		return newGPGSigner(key, passphrase)
+	case SignSSH:
+		return newSSHSigner(key, passphrase)
+	case SignWeb:
+		return nil, errors.New("web signed commits are signed by GitLab, not with a key")
+	}
+	return nil, fmt.Errorf("unknown signing format %q, expected one of: %s, %s", format, SignGPG, SignSSH)
+}
+
+type gpgSigner struct {
+	entity *openpgp.Entity
+}
+
+func newGPGSigner(key, passphrase string) (Signer, error) {
+	entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(key))
+	if err != nil {
+		return nil, fmt.Errorf("reading the GPG key: %w", err)
+	}
+	if len(entities) != 1 {
+		return nil, fmt.Errorf("the GPG key must hold exactly one key, found %d", len(entities))
+	}
+	entity := entities[0]
+	if entity.PrivateKey == nil {
+		return nil, errors.New("the GPG key is not a private key")
+	}
+	keys := []*packet.PrivateKey{entity.PrivateKey}
+	for _, sub := range entity.Subkeys {
+		keys = append(keys, sub.PrivateKey)
+	}
+	for _, k := range keys {
+		if k == nil || !k.Encrypted {
+			continue
+		}
+		if passphrase == "" {
+			return nil, errors.New("the GPG key is encrypted but no passphrase is configured")
+		}
+		if err := k.Decrypt([]byte(passphrase)); err != nil {
+			return nil, fmt.Errorf("decrypting the GPG key: %w", err)
+		}
+	}
+	return gpgSigner{entity: entity}, nil
+}
+
+func (s gpgSigner) Sign(payload []byte) (string, error) {
+	var sig bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&sig, s.entity, bytes.NewReader(payload), nil); err != nil {
+		return "", err
+	}
+	return sig.String(), nil
+}
+
+// sshNamespace is the namespace git signs commits in.
+const sshNamespace = "git"
+
+type sshSigner struct {
+	signer ssh.Signer
+}
+
+func newSSHSigner(key, passphrase string) (Signer, error) {
+	var signer ssh.Signer
+	var err error
+	if passphrase == "" {
+		signer, err = ssh.ParsePrivateKey([]byte(key))
+	} else {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(key), []byte(passphrase))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading the SSH key: %w", err)
+	}
+	return sshSigner{signer: signer}, nil
+}
+
+// Sign returns an SSHSIG signature like `ssh-keygen -Y sign -n git` does.
+func (s sshSigner) Sign(payload []byte) (string, error) {
+	digest := sha512.Sum512(payload)
+	signed := ssh.Marshal(struct {
+		Namespace string
+		Reserved  string
+		HashAlg   string
+		Hash      string
+	}{sshNamespace, "", "sha512", string(digest[:])})
+	signed = append([]byte("SSHSIG"), signed...)
+
+	var sig *ssh.Signature
+	var err error
+	if as, ok := s.signer.(ssh.AlgorithmSigner); ok && s.signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		// SSHSIG does not allow SHA-1 RSA signatures.
+		sig, err = as.SignWithAlgorithm(rand.Reader, signed, ssh.KeyAlgoRSASHA512)
+	} else {
+		sig, err = s.signer.Sign(rand.Reader, signed)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	blob := []byte("SSHSIG")
+	blob = binary.BigEndian.AppendUint32(blob, 1)
+	blob = append(blob, ssh.Marshal(struct {
+		PublicKey string
+		Namespace string
+		Reserved  string
+		HashAlg   string
+		Signature string
+	}{string(s.signer.PublicKey().Marshal()), sshNamespace, "", "sha512", string(ssh.Marshal(sig))})...)
+
+	encoded := base64.StdEncoding.EncodeToString(blob)
+	var armored strings.Builder
+	armored.WriteString("-----BEGIN SSH SIGNATURE-----\n")
+	for len(encoded) > 70 {
+		armored.WriteString(encoded[:70] + "\n")
+		encoded = encoded[70:]
+	}
+	armored.WriteString(encoded + "\n-----END SSH SIGNATURE-----\n")
+	return armored.String(), nil
+}
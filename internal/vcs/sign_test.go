@@ -0,0 +1,128 @@
+package vcs_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/jukie/atlantis-drift-detection/internal/vcs"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+// gpgKey returns a new OpenPGP key, armored and encrypted with passphrase
+// unless it is empty.
+func gpgKey(t *testing.T, passphrase string) (*openpgp.Entity, string) {
+	entity, err := openpgp.NewEntity("Drift Bot", "", "drift@example.com", nil)
+	assert.NoError(t, err)
+	if passphrase != "" {
+		assert.NoError(t, entity.PrivateKey.Encrypt([]byte(passphrase)))
+		for _, sub := range entity.Subkeys {
+			assert.NoError(t, sub.PrivateKey.Encrypt([]byte(passphrase)))
+		}
+	}
+	var key bytes.Buffer
+	w, err := armor.Encode(&key, openpgp.PrivateKeyType, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, entity.SerializePrivateWithoutSigning(w, nil))
+	assert.NoError(t, w.Close())
+	return entity, key.String()
+}
+
+func verifyGPG(t *testing.T, entity *openpgp.Entity, payload, signature string) {
+	_, err := openpgp.CheckArmoredDetachedSignature(openpgp.EntityList{entity}, strings.NewReader(payload), strings.NewReader(signature), nil)
+	assert.NoError(t, err)
+}
+
+// sshKey returns a new PEM encoded ECDSA or RSA key.
+func sshKey(t *testing.T, rsaKey bool) string {
+	if rsaKey {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		assert.NoError(t, err)
+		return string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	der, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+}
+
+// verifySSH checks an armored SSHSIG signature of payload in the git
+// namespace and returns the signing public key.
+func verifySSH(t *testing.T, payload, signature string) ssh.PublicKey {
+	lines := strings.Split(strings.TrimSpace(signature), "\n")
+	assert.Equal(t, "-----BEGIN SSH SIGNATURE-----", lines[0])
+	assert.Equal(t, "-----END SSH SIGNATURE-----", lines[len(lines)-1])
+	blob, err := base64.StdEncoding.DecodeString(strings.Join(lines[1:len(lines)-1], ""))
+	assert.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(blob, []byte("SSHSIG\x00\x00\x00\x01")))
+
+	var sig struct {
+		PublicKey string
+		Namespace string
+		Reserved  string
+		HashAlg   string
+		Signature string
+	}
+	assert.NoError(t, ssh.Unmarshal(blob[10:], &sig))
+	assert.Equal(t, "git", sig.Namespace)
+	assert.Equal(t, "sha512", sig.HashAlg)
+	pub, err := ssh.ParsePublicKey([]byte(sig.PublicKey))
+	assert.NoError(t, err)
+	var inner ssh.Signature
+	assert.NoError(t, ssh.Unmarshal([]byte(sig.Signature), &inner))
+
+	digest := sha512.Sum512([]byte(payload))
+	signed := append([]byte("SSHSIG"), ssh.Marshal(struct {
+		Namespace, Reserved, HashAlg, Hash string
+	}{"git", "", "sha512", string(digest[:])})...)
+	assert.NoError(t, pub.Verify(signed, &inner))
+	return pub
+}
+
+func TestGPGSigner(t *testing.T) {
+	entity, key := gpgKey(t, "secret")
+	_, err := vcs.NewSigner(vcs.SignGPG, key, "")
+	assert.ErrorContains(t, err, "the GPG key is encrypted but no passphrase is configured")
+	_, err = vcs.NewSigner(vcs.SignGPG, key, "wrong")
+	assert.ErrorContains(t, err, "decrypting the GPG key")
+
+	signer, err := vcs.NewSigner(vcs.SignGPG, key, "secret")
+	assert.NoError(t, err)
+	signature, err := signer.Sign([]byte("tree abc\n\nmessage"))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(signature, "-----BEGIN PGP SIGNATURE-----"))
+	verifyGPG(t, entity, "tree abc\n\nmessage", signature)
+
+	_, err = vcs.NewSigner(vcs.SignGPG, "not a key", "")
+	assert.ErrorContains(t, err, "reading the GPG key")
+}
+
+func TestSSHSigner(t *testing.T) {
+	for _, rsaKey := range []bool{false, true} {
+		signer, err := vcs.NewSigner(vcs.SignSSH, sshKey(t, rsaKey), "")
+		assert.NoError(t, err)
+		signature, err := signer.Sign([]byte("tree abc\n\nmessage"))
+		assert.NoError(t, err)
+		pub := verifySSH(t, "tree abc\n\nmessage", signature)
+		if rsaKey {
+			assert.Equal(t, ssh.KeyAlgoRSA, pub.Type())
+		}
+	}
+
+	_, err := vcs.NewSigner(vcs.SignSSH, "not a key", "")
+	assert.ErrorContains(t, err, "reading the SSH key")
+	_, err = vcs.NewSigner("x509", "", "")
+	assert.EqualError(t, err, `unknown signing format "x509", expected one of: gpg, ssh`)
+}
@@ -0,0 +1,58 @@
+package vcs
+
+import "unicode/utf8"
+
+// Commit states of a drift status.
+const (
+	StateClean   = "clean"
+	StateDrifted = "drifted"
+	// StateFailed is reported when a project could not be planned.
+	StateFailed = "failed"
+)
+
+// maxAnnotations is the most annotations GitHub accepts per check run
+// request.
+const maxAnnotations = 50
+
+// CommitStatus summarizes the drift of a repo on the head commit of its ref.
+type CommitStatus struct {
+	// Context names the status, or the check run on GitHub.
+	Context string
+	State   string
+	// Title is a one-line summary, shortened to fit commit status
+	// descriptions.
+	Title string
+	// Summary is a markdown summary of every project.
+	Summary string
+	// URL links to the drift PR or report.
+	URL      string
+	Projects []ProjectStatus
+}
+
+// ProjectStatus is the drift of one project. Projects that are not clean are
+// annotated on a Terraform file in their directory in GitHub check runs.
+type ProjectStatus struct {
+	Name    string
+	Dir     string
+	State   string
+	Message string
+}
+
+// StatusReporter is implemented by clients that can report drift on a
+// commit.
+type StatusReporter interface {
+	ReportStatus(repo, ref string, status CommitStatus) error
+}
+
+// truncate shortens s to at most n bytes without splitting a UTF-8 sequence,
+// marking the cut with "...".
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	cut := n - len("...")
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + "..."
+}
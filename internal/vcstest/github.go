@@ -0,0 +1,711 @@
+package vcstest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GithubServer is a fake of the GitHub REST API endpoints used by
+// vcs.GithubClient.
+type GithubServer struct {
+	store
+	// URL is the API base URL, to be passed to vcs.NewGithubClient.
+	URL string
+	// Token, when set, is the only bearer token accepted.
+	Token string
+	// DisableChecks rejects check runs like GitHub does for tokens other than
+	// those of GitHub Apps.
+	DisableChecks bool
+
+	server *httptest.Server
+}
+
+// NewGithubServer starts a fake GitHub server. Close it when done.
+func NewGithubServer() *GithubServer {
+	s := &GithubServer{store: newStore()}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	s.URL = s.server.URL + "/"
+	return s
+}
+
+// Close shuts the server down.
+func (s *GithubServer) Close() {
+	s.server.Close()
+}
+
+func (s *GithubServer) handle(w http.ResponseWriter, r *http.Request) {
+	if s.Token != "" && r.Header.Get("Authorization") != "Bearer "+s.Token {
+		writeMessage(w, http.StatusUnauthorized, "Bad credentials")
+		return
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 4 || parts[0] != "repos" {
+		writeMessage(w, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	repo := s.repos[parts[1]+"/"+parts[2]]
+	if repo == nil {
+		writeMessage(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	rest := parts[3:]
+	switch {
+	case rest[0] == "contents" && r.Method == http.MethodGet:
+		s.getContents(w, r, repo, strings.Join(rest[1:], "/"))
+	case rest[0] == "contents" && len(rest) > 1 && r.Method == http.MethodPut:
+		s.putContents(w, r, repo, strings.Join(rest[1:], "/"))
+	case rest[0] == "commits" && len(rest) == 2 && r.Method == http.MethodGet:
+		s.getCommit(w, repo, rest[1])
+	case rest[0] == "git" && len(rest) > 3 && rest[1] == "ref" && rest[2] == "heads" && r.Method == http.MethodGet:
+		s.getRef(w, repo, strings.Join(rest[3:], "/"))
+	case rest[0] == "git" && len(rest) > 2 && rest[1] == "matching-refs" && rest[2] == "heads" && r.Method == http.MethodGet:
+		s.listMatchingRefs(w, r, repo, strings.Join(rest[3:], "/"))
+	case rest[0] == "git" && len(rest) > 3 && rest[1] == "refs" && rest[2] == "heads" && r.Method == http.MethodDelete:
+		s.deleteRef(w, repo, strings.Join(rest[3:], "/"))
+	case rest[0] == "git" && len(rest) == 2 && rest[1] == "refs" && r.Method == http.MethodPost:
+		s.createRef(w, r, repo)
+	case rest[0] == "pulls" && len(rest) == 1 && r.Method == http.MethodPost:
+		s.createPull(w, r, repo)
+	case rest[0] == "pulls" && len(rest) == 1 && r.Method == http.MethodGet:
+		s.listPulls(w, r, repo)
+	case rest[0] == "pulls" && len(rest) == 2 && r.Method == http.MethodGet:
+		s.getPull(w, repo, rest[1])
+	case rest[0] == "pulls" && len(rest) == 2 && r.Method == http.MethodPatch:
+		s.editPull(w, r, repo, rest[1])
+	case rest[0] == "pulls" && len(rest) == 3 && rest[2] == "requested_reviewers" && r.Method == http.MethodPost:
+		s.requestReviewers(w, r, repo, rest[1])
+	case rest[0] == "git" && len(rest) > 3 && rest[1] == "refs" && rest[2] == "heads" && r.Method == http.MethodPatch:
+		s.updateRef(w, r, repo, strings.Join(rest[3:], "/"))
+	case rest[0] == "git" && len(rest) == 2 && rest[1] == "trees" && r.Method == http.MethodPost:
+		s.createTree(w, r, repo)
+	case rest[0] == "git" && len(rest) == 2 && rest[1] == "commits" && r.Method == http.MethodPost:
+		s.createGitCommit(w, r, repo)
+	case rest[0] == "git" && len(rest) == 3 && rest[1] == "commits" && r.Method == http.MethodGet:
+		s.getGitCommit(w, repo, rest[2])
+	case rest[0] == "issues" && len(rest) == 3 && rest[2] == "comments" && r.Method == http.MethodPost:
+		s.createComment(w, r, repo, rest[1])
+	case rest[0] == "check-runs" && len(rest) == 1 && r.Method == http.MethodPost:
+		s.createCheckRun(w, r, repo)
+	case rest[0] == "statuses" && len(rest) == 2 && r.Method == http.MethodPost:
+		s.createStatus(w, r, repo, rest[1])
+	default:
+		writeMessage(w, http.StatusNotFound, "Not Found")
+	}
+}
+
+func (s *GithubServer) ref(r *http.Request, repo *Repo) string {
+	if ref := r.URL.Query().Get("ref"); ref != "" {
+		return ref
+	}
+	return repo.DefaultBranch
+}
+
+func (s *GithubServer) getContents(w http.ResponseWriter, r *http.Request, repo *Repo, path string) {
+	ref := s.ref(r, repo)
+	if content, ok := repo.File(ref, path); ok {
+		writeJSON(w, http.StatusOK, githubContent(path, content))
+		return
+	}
+	entries := repo.dir(ref, path)
+	if len(entries) == 0 {
+		writeMessage(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	listing := []map[string]interface{}{}
+	for _, e := range entries {
+		entry := map[string]interface{}{"type": "dir", "name": e[strings.LastIndex(e, "/")+1:], "path": e}
+		if content, ok := repo.File(ref, e); ok {
+			entry = githubContent(e, content)
+			delete(entry, "content")
+			delete(entry, "encoding")
+		}
+		listing = append(listing, entry)
+	}
+	writeJSON(w, http.StatusOK, listing)
+}
+
+func githubContent(path, content string) map[string]interface{} {
+	name := path[strings.LastIndex(path, "/")+1:]
+	return map[string]interface{}{
+		"type":     "file",
+		"encoding": "base64",
+		"size":     len(content),
+		"name":     name,
+		"path":     path,
+		"content":  encodeBase64(content),
+		"sha":      blobSHA(content),
+	}
+}
+
+type githubAuthor struct {
+	Name  string    `json:"name"`
+	Email string    `json:"email"`
+	Date  time.Time `json:"date"`
+}
+
+func (s *GithubServer) putContents(w http.ResponseWriter, r *http.Request, repo *Repo, path string) {
+	var req struct {
+		Message   string        `json:"message"`
+		Content   []byte        `json:"content"`
+		SHA       string        `json:"sha"`
+		Branch    string        `json:"branch"`
+		Author    *githubAuthor `json:"author"`
+		Committer *githubAuthor `json:"committer"`
+	}
+	if _, err := decodeOptions(r, &req); err != nil {
+		writeMessage(w, http.StatusBadRequest, "Problems parsing JSON")
+		return
+	}
+	branch := req.Branch
+	if branch == "" {
+		branch = repo.DefaultBranch
+	}
+	head := repo.Head(branch)
+	if head == nil {
+		writeMessage(w, http.StatusNotFound, fmt.Sprintf("Branch %s not found", branch))
+		return
+	}
+	current, exists := head.Files[path]
+	switch {
+	case exists && req.SHA == "":
+		writeMessage(w, http.StatusUnprocessableEntity, `Invalid request.
+
+"sha" wasn't supplied.`)
+		return
+	case exists && req.SHA != blobSHA(current):
+		writeMessage(w, http.StatusConflict, fmt.Sprintf("%s does not match %s", path, req.SHA))
+		return
+	case !exists && req.SHA != "":
+		writeMessage(w, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	author := req.Author
+	if author == nil {
+		author = req.Committer
+	}
+	if author == nil {
+		author = &githubAuthor{}
+	}
+	c := s.commit(repo, head, req.Message, author.Name, author.Email)
+	c.Files[path] = string(req.Content)
+	repo.Branches[branch] = c.SHA
+
+	status := http.StatusCreated
+	if exists {
+		status = http.StatusOK
+	}
+	writeJSON(w, status, map[string]interface{}{
+		"content": githubContent(path, string(req.Content)),
+		"commit":  map[string]interface{}{"sha": c.SHA, "message": c.Message},
+	})
+}
+
+func (s *GithubServer) getCommit(w http.ResponseWriter, repo *Repo, ref string) {
+	c := repo.resolve(ref)
+	if c == nil {
+		writeMessage(w, http.StatusUnprocessableEntity, fmt.Sprintf("No commit found for SHA: %s", ref))
+		return
+	}
+	parents := []map[string]interface{}{}
+	if c.Parent != "" {
+		parents = append(parents, map[string]interface{}{"sha": c.Parent})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"sha":     c.SHA,
+		"parents": parents,
+		"commit": map[string]interface{}{
+			"message":   c.Message,
+			"committer": map[string]interface{}{"date": c.Date.Format(time.RFC3339)},
+			"tree":      map[string]interface{}{"sha": repo.tree(c)},
+		},
+	})
+}
+
+// githubGitCommit is a commit as returned by the Git Data API.
+func githubGitCommit(repo *Repo, c *Commit) map[string]interface{} {
+	parents := []map[string]interface{}{}
+	if c.Parent != "" {
+		parents = append(parents, map[string]interface{}{"sha": c.Parent})
+	}
+	return map[string]interface{}{
+		"sha":     c.SHA,
+		"message": c.Message,
+		"author":  map[string]interface{}{"name": c.AuthorName, "email": c.AuthorEmail},
+		"committer": map[string]interface{}{
+			"name":  c.AuthorName,
+			"email": c.AuthorEmail,
+			"date":  c.Date.Format(time.RFC3339),
+		},
+		"tree":    map[string]interface{}{"sha": repo.tree(c)},
+		"parents": parents,
+	}
+}
+
+func (s *GithubServer) getGitCommit(w http.ResponseWriter, repo *Repo, sha string) {
+	c := repo.Commits[sha]
+	if c == nil {
+		writeMessage(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	writeJSON(w, http.StatusOK, githubGitCommit(repo, c))
+}
+
+func (s *GithubServer) createTree(w http.ResponseWriter, r *http.Request, repo *Repo) {
+	var req struct {
+		BaseTree string `json:"base_tree"`
+		Tree     []struct {
+			Path    string  `json:"path"`
+			Mode    string  `json:"mode"`
+			Type    string  `json:"type"`
+			SHA     *string `json:"sha"`
+			Content *string `json:"content"`
+		} `json:"tree"`
+	}
+	if _, err := decodeOptions(r, &req); err != nil {
+		writeMessage(w, http.StatusBadRequest, "Problems parsing JSON")
+		return
+	}
+	base, ok := repo.trees[req.BaseTree]
+	if req.BaseTree != "" && !ok {
+		writeMessage(w, http.StatusUnprocessableEntity, "base_tree is not a valid tree oid")
+		return
+	}
+	// The tree is built as a commit that is never referenced.
+	c := &Commit{Files: map[string]string{}}
+	for path, content := range base {
+		c.Files[path] = content
+	}
+	for _, e := range req.Tree {
+		switch {
+		case e.SHA != nil && *e.SHA == "" || e.SHA == nil && e.Content == nil:
+			delete(c.Files, e.Path)
+		case e.Content != nil:
+			c.Files[e.Path] = *e.Content
+		default:
+			writeMessage(w, http.StatusUnprocessableEntity, "only content and deletions are supported")
+			return
+		}
+	}
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"sha": repo.tree(c)})
+}
+
+func (s *GithubServer) createGitCommit(w http.ResponseWriter, r *http.Request, repo *Repo) {
+	var req struct {
+		Message   string        `json:"message"`
+		Tree      string        `json:"tree"`
+		Parents   []string      `json:"parents"`
+		Author    *githubAuthor `json:"author"`
+		Committer *githubAuthor `json:"committer"`
+		Signature string        `json:"signature"`
+	}
+	if _, err := decodeOptions(r, &req); err != nil {
+		writeMessage(w, http.StatusBadRequest, "Problems parsing JSON")
+		return
+	}
+	files, ok := repo.trees[req.Tree]
+	if !ok {
+		writeMessage(w, http.StatusUnprocessableEntity, "Tree SHA does not exist")
+		return
+	}
+	if len(req.Parents) > 1 {
+		writeMessage(w, http.StatusUnprocessableEntity, "merge commits are not supported")
+		return
+	}
+	var parent *Commit
+	if len(req.Parents) == 1 {
+		if parent = repo.Commits[req.Parents[0]]; parent == nil {
+			writeMessage(w, http.StatusUnprocessableEntity, "Parent SHA does not exist or is not a commit object")
+			return
+		}
+	}
+	author := req.Author
+	if author == nil {
+		author = &githubAuthor{}
+	}
+	committer := req.Committer
+	if committer == nil {
+		committer = author
+	}
+	if req.Signature != "" && (req.Author == nil || author.Date.IsZero() || committer.Date.IsZero()) {
+		writeMessage(w, http.StatusUnprocessableEntity, "signed commits need an author and committer with dates")
+		return
+	}
+	c := s.commit(repo, parent, req.Message, author.Name, author.Email)
+	c.Files = map[string]string{}
+	for path, content := range files {
+		c.Files[path] = content
+	}
+	if !author.Date.IsZero() {
+		c.Date = author.Date
+	}
+	if req.Signature != "" {
+		c.Signature = req.Signature
+		c.Payload = gitCommitObject(req.Tree, req.Parents, author, committer, req.Message)
+	}
+	writeJSON(w, http.StatusCreated, githubGitCommit(repo, c))
+}
+
+// gitCommitObject is the git object of an unsigned commit, which GitHub
+// verifies signatures against.
+func gitCommitObject(tree string, parents []string, author, committer *githubAuthor, message string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "tree %s\n", tree)
+	for _, p := range parents {
+		fmt.Fprintf(&b, "parent %s\n", p)
+	}
+	for _, ident := range []struct {
+		role string
+		a    *githubAuthor
+	}{{"author", author}, {"committer", committer}} {
+		fmt.Fprintf(&b, "%s %s <%s> %d %s\n", ident.role, ident.a.Name, ident.a.Email, ident.a.Date.Unix(), ident.a.Date.Format("-0700"))
+	}
+	fmt.Fprintf(&b, "\n%s", message)
+	return b.String()
+}
+
+func (s *GithubServer) updateRef(w http.ResponseWriter, r *http.Request, repo *Repo, branch string) {
+	var req struct {
+		SHA   string `json:"sha"`
+		Force bool   `json:"force"`
+	}
+	if _, err := decodeOptions(r, &req); err != nil {
+		writeMessage(w, http.StatusBadRequest, "Problems parsing JSON")
+		return
+	}
+	current, ok := repo.Branches[branch]
+	switch {
+	case !ok:
+		writeMessage(w, http.StatusUnprocessableEntity, "Reference does not exist")
+	case repo.Commits[req.SHA] == nil:
+		writeMessage(w, http.StatusUnprocessableEntity, "Object does not exist")
+	case !req.Force && !repo.isAncestor(current, req.SHA):
+		writeMessage(w, http.StatusUnprocessableEntity, "Update is not a fast forward")
+	default:
+		repo.Branches[branch] = req.SHA
+		writeJSON(w, http.StatusOK, githubRef(branch, req.SHA))
+	}
+}
+
+func githubRef(branch, sha string) map[string]interface{} {
+	return map[string]interface{}{
+		"ref":    "refs/heads/" + branch,
+		"object": map[string]interface{}{"type": "commit", "sha": sha},
+	}
+}
+
+func (s *GithubServer) getRef(w http.ResponseWriter, repo *Repo, branch string) {
+	sha, ok := repo.Branches[branch]
+	if !ok {
+		writeMessage(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	writeJSON(w, http.StatusOK, githubRef(branch, sha))
+}
+
+func (s *GithubServer) listMatchingRefs(w http.ResponseWriter, r *http.Request, repo *Repo, prefix string) {
+	branches := repo.branchesWithPrefix(prefix)
+	start, end := githubPage(w, r, len(branches))
+	refs := []map[string]interface{}{}
+	for _, branch := range branches[start:end] {
+		refs = append(refs, githubRef(branch, repo.Branches[branch]))
+	}
+	writeJSON(w, http.StatusOK, refs)
+}
+
+// githubPage returns the range of the n items of a list on the page requested
+// by r, 30 items per page by default, and links the next page like GitHub.
+func githubPage(w http.ResponseWriter, r *http.Request, n int) (int, int) {
+	q := r.URL.Query()
+	perPage, _ := strconv.Atoi(q.Get("per_page"))
+	if perPage <= 0 {
+		perPage = 30
+	}
+	page, _ := strconv.Atoi(q.Get("page"))
+	if page <= 0 {
+		page = 1
+	}
+	start, end := (page-1)*perPage, page*perPage
+	if start > n {
+		start = n
+	}
+	if end > n {
+		end = n
+	}
+	if end < n {
+		q.Set("page", strconv.Itoa(page+1))
+		next := *r.URL
+		next.RawQuery = q.Encode()
+		w.Header().Set("Link", fmt.Sprintf(`<http://%s%s>; rel="next"`, r.Host, next.RequestURI()))
+	}
+	return start, end
+}
+
+func (s *GithubServer) deleteRef(w http.ResponseWriter, repo *Repo, branch string) {
+	if _, ok := repo.Branches[branch]; !ok {
+		writeMessage(w, http.StatusUnprocessableEntity, "Reference does not exist")
+		return
+	}
+	delete(repo.Branches, branch)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *GithubServer) createRef(w http.ResponseWriter, r *http.Request, repo *Repo) {
+	var req struct {
+		Ref string `json:"ref"`
+		SHA string `json:"sha"`
+	}
+	if _, err := decodeOptions(r, &req); err != nil {
+		writeMessage(w, http.StatusBadRequest, "Problems parsing JSON")
+		return
+	}
+	branch := strings.TrimPrefix(req.Ref, "refs/heads/")
+	switch {
+	case branch == req.Ref:
+		writeMessage(w, http.StatusUnprocessableEntity, "Reference name must start with refs/heads/")
+	case repo.Branches[branch] != "":
+		writeMessage(w, http.StatusUnprocessableEntity, "Reference already exists")
+	case repo.Commits[req.SHA] == nil:
+		writeMessage(w, http.StatusUnprocessableEntity, "Object does not exist")
+	default:
+		repo.Branches[branch] = req.SHA
+		writeJSON(w, http.StatusCreated, githubRef(branch, req.SHA))
+	}
+}
+
+func (s *GithubServer) createPull(w http.ResponseWriter, r *http.Request, repo *Repo) {
+	var req struct {
+		Title string `json:"title"`
+		Head  string `json:"head"`
+		Base  string `json:"base"`
+		Body  string `json:"body"`
+	}
+	options, err := decodeOptions(r, &req)
+	if err != nil {
+		writeMessage(w, http.StatusBadRequest, "Problems parsing JSON")
+		return
+	}
+	head, base := repo.Head(req.Head), repo.Head(req.Base)
+	switch {
+	case head == nil || base == nil:
+		writeMessage(w, http.StatusUnprocessableEntity, "Validation Failed: head and base must be existing branches")
+		return
+	case head.SHA == base.SHA:
+		writeMessage(w, http.StatusUnprocessableEntity, fmt.Sprintf("Validation Failed: No commits between %s and %s", req.Base, req.Head))
+		return
+	case repo.openPull(req.Head, req.Base) != nil:
+		writeMessage(w, http.StatusUnprocessableEntity, fmt.Sprintf("Validation Failed: A pull request already exists for %s.", req.Head))
+		return
+	}
+	for _, key := range []string{"title", "head", "base", "body"} {
+		delete(options, key)
+	}
+	number := len(repo.Pulls) + 1
+	pull := &Pull{
+		Number:  number,
+		Title:   req.Title,
+		Body:    req.Body,
+		Head:    req.Head,
+		Base:    req.Base,
+		URL:     fmt.Sprintf("%s%s/pull/%d", s.URL, repo.Name, number),
+		State:   PullOpen,
+		Options: options,
+	}
+	repo.Pulls = append(repo.Pulls, pull)
+	writeJSON(w, http.StatusCreated, githubPull(pull))
+}
+
+func githubPull(p *Pull) map[string]interface{} {
+	state := p.State
+	if state == PullMerged {
+		state = PullClosed
+	}
+	var mergedAt interface{}
+	if p.State == PullMerged {
+		mergedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	return map[string]interface{}{
+		"number":    p.Number,
+		"merged_at": mergedAt,
+		"html_url":  p.URL,
+		"title":     p.Title,
+		"body":      p.Body,
+		"state":     state,
+		"merged":    p.State == PullMerged,
+		"head":      map[string]interface{}{"ref": p.Head},
+		"base":      map[string]interface{}{"ref": p.Base},
+	}
+}
+
+func (s *GithubServer) listPulls(w http.ResponseWriter, r *http.Request, repo *Repo) {
+	q := r.URL.Query()
+	state := q.Get("state")
+	if state == "" {
+		state = PullOpen
+	}
+	// head is given as owner:branch.
+	head := q.Get("head")
+	if i := strings.Index(head, ":"); i >= 0 {
+		head = head[i+1:]
+	}
+	pulls := []map[string]interface{}{}
+	for _, p := range repo.Pulls {
+		open := p.State == PullOpen
+		switch {
+		case state == PullOpen && !open, state == PullClosed && open:
+		case head != "" && p.Head != head, q.Get("base") != "" && p.Base != q.Get("base"):
+		default:
+			pulls = append(pulls, githubPull(p))
+		}
+	}
+	writeJSON(w, http.StatusOK, pulls)
+}
+
+func (s *GithubServer) getPull(w http.ResponseWriter, repo *Repo, number string) {
+	n, _ := strconv.Atoi(number)
+	pull := repo.pull(n)
+	if pull == nil {
+		writeMessage(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	writeJSON(w, http.StatusOK, githubPull(pull))
+}
+
+func (s *GithubServer) editPull(w http.ResponseWriter, r *http.Request, repo *Repo, number string) {
+	n, _ := strconv.Atoi(number)
+	pull := repo.pull(n)
+	if pull == nil {
+		writeMessage(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	var req struct {
+		Title *string `json:"title"`
+		Body  *string `json:"body"`
+	}
+	if _, err := decodeOptions(r, &req); err != nil {
+		writeMessage(w, http.StatusBadRequest, "Problems parsing JSON")
+		return
+	}
+	if req.Title != nil {
+		pull.Title = *req.Title
+	}
+	if req.Body != nil {
+		pull.Body = *req.Body
+	}
+	writeJSON(w, http.StatusOK, githubPull(pull))
+}
+
+func (s *GithubServer) requestReviewers(w http.ResponseWriter, r *http.Request, repo *Repo, number string) {
+	n, _ := strconv.Atoi(number)
+	pull := repo.pull(n)
+	if pull == nil {
+		writeMessage(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	var req struct {
+		Reviewers     []string `json:"reviewers"`
+		TeamReviewers []string `json:"team_reviewers"`
+	}
+	if _, err := decodeOptions(r, &req); err != nil {
+		writeMessage(w, http.StatusBadRequest, "Problems parsing JSON")
+		return
+	}
+	pull.Reviewers = appendNew(pull.Reviewers, req.Reviewers...)
+	pull.TeamReviewers = appendNew(pull.TeamReviewers, req.TeamReviewers...)
+	writeJSON(w, http.StatusCreated, githubPull(pull))
+}
+
+func (s *GithubServer) createComment(w http.ResponseWriter, r *http.Request, repo *Repo, number string) {
+	n, _ := strconv.Atoi(number)
+	pull := repo.pull(n)
+	if pull == nil {
+		writeMessage(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	var req struct {
+		Body string `json:"body"`
+	}
+	if _, err := decodeOptions(r, &req); err != nil {
+		writeMessage(w, http.StatusBadRequest, "Problems parsing JSON")
+		return
+	}
+	pull.Comments = append(pull.Comments, req.Body)
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"id": len(pull.Comments), "body": req.Body})
+}
+
+func (s *GithubServer) createCheckRun(w http.ResponseWriter, r *http.Request, repo *Repo) {
+	if s.DisableChecks {
+		writeMessage(w, http.StatusForbidden, "Resource not accessible by personal access token")
+		return
+	}
+	var req struct {
+		Name       string `json:"name"`
+		HeadSHA    string `json:"head_sha"`
+		DetailsURL string `json:"details_url"`
+		Conclusion string `json:"conclusion"`
+	}
+	options, err := decodeOptions(r, &req)
+	if err != nil {
+		writeMessage(w, http.StatusBadRequest, "Problems parsing JSON")
+		return
+	}
+	if repo.Commits[req.HeadSHA] == nil {
+		writeMessage(w, http.StatusUnprocessableEntity, "No commit found for SHA: "+req.HeadSHA)
+		return
+	}
+	for _, key := range []string{"name", "head_sha", "details_url", "conclusion"} {
+		delete(options, key)
+	}
+	repo.Statuses = append(repo.Statuses, &Status{
+		SHA:       req.HeadSHA,
+		CheckRun:  true,
+		Context:   req.Name,
+		State:     req.Conclusion,
+		TargetURL: req.DetailsURL,
+		Options:   options,
+	})
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"id":         len(repo.Statuses),
+		"name":       req.Name,
+		"head_sha":   req.HeadSHA,
+		"conclusion": req.Conclusion,
+	})
+}
+
+func (s *GithubServer) createStatus(w http.ResponseWriter, r *http.Request, repo *Repo, sha string) {
+	var req struct {
+		State       string `json:"state"`
+		TargetURL   string `json:"target_url"`
+		Description string `json:"description"`
+		Context     string `json:"context"`
+	}
+	if _, err := decodeOptions(r, &req); err != nil {
+		writeMessage(w, http.StatusBadRequest, "Problems parsing JSON")
+		return
+	}
+	if repo.Commits[sha] == nil {
+		writeMessage(w, http.StatusUnprocessableEntity, "No commit found for SHA: "+sha)
+		return
+	}
+	repo.Statuses = append(repo.Statuses, &Status{
+		SHA:         sha,
+		Context:     req.Context,
+		State:       req.State,
+		Description: req.Description,
+		TargetURL:   req.TargetURL,
+	})
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"id":          len(repo.Statuses),
+		"state":       req.State,
+		"context":     req.Context,
+		"description": req.Description,
+		"target_url":  req.TargetURL,
+	})
+}
@@ -0,0 +1,487 @@
+package vcstest
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GitlabServer is a fake of the GitLab REST API endpoints used by
+// vcs.GitlabClient.
+type GitlabServer struct {
+	store
+	// URL is the base URL, to be passed to vcs.NewGitlabClient.
+	URL string
+	// Token, when set, is the only PRIVATE-TOKEN accepted.
+	Token string
+	// Users maps the usernames known to the server to their IDs.
+	Users map[string]int
+	// Milestones maps milestone titles to their IDs. They are shared by all
+	// projects, like group milestones.
+	Milestones map[string]int
+	// WebCommitSigning signs the commits created through the API, like
+	// instances with web commit signing enabled.
+	WebCommitSigning bool
+
+	server *httptest.Server
+}
+
+// webSignature is the signature of commits signed by the server.
+const webSignature = "gitlab-web-signature"
+
+// NewGitlabServer starts a fake GitLab server. Close it when done.
+func NewGitlabServer() *GitlabServer {
+	s := &GitlabServer{store: newStore()}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	s.URL = s.server.URL
+	return s
+}
+
+// Close shuts the server down.
+func (s *GitlabServer) Close() {
+	s.server.Close()
+}
+
+func (s *GitlabServer) handle(w http.ResponseWriter, r *http.Request) {
+	if s.Token != "" && r.Header.Get("PRIVATE-TOKEN") != s.Token {
+		writeMessage(w, http.StatusUnauthorized, "401 Unauthorized")
+		return
+	}
+	// Project IDs and file paths are escaped into single path segments.
+	var parts []string
+	for _, part := range strings.Split(strings.Trim(r.URL.EscapedPath(), "/"), "/") {
+		unescaped, err := url.PathUnescape(part)
+		if err != nil {
+			writeMessage(w, http.StatusBadRequest, "400 Bad Request")
+			return
+		}
+		parts = append(parts, unescaped)
+	}
+	if len(parts) == 3 && parts[0] == "api" && parts[1] == "v4" && parts[2] == "users" && r.Method == http.MethodGet {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.listUsers(w, r)
+		return
+	}
+	if len(parts) < 5 || parts[0] != "api" || parts[1] != "v4" || parts[2] != "projects" {
+		writeMessage(w, http.StatusNotFound, "404 Not Found")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	repo := s.project(parts[3])
+	if repo == nil {
+		writeMessage(w, http.StatusNotFound, "404 Project Not Found")
+		return
+	}
+	rest := parts[4:]
+	switch {
+	case len(rest) == 4 && rest[0] == "repository" && rest[1] == "files" && rest[3] == "raw" && r.Method == http.MethodGet:
+		s.getRawFile(w, r, repo, rest[2])
+	case len(rest) == 3 && rest[0] == "repository" && rest[1] == "commits" && r.Method == http.MethodGet:
+		s.getCommit(w, repo, rest[2])
+	case len(rest) == 4 && rest[0] == "repository" && rest[1] == "commits" && rest[3] == "signature" && r.Method == http.MethodGet:
+		s.getSignature(w, repo, rest[2])
+	case len(rest) == 2 && rest[0] == "repository" && rest[1] == "branches" && r.Method == http.MethodGet:
+		s.listBranches(w, r, repo)
+	case len(rest) == 3 && rest[0] == "repository" && rest[1] == "branches" && r.Method == http.MethodDelete:
+		s.deleteBranch(w, repo, rest[2])
+	case len(rest) == 2 && rest[0] == "repository" && rest[1] == "commits" && r.Method == http.MethodPost:
+		s.createCommit(w, r, repo)
+	case len(rest) == 1 && rest[0] == "merge_requests" && r.Method == http.MethodPost:
+		s.createMergeRequest(w, r, repo)
+	case len(rest) == 1 && rest[0] == "merge_requests" && r.Method == http.MethodGet:
+		s.listMergeRequests(w, r, repo)
+	case len(rest) == 2 && rest[0] == "merge_requests" && r.Method == http.MethodGet:
+		s.getMergeRequest(w, repo, rest[1])
+	case len(rest) == 2 && rest[0] == "merge_requests" && r.Method == http.MethodPut:
+		s.updateMergeRequest(w, r, repo, rest[1])
+	case len(rest) == 3 && rest[0] == "merge_requests" && rest[2] == "notes" && r.Method == http.MethodPost:
+		s.createNote(w, r, repo, rest[1])
+	case len(rest) == 2 && rest[0] == "statuses" && r.Method == http.MethodPost:
+		s.setStatus(w, r, repo, rest[1])
+	case len(rest) == 1 && rest[0] == "milestones" && r.Method == http.MethodGet:
+		s.listMilestones(w, r)
+	default:
+		writeMessage(w, http.StatusNotFound, "404 Not Found")
+	}
+}
+
+// project finds a repo by its path or numeric ID.
+func (s *GitlabServer) project(id string) *Repo {
+	if repo, ok := s.repos[id]; ok {
+		return repo
+	}
+	if n, err := strconv.Atoi(id); err == nil {
+		for _, repo := range s.repos {
+			if repo.ID == n {
+				return repo
+			}
+		}
+	}
+	return nil
+}
+
+// listUsers only supports looking users up by username.
+func (s *GitlabServer) listUsers(w http.ResponseWriter, r *http.Request) {
+	users := []map[string]interface{}{}
+	name := r.URL.Query().Get("username")
+	if id, ok := s.Users[name]; ok {
+		users = append(users, map[string]interface{}{"id": id, "username": name})
+	}
+	writeJSON(w, http.StatusOK, users)
+}
+
+// listMilestones only supports looking milestones up by title.
+func (s *GitlabServer) listMilestones(w http.ResponseWriter, r *http.Request) {
+	milestones := []map[string]interface{}{}
+	title := r.URL.Query().Get("title")
+	if id, ok := s.Milestones[title]; ok {
+		milestones = append(milestones, map[string]interface{}{"id": id, "title": title})
+	}
+	writeJSON(w, http.StatusOK, milestones)
+}
+
+func (s *GitlabServer) getRawFile(w http.ResponseWriter, r *http.Request, repo *Repo, path string) {
+	ref := r.URL.Query().Get("ref")
+	if ref == "" {
+		ref = repo.DefaultBranch
+	}
+	content, ok := repo.File(ref, path)
+	if !ok {
+		writeMessage(w, http.StatusNotFound, "404 File Not Found")
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte(content))
+}
+
+func gitlabCommit(c *Commit) map[string]interface{} {
+	parents := []string{}
+	if c.Parent != "" {
+		parents = append(parents, c.Parent)
+	}
+	return map[string]interface{}{
+		"id":             c.SHA,
+		"short_id":       c.SHA[:8],
+		"title":          strings.SplitN(c.Message, "\n", 2)[0],
+		"message":        c.Message,
+		"author_name":    c.AuthorName,
+		"author_email":   c.AuthorEmail,
+		"committed_date": c.Date.Format(time.RFC3339),
+		"parent_ids":     parents,
+	}
+}
+
+// listBranches supports searching for a name prefix with ^prefix.
+func (s *GitlabServer) listBranches(w http.ResponseWriter, r *http.Request, repo *Repo) {
+	search := r.URL.Query().Get("search")
+	if search != "" && !strings.HasPrefix(search, "^") {
+		writeMessage(w, http.StatusBadRequest, "only ^prefix searches are supported")
+		return
+	}
+	branches := []map[string]interface{}{}
+	for _, name := range repo.branchesWithPrefix(strings.TrimPrefix(search, "^")) {
+		branches = append(branches, map[string]interface{}{
+			"name":    name,
+			"default": name == repo.DefaultBranch,
+			"commit":  gitlabCommit(repo.Head(name)),
+		})
+	}
+	writeJSON(w, http.StatusOK, branches)
+}
+
+func (s *GitlabServer) deleteBranch(w http.ResponseWriter, repo *Repo, branch string) {
+	switch _, ok := repo.Branches[branch]; {
+	case !ok:
+		writeMessage(w, http.StatusNotFound, "404 Branch Not Found")
+	case branch == repo.DefaultBranch:
+		writeMessage(w, http.StatusBadRequest, "The default branch of a project cannot be deleted.")
+	default:
+		delete(repo.Branches, branch)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *GitlabServer) getCommit(w http.ResponseWriter, repo *Repo, ref string) {
+	c := repo.resolve(ref)
+	if c == nil {
+		writeMessage(w, http.StatusNotFound, "404 Commit Not Found")
+		return
+	}
+	writeJSON(w, http.StatusOK, gitlabCommit(c))
+}
+
+func (s *GitlabServer) createCommit(w http.ResponseWriter, r *http.Request, repo *Repo) {
+	var req struct {
+		Branch        string `json:"branch"`
+		CommitMessage string `json:"commit_message"`
+		StartBranch   string `json:"start_branch"`
+		AuthorName    string `json:"author_name"`
+		AuthorEmail   string `json:"author_email"`
+		Force         bool   `json:"force"`
+		Actions       []struct {
+			Action   string `json:"action"`
+			FilePath string `json:"file_path"`
+			Content  string `json:"content"`
+		} `json:"actions"`
+	}
+	if _, err := decodeOptions(r, &req); err != nil {
+		writeMessage(w, http.StatusBadRequest, "400 Bad Request")
+		return
+	}
+
+	// An existing branch is committed to unless force resets it to the start
+	// branch.
+	parent := repo.Head(req.Branch)
+	if parent == nil || req.Force {
+		start := req.StartBranch
+		if start == "" {
+			start = req.Branch
+		}
+		parent = repo.Head(start)
+		if parent == nil {
+			writeMessage(w, http.StatusBadRequest, fmt.Sprintf("A branch called '%s' does not exist", start))
+			return
+		}
+	}
+
+	c := s.commit(repo, parent, req.CommitMessage, req.AuthorName, req.AuthorEmail)
+	for _, a := range req.Actions {
+		_, exists := c.Files[a.FilePath]
+		switch {
+		case a.Action == "create" && exists:
+			delete(repo.Commits, c.SHA)
+			writeMessage(w, http.StatusBadRequest, "A file with this name already exists")
+			return
+		case (a.Action == "update" || a.Action == "delete") && !exists:
+			delete(repo.Commits, c.SHA)
+			writeMessage(w, http.StatusBadRequest, "A file with this name doesn't exist")
+			return
+		case a.Action == "create" || a.Action == "update":
+			c.Files[a.FilePath] = a.Content
+		case a.Action == "delete":
+			delete(c.Files, a.FilePath)
+		default:
+			delete(repo.Commits, c.SHA)
+			writeMessage(w, http.StatusBadRequest, fmt.Sprintf("actions[0][action] does not have a valid value: %s", a.Action))
+			return
+		}
+	}
+	if s.WebCommitSigning {
+		c.Signature = webSignature
+	}
+	repo.Branches[req.Branch] = c.SHA
+	writeJSON(w, http.StatusCreated, gitlabCommit(c))
+}
+
+func (s *GitlabServer) getSignature(w http.ResponseWriter, repo *Repo, sha string) {
+	c := repo.resolve(sha)
+	if c == nil || c.Signature == "" {
+		writeMessage(w, http.StatusNotFound, "404 Signature Not Found")
+		return
+	}
+	status := "unverified"
+	if c.Signature == webSignature {
+		status = "verified"
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"signature_type": "SSH", "verification_status": status})
+}
+
+func (s *GitlabServer) createMergeRequest(w http.ResponseWriter, r *http.Request, repo *Repo) {
+	var req struct {
+		Title        string `json:"title"`
+		Description  string `json:"description"`
+		SourceBranch string `json:"source_branch"`
+		TargetBranch string `json:"target_branch"`
+		ReviewerIDs  []int  `json:"reviewer_ids"`
+	}
+	options, err := decodeOptions(r, &req)
+	if err != nil {
+		writeMessage(w, http.StatusBadRequest, "400 Bad Request")
+		return
+	}
+	switch {
+	case repo.Head(req.SourceBranch) == nil:
+		writeJSON(w, http.StatusBadRequest, map[string][]string{"message": {fmt.Sprintf("Source branch %q does not exist", req.SourceBranch)}})
+		return
+	case repo.Head(req.TargetBranch) == nil:
+		writeJSON(w, http.StatusBadRequest, map[string][]string{"message": {fmt.Sprintf("Target branch %q does not exist", req.TargetBranch)}})
+		return
+	case req.SourceBranch == req.TargetBranch:
+		writeJSON(w, http.StatusBadRequest, map[string][]string{"message": {"You can't use same project/branch for source and target"}})
+		return
+	}
+	if existing := repo.openPull(req.SourceBranch, req.TargetBranch); existing != nil {
+		writeJSON(w, http.StatusConflict, map[string][]string{"message": {fmt.Sprintf("Another open merge request already exists for this source branch: !%d", existing.Number)}})
+		return
+	}
+	for _, key := range []string{"title", "description", "source_branch", "target_branch"} {
+		delete(options, key)
+	}
+	iid := len(repo.Pulls) + 1
+	mr := &Pull{
+		Number:    iid,
+		Title:     req.Title,
+		Body:      req.Description,
+		Head:      req.SourceBranch,
+		Base:      req.TargetBranch,
+		URL:       fmt.Sprintf("%s/%s/-/merge_requests/%d", s.URL, repo.Name, iid),
+		State:     PullOpen,
+		Reviewers: s.usernames(req.ReviewerIDs),
+		Options:   options,
+	}
+	repo.Pulls = append(repo.Pulls, mr)
+	writeJSON(w, http.StatusCreated, gitlabMergeRequest(repo, mr))
+}
+
+// gitlabStates maps pull states to merge request states.
+var gitlabStates = map[string]string{PullOpen: "opened", PullClosed: "closed", PullMerged: "merged"}
+
+func gitlabMergeRequest(repo *Repo, mr *Pull) map[string]interface{} {
+	return map[string]interface{}{
+		"id":            1000 + mr.Number,
+		"iid":           mr.Number,
+		"project_id":    repo.ID,
+		"title":         mr.Title,
+		"description":   mr.Body,
+		"source_branch": mr.Head,
+		"target_branch": mr.Base,
+		"state":         gitlabStates[mr.State],
+		"web_url":       mr.URL,
+	}
+}
+
+func (s *GitlabServer) listMergeRequests(w http.ResponseWriter, r *http.Request, repo *Repo) {
+	q := r.URL.Query()
+	mrs := []map[string]interface{}{}
+	for _, mr := range repo.Pulls {
+		switch {
+		case q.Get("state") != "" && q.Get("state") != "all" && q.Get("state") != gitlabStates[mr.State]:
+		case q.Get("source_branch") != "" && q.Get("source_branch") != mr.Head:
+		case q.Get("target_branch") != "" && q.Get("target_branch") != mr.Base:
+		default:
+			mrs = append(mrs, gitlabMergeRequest(repo, mr))
+		}
+	}
+	writeJSON(w, http.StatusOK, mrs)
+}
+
+func (s *GitlabServer) updateMergeRequest(w http.ResponseWriter, r *http.Request, repo *Repo, iid string) {
+	n, _ := strconv.Atoi(iid)
+	mr := repo.pull(n)
+	if mr == nil {
+		writeMessage(w, http.StatusNotFound, "404 Not found")
+		return
+	}
+	var req struct {
+		Title       *string `json:"title"`
+		Description *string `json:"description"`
+		ReviewerIDs *[]int  `json:"reviewer_ids"`
+	}
+	if _, err := decodeOptions(r, &req); err != nil {
+		writeMessage(w, http.StatusBadRequest, "400 Bad Request")
+		return
+	}
+	if req.Title != nil {
+		mr.Title = *req.Title
+	}
+	if req.Description != nil {
+		mr.Body = *req.Description
+	}
+	if req.ReviewerIDs != nil {
+		mr.Reviewers = s.usernames(*req.ReviewerIDs)
+	}
+	writeJSON(w, http.StatusOK, gitlabMergeRequest(repo, mr))
+}
+
+func (s *GitlabServer) getMergeRequest(w http.ResponseWriter, repo *Repo, iid string) {
+	n, _ := strconv.Atoi(iid)
+	mr := repo.pull(n)
+	if mr == nil {
+		writeMessage(w, http.StatusNotFound, "404 Not found")
+		return
+	}
+	reviewers := []map[string]interface{}{}
+	for _, name := range mr.Reviewers {
+		reviewers = append(reviewers, map[string]interface{}{"id": s.Users[name], "username": name})
+	}
+	resp := gitlabMergeRequest(repo, mr)
+	resp["reviewers"] = reviewers
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// usernames maps user IDs back to the names in Users.
+func (s *GitlabServer) usernames(ids []int) []string {
+	var names []string
+	for _, id := range ids {
+		for name, userID := range s.Users {
+			if userID == id {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+func (s *GitlabServer) createNote(w http.ResponseWriter, r *http.Request, repo *Repo, iid string) {
+	n, _ := strconv.Atoi(iid)
+	mr := repo.pull(n)
+	if mr == nil {
+		writeMessage(w, http.StatusNotFound, "404 Not found")
+		return
+	}
+	var req struct {
+		Body string `json:"body"`
+	}
+	if _, err := decodeOptions(r, &req); err != nil {
+		writeMessage(w, http.StatusBadRequest, "400 Bad Request")
+		return
+	}
+	mr.Comments = append(mr.Comments, req.Body)
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"id": len(mr.Comments), "body": req.Body})
+}
+
+func (s *GitlabServer) setStatus(w http.ResponseWriter, r *http.Request, repo *Repo, sha string) {
+	var req struct {
+		State       string `json:"state"`
+		Ref         string `json:"ref"`
+		Name        string `json:"name"`
+		TargetURL   string `json:"target_url"`
+		Description string `json:"description"`
+	}
+	options, err := decodeOptions(r, &req)
+	if err != nil {
+		writeMessage(w, http.StatusBadRequest, "400 Bad Request")
+		return
+	}
+	if repo.Commits[sha] == nil {
+		writeMessage(w, http.StatusNotFound, "404 Commit Not Found")
+		return
+	}
+	for _, key := range []string{"state", "name", "target_url", "description"} {
+		delete(options, key)
+	}
+	repo.Statuses = append(repo.Statuses, &Status{
+		SHA:         sha,
+		Context:     req.Name,
+		State:       req.State,
+		Description: req.Description,
+		TargetURL:   req.TargetURL,
+		Options:     options,
+	})
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"id":          len(repo.Statuses),
+		"sha":         sha,
+		"ref":         req.Ref,
+		"status":      req.State,
+		"name":        req.Name,
+		"target_url":  req.TargetURL,
+		"description": req.Description,
+	})
+}
@@ -0,0 +1,338 @@
+// Package vcstest provides in-memory fake GitHub and GitLab REST servers for
+// testing the VCS clients. Both track branches, commits with their files,
+// pull or merge requests with their comments, and commit statuses.
+package vcstest
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Repo is a repository held by a fake server. Tests may inspect it between
+// requests but must not modify it while requests are in flight.
+type Repo struct {
+	ID            int
+	Name          string
+	DefaultBranch string
+	// Branches maps branch names to their head commit SHA.
+	Branches map[string]string
+	Commits  map[string]*Commit
+	Pulls    []*Pull
+	Statuses []*Status
+
+	// trees holds the files of the tree objects handed out by the GitHub
+	// server, by tree SHA.
+	trees map[string]map[string]string
+}
+
+// Commit is a commit together with the complete file tree it results in.
+type Commit struct {
+	SHA         string
+	Parent      string
+	Message     string
+	AuthorName  string
+	AuthorEmail string
+	// Date is when the commit was made. Tests may backdate it.
+	Date  time.Time
+	Files map[string]string
+	// Signature is the signature a commit was created with, and Payload the
+	// commit object it must verify against.
+	Signature string
+	Payload   string
+}
+
+// Pull states.
+const (
+	PullOpen   = "open"
+	PullClosed = "closed"
+	PullMerged = "merged"
+)
+
+// Pull is a GitHub pull request or GitLab merge request.
+type Pull struct {
+	Number int
+	Title  string
+	Body   string
+	Head   string
+	Base   string
+	URL    string
+	// State is PullOpen for new pulls. Tests may close or merge them.
+	State    string
+	Comments []string
+	// Reviewers are the usernames reviews were requested from, and
+	// TeamReviewers the slugs of the GitHub teams.
+	Reviewers     []string
+	TeamReviewers []string
+	// Options holds the remaining fields of the create request as decoded
+	// JSON, for example squash or remove_source_branch on GitLab.
+	Options map[string]interface{}
+}
+
+// Status is a commit status, or a GitHub check run.
+type Status struct {
+	SHA string
+	// CheckRun is set for GitHub check runs.
+	CheckRun bool
+	// Context is the context of a status or the name of a check run.
+	Context string
+	// State is the state of a status or the conclusion of a check run.
+	State       string
+	Description string
+	TargetURL   string
+	// Options holds the remaining fields of the request as decoded JSON, for
+	// example the output of a check run.
+	Options map[string]interface{}
+}
+
+// Head returns the head commit of branch, or nil when it does not exist.
+func (r *Repo) Head(branch string) *Commit {
+	return r.Commits[r.Branches[branch]]
+}
+
+// File returns the content of path at ref, a branch name or commit SHA.
+func (r *Repo) File(ref, path string) (string, bool) {
+	c := r.resolve(ref)
+	if c == nil {
+		return "", false
+	}
+	content, ok := c.Files[path]
+	return content, ok
+}
+
+// dir returns the paths of the files and directories directly in the
+// directory path at ref, in order. The root directory is "".
+func (r *Repo) dir(ref, path string) []string {
+	c := r.resolve(ref)
+	if c == nil {
+		return nil
+	}
+	prefix := ""
+	if path != "" {
+		prefix = path + "/"
+	}
+	seen := map[string]bool{}
+	var entries []string
+	for file := range c.Files {
+		if !strings.HasPrefix(file, prefix) {
+			continue
+		}
+		entry := prefix + strings.SplitN(file[len(prefix):], "/", 2)[0]
+		if !seen[entry] {
+			seen[entry] = true
+			entries = append(entries, entry)
+		}
+	}
+	sort.Strings(entries)
+	return entries
+}
+
+// resolve returns the commit a branch name or full or abbreviated SHA refers
+// to.
+func (r *Repo) resolve(ref string) *Commit {
+	if sha, ok := r.Branches[ref]; ok {
+		return r.Commits[sha]
+	}
+	if c, ok := r.Commits[ref]; ok {
+		return c
+	}
+	if len(ref) >= 7 {
+		for sha, c := range r.Commits {
+			if strings.HasPrefix(sha, ref) {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Repo) openPull(head, base string) *Pull {
+	for _, p := range r.Pulls {
+		if p.Head == head && p.Base == base && p.State == PullOpen {
+			return p
+		}
+	}
+	return nil
+}
+
+func (r *Repo) pull(number int) *Pull {
+	for _, p := range r.Pulls {
+		if p.Number == number {
+			return p
+		}
+	}
+	return nil
+}
+
+// branchesWithPrefix returns the names of the branches starting with prefix
+// in order.
+func (r *Repo) branchesWithPrefix(prefix string) []string {
+	var names []string
+	for name := range r.Branches {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// tree registers the files of c as a tree object and returns its SHA.
+func (r *Repo) tree(c *Commit) string {
+	paths := make([]string, 0, len(c.Files))
+	for path := range c.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	h := sha1.New()
+	for _, path := range paths {
+		fmt.Fprintf(h, "%s\x00%s\x00", path, blobSHA(c.Files[path]))
+	}
+	sha := hex.EncodeToString(h.Sum(nil))
+	if r.trees == nil {
+		r.trees = map[string]map[string]string{}
+	}
+	r.trees[sha] = c.Files
+	return sha
+}
+
+// isAncestor reports whether the commit ancestor is reachable from sha.
+func (r *Repo) isAncestor(ancestor, sha string) bool {
+	for c := r.Commits[sha]; c != nil; c = r.Commits[c.Parent] {
+		if c.SHA == ancestor {
+			return true
+		}
+	}
+	return false
+}
+
+// store holds the repositories of a fake server.
+type store struct {
+	mu    sync.Mutex
+	repos map[string]*Repo
+	seq   int
+}
+
+func newStore() store {
+	return store{repos: map[string]*Repo{}}
+}
+
+// AddRepo creates a repository whose branch holds a single commit with
+// files.
+func (s *store) AddRepo(name, branch string, files map[string]string) *Repo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := &Repo{
+		ID:            len(s.repos) + 1,
+		Name:          name,
+		DefaultBranch: branch,
+		Branches:      map[string]string{},
+		Commits:       map[string]*Commit{},
+	}
+	c := s.commit(r, nil, "Initial commit", "", "")
+	for path, content := range files {
+		c.Files[path] = content
+	}
+	r.Branches[branch] = c.SHA
+	s.repos[name] = r
+	return r
+}
+
+// Push adds a commit changing files to branch of the repository called name,
+// as if pushed by someone else.
+func (s *store) Push(name, branch, message string, files map[string]string) *Commit {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.repos[name]
+	c := s.commit(r, r.Head(branch), message, "", "")
+	for path, content := range files {
+		c.Files[path] = content
+	}
+	r.Branches[branch] = c.SHA
+	return c
+}
+
+// Repo returns the repository called name, or nil.
+func (s *store) Repo(name string) *Repo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.repos[name]
+}
+
+// commit adds a commit on top of parent, copying its files. The caller must
+// hold the lock and point a branch at the commit.
+func (s *store) commit(r *Repo, parent *Commit, message, authorName, authorEmail string) *Commit {
+	s.seq++
+	sum := sha1.Sum([]byte(fmt.Sprintf("%d %s %s", s.seq, r.Name, message)))
+	c := &Commit{
+		SHA:         hex.EncodeToString(sum[:]),
+		Message:     message,
+		AuthorName:  authorName,
+		AuthorEmail: authorEmail,
+		Date:        time.Now().UTC().Truncate(time.Second),
+		Files:       map[string]string{},
+	}
+	if parent != nil {
+		c.Parent = parent.SHA
+		for path, content := range parent.Files {
+			c.Files[path] = content
+		}
+	}
+	r.Commits[c.SHA] = c
+	return c
+}
+
+func encodeBase64(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+// blobSHA is the git object ID of a file with content.
+func blobSHA(content string) string {
+	sum := sha1.Sum([]byte(fmt.Sprintf("blob %d\x00%s", len(content), content)))
+	return hex.EncodeToString(sum[:])
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeMessage(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"message": message})
+}
+
+// decodeOptions decodes a JSON request body into v and returns all of its
+// fields as a map as well.
+func decodeOptions(r *http.Request, v interface{}) (map[string]interface{}, error) {
+	var raw json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return nil, err
+	}
+	options := map[string]interface{}{}
+	return options, json.Unmarshal(raw, &options)
+}
+
+// appendNew appends the values that list does not hold yet.
+func appendNew(list []string, values ...string) []string {
+	for _, v := range values {
+		found := false
+		for _, l := range list {
+			found = found || l == v
+		}
+		if !found {
+			list = append(list, v)
+		}
+	}
+	return list
+}